@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+// Refresh tokens are revoked when users ask to be deleted, so that they have to
+// log in again to cancel the deletion
+func TestScheduledDeletionRevokesRefreshTokens(t *testing.T) {
+	s := newSpecTest(t)
+	s.user("alice")
+
+	credentials := map[string]string{"email": "alice@example.com", "password": "password"}
+	session := s.call("POST", "/api/login", "", credentials)
+	s.expect(session, 200)
+	accessToken, refreshToken := s.str(session, "token"), s.str(session, "refresh_token")
+
+	s.expect(s.call("DELETE", "/api/users/me", accessToken, map[string]string{"password": "password"}), 202)
+
+	s.expect(s.call("POST", "/api/refresh", refreshToken, nil), 401)
+	s.expect(s.call("GET", "/api/users/me/export", refreshToken, nil), 401)
+	s.expect(s.call("GET", "/api/users/me/subscription", refreshToken, nil), 401)
+}
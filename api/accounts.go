@@ -0,0 +1,193 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mawkler/go-web-server/auth"
+	"github.com/mawkler/go-web-server/database"
+)
+
+type deleteAccountRequest struct {
+	Password string `json:"password" validate:"required,maxbytes=72"`
+}
+
+type deleteAccountResponse struct {
+	DeleteAt time.Time `json:"delete_at"`
+}
+
+// Writes a problem response and returns false if the request is made with an
+// impersonation token, since account deletion and exports are only for the
+// account owner
+func rejectImpersonation(w http.ResponseWriter, r *http.Request) bool {
+	token, ok := authorizedTokenWithIssuer(w, r, "chirpy-access")
+	if !ok {
+		return false
+	}
+
+	if _, impersonating := auth.Impersonator(token); impersonating {
+		writeProblem(w, r, 403, codeForbidden, "Impersonation tokens can't be used to delete or export accounts")
+		return false
+	}
+
+	return true
+}
+
+// Schedules the authenticated user's account to be deleted once the grace
+// period has passed. Logging in again before then cancels the deletion
+func (cfg *APIConfig) HandlerDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if !rejectImpersonation(w, r) {
+		return
+	}
+
+	req := deleteAccountRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	correct, err := cfg.DB.CheckPassword(userID, req.Password)
+	if errors.Is(err, database.ErrNoPassword) {
+		writeProblem(w, r, 403, codeForbidden, "Set a password with `PATCH /api/users` before deleting your account")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to check password of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if !correct {
+		writeProblem(w, r, 403, codeForbidden, "Incorrect password")
+		return
+	}
+
+	deleteAt := time.Now().UTC().Add(cfg.accountDeletionGracePeriod)
+	if err := cfg.DB.ScheduleUserDeletion(userID, deleteAt); err != nil {
+		log.Printf("failed to schedule deletion of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if isCookieSession(r) {
+		clearSessionCookies(w)
+	}
+
+	writeResponse(deleteAccountResponse{DeleteAt: deleteAt}, 202, w)
+}
+
+// Called when the user logs in, since that cancels a scheduled deletion
+func (cfg *APIConfig) cancelAccountDeletion(userID int) {
+	canceled, err := cfg.DB.CancelUserDeletion(userID)
+	if err != nil {
+		log.Printf("failed to cancel deletion of user %d: %s", userID, err)
+		return
+	}
+
+	if canceled {
+		log.Printf("user %d logged in, canceling the deletion of their account", userID)
+	}
+}
+
+// Deletes the accounts whose grace period has ended, along with their media
+func (cfg *APIConfig) PurgeDeletedAccounts() {
+	ids, uploads, err := cfg.DB.PurgeDeletedUsers(time.Now())
+	if err != nil {
+		log.Printf("failed to delete accounts: %s", err)
+		return
+	}
+
+	for _, m := range uploads {
+		cfg.deleteBlobs(m.Key, m.ThumbnailKey)
+	}
+
+	for _, id := range ids {
+		log.Printf("deleted user %d", id)
+	}
+}
+
+// Writes everything stored about the authenticated user as a zip archive with
+// the data in `data.json` and the user's uploaded images in `media/`
+func (cfg *APIConfig) HandlerExportAccount(w http.ResponseWriter, r *http.Request) {
+	if !rejectImpersonation(w, r) {
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	export, err := cfg.DB.ExportUser(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		writeNotFound(w, r, fmt.Sprintf("User %d does not exist", userID))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to export user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	// Nothing can be written to the response after the archive has started, so
+	// failures past this point can only be logged
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%d.zip"`, userID))
+	w.Header().Set("Cache-Control", "no-store")
+
+	archive := zip.NewWriter(w)
+	if err := cfg.writeExportArchive(archive, export); err != nil {
+		log.Printf("failed to write export of user %d: %s", userID, err)
+		return
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("failed to write export of user %d: %s", userID, err)
+	}
+}
+
+func (cfg *APIConfig) writeExportArchive(archive *zip.Writer, export *database.UserExport) error {
+	file, err := archive.Create("data.json")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return err
+	}
+
+	for _, m := range export.Media {
+		if err := cfg.addBlob(archive, m.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cfg *APIConfig) addBlob(archive *zip.Writer, key string) error {
+	blob, err := cfg.blobs.Get(key)
+	if err != nil {
+		return fmt.Errorf("failed to get blob %s: %s", key, err)
+	}
+	defer blob.Close()
+
+	file, err := archive.Create("media/" + key)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, blob)
+	return err
+}
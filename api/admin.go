@@ -0,0 +1,178 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/mawkler/go-web-server/auth"
+	"github.com/mawkler/go-web-server/database"
+)
+
+func (cfg *APIConfig) isAdmin(userID int) (bool, error) {
+	role, err := cfg.DB.GetUserRole(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get role of user %d: %s", userID, err)
+	}
+
+	return role == database.RoleAdmin, nil
+}
+
+// Admins are moderators as well
+func (cfg *APIConfig) isModerator(userID int) (bool, error) {
+	isAdmin, err := cfg.isAdmin(userID)
+	if err != nil || isAdmin {
+		return isAdmin, err
+	}
+
+	role, err := cfg.DB.GetUserRole(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get role of user %d: %s", userID, err)
+	}
+
+	return role == database.RoleModerator, nil
+}
+
+// Requires an access token belonging to an admin. Impersonation tokens are
+// rejected, even if the impersonated user is an admin
+func (cfg *APIConfig) MiddlewareAdmin(next http.Handler) http.Handler {
+	return cfg.middlewarePrivileged(next, cfg.isAdmin, "Admin")
+}
+
+// Same as MiddlewareAdmin, but also lets moderators through
+func (cfg *APIConfig) MiddlewareModerator(next http.Handler) http.Handler {
+	return cfg.middlewarePrivileged(next, cfg.isModerator, "Moderator")
+}
+
+func (cfg *APIConfig) middlewarePrivileged(next http.Handler, isPrivileged func(userID int) (bool, error), role string) http.Handler {
+	return cfg.MiddlewareAuthorization(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := authorizedTokenWithIssuer(w, r, "chirpy-access")
+		if !ok {
+			return
+		}
+
+		if _, impersonating := auth.Impersonator(token); impersonating {
+			log.Printf("impersonation tokens can't be used for %s endpoints", strings.ToLower(role))
+			writeProblem(w, r, 403, codeForbidden, fmt.Sprintf("Impersonation tokens can't be used for %s endpoints", strings.ToLower(role)))
+			return
+		}
+
+		userID, ok := authorizedUserID(w, r)
+		if !ok {
+			return
+		}
+
+		privileged, err := isPrivileged(userID)
+		if err != nil {
+			log.Print(err)
+			writeInternalError(w, r)
+			return
+		}
+
+		if !privileged {
+			log.Printf("user %d lacks %s privileges", userID, strings.ToLower(role))
+			writeProblem(w, r, 403, codeForbidden, fmt.Sprintf("%s privileges required", role))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// Anything but reads, so that impersonating admins can't act as the user, e.g.
+// by posting chirps or sending messages
+func isWriteMethod(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// Logs a request made with an impersonation token and records it in the audit
+// log. Returns false if the request should be blocked
+func (cfg *APIConfig) auditImpersonatedRequest(token *jwt.Token, adminIDString string, r *http.Request) bool {
+	userIDString, _ := token.Claims.GetSubject()
+	userID, _ := strconv.Atoi(userIDString)
+	adminID, _ := strconv.Atoi(adminIDString)
+
+	blocked := cfg.blockImpersonatedWrites && isWriteMethod(r.Method)
+	action := "impersonated_request"
+	if blocked {
+		action = "impersonated_request_blocked"
+	}
+
+	log.Printf("[impersonation] admin %d as user %d: %s %s (blocked: %t)", adminID, userID, r.Method, r.URL.Path, blocked)
+
+	details := fmt.Sprintf("%s %s", r.Method, r.URL.Path)
+	if err := cfg.DB.RecordAuditEvent(adminID, userID, action, details); err != nil {
+		log.Printf("failed to record impersonated request in audit log: %s", err)
+	}
+
+	return !blocked
+}
+
+type impersonationResponse struct {
+	Token         string `json:"token"`
+	Impersonating bool   `json:"impersonating"`
+	ExpiresIn     int    `json:"expires_in_seconds"`
+	database.User
+}
+
+func (cfg *APIConfig) HandlerImpersonate(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeInvalidPathID(w, r)
+		return
+	}
+
+	adminID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		log.Printf("failed to get user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if user == nil {
+		writeNotFound(w, r, fmt.Sprintf("User %d does not exist", userID))
+		return
+	}
+
+	impersonationToken, err := auth.CreateImpersonationToken(userID, adminID, cfg.jwtSecret)
+	if err != nil {
+		log.Printf("failed to create impersonation token: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	log.Printf("[impersonation] admin %d started impersonating user %d", adminID, userID)
+	if err := cfg.DB.RecordAuditEvent(adminID, userID, "impersonation_started", ""); err != nil {
+		log.Printf("failed to record impersonation in audit log: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	res := impersonationResponse{
+		Token:         impersonationToken,
+		Impersonating: true,
+		ExpiresIn:     int(auth.ImpersonationExpiry.Seconds()),
+		User:          *user,
+	}
+	writeResponse(res, 200, w)
+}
+
+func (cfg *APIConfig) HandlerGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	events, err := cfg.DB.GetAuditLog()
+	if err != nil {
+		log.Printf("failed to get audit log: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(events, 200, w)
+}
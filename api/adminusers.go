@@ -0,0 +1,223 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/mawkler/go-web-server/database"
+)
+
+type accountStateResponse struct {
+	IsAdmin bool `json:"is_admin"`
+	database.AccountState
+}
+
+type suspendUserRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+type setRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+func (cfg *APIConfig) HandlerSearchUsers(w http.ResponseWriter, r *http.Request) {
+	params, fieldErrors := parsePageParams(r)
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		fieldErrors = append(fieldErrors, fieldError{Field: "q", Code: "required", Message: "Query must not be empty"})
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	users, err := cfg.DB.SearchUsers(query)
+	if err != nil {
+		log.Printf("failed to search users: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writePage(w, r, users, userID, params)
+}
+
+func (cfg *APIConfig) HandlerGetAccountState(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	cfg.writeAccountState(w, r, user.ID)
+}
+
+func (cfg *APIConfig) writeAccountState(w http.ResponseWriter, r *http.Request, userID int) {
+	state, err := cfg.DB.GetAccountState(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		writeNotFound(w, r, fmt.Sprintf("User %d does not exist", userID))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get account state of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(accountStateResponse{IsAdmin: state.Role == database.RoleAdmin, AccountState: *state}, 200, w)
+}
+
+// Records an admin action on the user in the audit log and responds with the
+// user's new account state
+func (cfg *APIConfig) finishAdminAction(w http.ResponseWriter, r *http.Request, adminID, userID int, action, details string) {
+	log.Printf("admin %d: %s for user %d", adminID, action, userID)
+	if err := cfg.DB.RecordAuditEvent(adminID, userID, action, details); err != nil {
+		log.Printf("failed to record %s in audit log: %s", action, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	cfg.writeAccountState(w, r, userID)
+}
+
+// Returns the admin's ID and the path user. Admins can't use the actions that
+// would lock themselves out on their own account
+func (cfg *APIConfig) adminActionUsers(w http.ResponseWriter, r *http.Request, allowSelf bool) (int, *database.User, bool) {
+	adminID, ok := authorizedUserID(w, r)
+	if !ok {
+		return 0, nil, false
+	}
+
+	user, ok := cfg.pathUser(w, r)
+	if !ok {
+		return 0, nil, false
+	}
+
+	if !allowSelf && user.ID == adminID {
+		writeProblem(w, r, 403, codeForbidden, "Admins can't do this to their own account")
+		return 0, nil, false
+	}
+
+	return adminID, user, true
+}
+
+// Suspends the user and ends their sessions. Their access tokens are rejected
+// until they're reactivated
+func (cfg *APIConfig) HandlerSuspendUser(w http.ResponseWriter, r *http.Request) {
+	req := suspendUserRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	adminID, user, ok := cfg.adminActionUsers(w, r, false)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.SuspendUser(user.ID, adminID, req.Reason); err != nil {
+		log.Printf("failed to suspend user %d: %s", user.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	cfg.finishAdminAction(w, r, adminID, user.ID, "user_suspended", req.Reason)
+}
+
+func (cfg *APIConfig) HandlerReactivateUser(w http.ResponseWriter, r *http.Request) {
+	adminID, user, ok := cfg.adminActionUsers(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.ReactivateUser(user.ID); err != nil {
+		log.Printf("failed to reactivate user %d: %s", user.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	cfg.finishAdminAction(w, r, adminID, user.ID, "user_reactivated", "")
+}
+
+// Removes the user's password, ends their sessions and mails them a login link
+// so that they can set a new password
+func (cfg *APIConfig) HandlerForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	adminID, user, ok := cfg.adminActionUsers(w, r, false)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.ResetPassword(user.ID); err != nil {
+		log.Printf("failed to reset password of user %d: %s", user.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	intro := "An admin has reset your Chirpy password and logged you out everywhere. " +
+		"Click the link below to log in, then set a new password with `PATCH /api/users`."
+	if err := cfg.sendMagicLink(user.Email, "Reset your Chirpy password", intro); err != nil {
+		log.Print(err)
+		writeInternalError(w, r)
+		return
+	}
+
+	cfg.finishAdminAction(w, r, adminID, user.ID, "password_reset_forced", "")
+}
+
+func (cfg *APIConfig) HandlerSetUserRole(w http.ResponseWriter, r *http.Request) {
+	req := setRoleRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.Role != database.RoleUser && req.Role != database.RoleModerator && req.Role != database.RoleAdmin {
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "role",
+			Code:    "invalid_value",
+			Message: fmt.Sprintf("Must be %q, %q or %q", database.RoleUser, database.RoleModerator, database.RoleAdmin),
+		}})
+		return
+	}
+
+	adminID, user, ok := cfg.adminActionUsers(w, r, false)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.SetUserRole(user.ID, req.Role); err != nil {
+		log.Printf("failed to set role of user %d: %s", user.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	cfg.finishAdminAction(w, r, adminID, user.ID, "role_changed", req.Role)
+}
+
+func (cfg *APIConfig) HandlerGrantChirpyRed(w http.ResponseWriter, r *http.Request) {
+	cfg.setChirpyRed(w, r, true)
+}
+
+func (cfg *APIConfig) HandlerRevokeChirpyRed(w http.ResponseWriter, r *http.Request) {
+	cfg.setChirpyRed(w, r, false)
+}
+
+func (cfg *APIConfig) setChirpyRed(w http.ResponseWriter, r *http.Request, isChirpyRed bool) {
+	adminID, user, ok := cfg.adminActionUsers(w, r, true)
+	if !ok {
+		return
+	}
+
+	change := database.SubscriptionChange{Type: database.SubscriptionEventRevoked, ActorID: &adminID}
+	action := "chirpy_red_revoked"
+	if isChirpyRed {
+		change.Type = database.SubscriptionEventGranted
+		action = "chirpy_red_granted"
+	}
+
+	if _, err := cfg.DB.UpdateSubscription(user.ID, change); err != nil {
+		log.Printf("failed to set Chirpy Red of user %d: %s", user.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	cfg.finishAdminAction(w, r, adminID, user.ID, action, "")
+}
@@ -1,9 +1,12 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -11,162 +14,334 @@ import (
 
 	"github.com/mawkler/go-web-server/auth"
 	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/mail"
 )
 
-func (cfg *APIConfig) HandlerLogin(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		ExpiresInSeconds *int   `json:"expires_in_seconds"`
-		Email            string `json:"email"`
-		Password         string `json:"password"`
+type loginResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	database.User
+}
+
+// Issues an access token and a refresh token for the user
+func (cfg *APIConfig) createSession(user *database.User, expiresInSeconds *int) (*loginResponse, error) {
+	sessionID, err := auth.CreateSessionID()
+	if err != nil {
+		return nil, err
 	}
 
-	type response struct {
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-		database.User
+	accessToken, err := auth.CreateAccessToken(user.ID, sessionID, cfg.jwtSecret, expiresInSeconds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create access token: %s", err)
 	}
 
-	req := request{}
-	err := json.NewDecoder(r.Body).Decode(&req)
+	refreshToken, err := auth.CreateRefreshToken(user.ID, sessionID, cfg.jwtSecret)
 	if err != nil {
-		writeResponse(errResponse{Error: "Invalid JSON body"}, 400, w)
+		return nil, fmt.Errorf("failed to create refresh token: %s", err)
+	}
+
+	if err := cfg.DB.SaveRefreshToken(refreshToken, user.ID, time.Hour*24*60); err != nil {
+		return nil, fmt.Errorf("failed to save refresh token: %s", err)
+	}
+
+	return &loginResponse{
+		User:         *user,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+type loginRequest struct {
+	ExpiresInSeconds *int   `json:"expires_in_seconds,omitempty" validate:"min=1"`
+	Email            string `json:"email" validate:"required,email"`
+	Password         string `json:"password" validate:"required"`
+	UseCookies       bool   `json:"use_cookies,omitempty"`
+}
+
+func (cfg *APIConfig) HandlerLogin(w http.ResponseWriter, r *http.Request) {
+	req := loginRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ip := clientIP(r, cfg.trustedProxies)
+	if retryAfter := cfg.loginThrottle.retryAfter(ip, time.Now()); retryAfter > 0 {
+		log.Printf("Unauthenticated, too many failed logins from %s", ip)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		writeProblem(w, r, 429, codeTooManyFailedLogins, "Too many failed logins, try again later")
 		return
 	}
 
 	user, err := cfg.DB.Login(req.Email, req.Password)
+	if errors.Is(err, database.ErrUserSuspended) {
+		log.Printf("Unauthenticated: %s", err)
+		writeProblem(w, r, 403, codeAccountSuspended, "Account is suspended")
+		return
+	}
 	if err != nil {
 		log.Printf("Unauthenticated: %s", err)
-		w.WriteHeader(401)
+		cfg.loginThrottle.recordFailure(ip, time.Now())
+		writeProblem(w, r, 401, codeUnauthorized, "Incorrect email or password")
 		return
 
 	}
 
 	if user == nil {
 		log.Printf("Unauthenticated, user %s does not exist", req.Email)
-		w.WriteHeader(401)
+		cfg.loginThrottle.recordFailure(ip, time.Now())
+		writeProblem(w, r, 401, codeUnauthorized, "Incorrect email or password")
 		return
 	}
 
-	accessToken, err := auth.CreateAccessToken(user.ID, cfg.jwtSecret, req.ExpiresInSeconds)
+	cfg.cancelAccountDeletion(user.ID)
+
+	res, err := cfg.createSession(user, req.ExpiresInSeconds)
 	if err != nil {
-		log.Printf("Failed to create access token: %s", err)
-		w.WriteHeader(401)
+		log.Print(err)
+		writeInternalError(w, r)
 		return
 	}
 
-	refreshToken, err := auth.CreateRefreshToken(user.ID, cfg.jwtSecret)
-	if err != nil {
-		log.Printf("Failed to create refresh token: %s", err)
-		w.WriteHeader(401)
+	if req.UseCookies {
+		if err := cfg.startCookieSession(w, res, req.ExpiresInSeconds); err != nil {
+			log.Print(err)
+			writeInternalError(w, r)
+			return
+		}
+		writeResponse(res.User, 200, w)
 		return
 	}
 
-	cfg.DB.SaveRefreshToken(refreshToken, user.ID, time.Hour*24*60)
+	writeResponse(res, 200, w)
+}
 
-	res := response{
-		User:         database.User{Email: user.Email, ID: user.ID, IsChirpyRed: user.IsChirpyRed},
-		Token:        accessToken,
-		RefreshToken: refreshToken,
+// Puts the tokens in cookies instead of the response body, so that they're out
+// of reach for JavaScript
+func (cfg *APIConfig) startCookieSession(w http.ResponseWriter, res *loginResponse, expiresInSeconds *int) error {
+	csrfToken, err := auth.CreateCSRFToken()
+	if err != nil {
+		return err
 	}
-	writeResponse(res, 200, w)
+
+	accessTokenExpiry := time.Hour
+	if expiresInSeconds != nil {
+		accessTokenExpiry = time.Duration(*expiresInSeconds) * time.Second
+	}
+
+	setSessionCookies(w, res.Token, res.RefreshToken, csrfToken, accessTokenExpiry)
+	return nil
 }
 
-func (cfg *APIConfig) HandlerRefresh(w http.ResponseWriter, r *http.Request) {
-	type response struct {
-		Token string `json:"token"`
+type magicLinkRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+func (cfg *APIConfig) HandlerMagicLinkLogin(w http.ResponseWriter, r *http.Request) {
+	req := magicLinkRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
 	}
 
-	token, ok := r.Context().Value(authorizedJWTKey).(*jwt.Token)
-	if !ok || token == nil {
-		log.Printf("context does not contain authorized access token")
-		w.WriteHeader(500)
+	if err := cfg.sendMagicLink(req.Email, "Your Chirpy login link", "Click the link below to log in to Chirpy."); err != nil {
+		log.Print(err)
+		writeInternalError(w, r)
 		return
 	}
 
+	// Respond the same way regardless of whether the account exists
+	w.WriteHeader(202)
+}
+
+// Mails a single-use login link to email, with intro above it
+func (cfg *APIConfig) sendMagicLink(email, subject, intro string) error {
+	token, err := auth.CreateMagicLinkToken(email, cfg.jwtSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create magic link token: %s", err)
+	}
+
+	if err := cfg.DB.SaveMagicLink(token, email, auth.MagicLinkExpiry); err != nil {
+		return fmt.Errorf("failed to save magic link: %s", err)
+	}
+
+	link := fmt.Sprintf("%s/api/login/magic/verify?token=%s", cfg.baseURL, url.QueryEscape(token))
+	msg := mail.Message{
+		To:      email,
+		Subject: subject,
+		Body: fmt.Sprintf(
+			"%s It expires in %d minutes and can only be used once.\n\n%s",
+			intro, int(auth.MagicLinkExpiry.Minutes()), link,
+		),
+	}
+	if err := cfg.mailer.Send(msg); err != nil {
+		return fmt.Errorf("failed to send magic link to %s: %s", email, err)
+	}
+
+	return nil
+}
+
+// Writes a problem response and returns nil if the request's token isn't a
+// valid magic link token
+func (cfg *APIConfig) magicLinkToken(w http.ResponseWriter, r *http.Request) *jwt.Token {
+	token, err := auth.Authorize(r.URL.Query().Get("token"), cfg.jwtSecret)
+	if err != nil {
+		log.Printf("Invalid magic link: %s", err)
+		writeProblem(w, r, 401, codeInvalidToken, "Invalid or expired login link")
+		return nil
+	}
+
 	issuer, err := token.Claims.GetIssuer()
+	if err != nil || issuer != "chirpy-magic" {
+		log.Printf("jwt is not a magic link token")
+		writeProblem(w, r, 401, codeWrongTokenType, "Token is not a login link")
+		return nil
+	}
+
+	return token
+}
+
+var magicLinkConfirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+  <body>
+    <h1>Log in to Chirpy</h1>
+    <form method="post" action="/api/login/magic/verify?token={{.}}">
+      <button type="submit">Log in</button>
+    </form>
+  </body>
+</html>
+`))
+
+// Asks the user to confirm the login rather than using up the link, since mail
+// scanners open links to check them
+func (cfg *APIConfig) HandlerMagicLinkConfirm(w http.ResponseWriter, r *http.Request) {
+	token := cfg.magicLinkToken(w, r)
+	if token == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := magicLinkConfirmPage.Execute(w, token.Raw); err != nil {
+		log.Printf("failed to render magic link confirmation page: %s", err)
+	}
+}
+
+func (cfg *APIConfig) HandlerMagicLinkVerify(w http.ResponseWriter, r *http.Request) {
+	token := cfg.magicLinkToken(w, r)
+	if token == nil {
+		return
+	}
+
+	link, err := cfg.DB.ConsumeMagicLink(token.Raw)
+	if err != nil {
+		log.Printf("failed to consume magic link: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if link == nil {
+		log.Print("magic link doesn't exist or has already been used")
+		writeProblem(w, r, 401, codeInvalidToken, "Login link has already been used")
+		return
+	}
+
+	user, err := cfg.DB.GetOrCreateUserByEmail(link.Email)
+	if err != nil {
+		log.Printf("failed to get or create user %s: %s", link.Email, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	suspended, err := cfg.DB.IsSuspended(user.ID)
+	if err != nil {
+		log.Printf("failed to check suspension of user %d: %s", user.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+	if suspended {
+		log.Printf("user %d is suspended", user.ID)
+		writeProblem(w, r, 403, codeAccountSuspended, "Account is suspended")
+		return
+	}
+
+	cfg.cancelAccountDeletion(user.ID)
+
+	res, err := cfg.createSession(user, nil)
 	if err != nil {
-		log.Printf("failed to get issuer from refresh token: %s", err)
-		w.WriteHeader(401)
+		log.Print(err)
+		writeInternalError(w, r)
 		return
 	}
 
-	if issuer != "chirpy-refresh" {
-		log.Printf("jwt is not a refresh token")
-		w.WriteHeader(401)
+	writeResponse(res, 200, w)
+}
+
+type refreshResponse struct {
+	Token string `json:"token"`
+}
+
+func (cfg *APIConfig) HandlerRefresh(w http.ResponseWriter, r *http.Request) {
+	token, ok := authorizedTokenWithIssuer(w, r, "chirpy-refresh")
+	if !ok {
 		return
 	}
 
 	refreshToken, err := cfg.DB.GetRefreshToken(token.Raw)
 	if err != nil {
 		log.Printf("failed to get refresh token from database: %s", err)
-		w.WriteHeader(500)
+		writeInternalError(w, r)
 		return
 	}
 
 	if refreshToken == nil {
 		log.Print("refresh token doesn't exist")
-		w.WriteHeader(401)
+		writeProblem(w, r, 401, codeInvalidToken, "Refresh token has been revoked")
 		return
 	}
 
 	tokenIsExpired := refreshToken.ExpiresAt.Before(time.Now())
 	if tokenIsExpired {
 		log.Print("refresh token has expired")
-		w.WriteHeader(401)
+		writeProblem(w, r, 401, codeInvalidToken, "Refresh token has expired")
 		return
 	}
 
-	userID, err := token.Claims.GetSubject()
-	if err != nil {
-		log.Printf("token has no subject: %s", err)
-		w.WriteHeader(401)
+	id, ok := authorizedUserID(w, r)
+	if !ok {
 		return
 	}
 
-	id, err := strconv.Atoi(userID)
+	expiresInSeconds := 60 * 60
+	sessionID := auth.GetClaims(token).SessionID
+	newRefreshToken, err := auth.CreateAccessToken(id, sessionID, cfg.jwtSecret, &expiresInSeconds)
 	if err != nil {
-		log.Print("JWT subject (user ID) is non-numeric")
-		w.WriteHeader(401)
+		log.Printf("failed to create access token: %s", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	expiresInSeconds := 60 * 60
-	newRefreshToken, err := auth.CreateAccessToken(id, cfg.jwtSecret, &expiresInSeconds)
-	if err != nil {
-		log.Printf("failed to create access token: %s", err)
-		w.WriteHeader(401)
+	if isCookieSession(r) {
+		setAccessTokenCookie(w, newRefreshToken, time.Duration(expiresInSeconds)*time.Second)
+		w.WriteHeader(204)
 		return
 	}
 
-	writeResponse(response{Token: newRefreshToken}, 200, w)
+	writeResponse(refreshResponse{Token: newRefreshToken}, 200, w)
 }
 
 func (cfg *APIConfig) HandlerRevoke(w http.ResponseWriter, r *http.Request) {
-	token, ok := r.Context().Value(authorizedJWTKey).(*jwt.Token)
+	token, ok := authorizedTokenWithIssuer(w, r, "chirpy-refresh")
 	if !ok {
-		log.Printf("context does not contain authorized access token")
-		w.WriteHeader(500)
-		return
-	}
-
-	issuer, err := token.Claims.GetIssuer()
-	if err != nil {
-		log.Printf("failed to get issuer from refresh token: %s", err)
-		w.WriteHeader(401)
-		return
-	}
-
-	if issuer != "chirpy-refresh" {
-		log.Printf("jwt is not a refresh token")
-		w.WriteHeader(401)
 		return
 	}
 
 	if err := cfg.DB.DeleteRefreshToken(token.Raw); err != nil {
 		log.Printf("failed to revoke refresh token %s: %s", token.Raw, err)
-		w.WriteHeader(500)
+		writeInternalError(w, r)
 		return
 	}
 
+	if isCookieSession(r) {
+		clearSessionCookies(w)
+	}
+
 	w.WriteHeader(204)
 }
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/mawkler/go-web-server/database"
+)
+
+// Handles blocking, unblocking, muting and unmuting the user in the `id` path
+// parameter, which are all idempotent
+func (cfg *APIConfig) handleRelationship(action string, update func(userID, otherUserID int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authorizedUserID(w, r)
+		if !ok {
+			return
+		}
+
+		other, ok := cfg.pathUser(w, r)
+		if !ok {
+			return
+		}
+
+		if other.ID == userID {
+			writeProblem(w, r, 400, codeInvalidParameter, "You can't "+action+" yourself")
+			return
+		}
+
+		if err := update(userID, other.ID); err != nil {
+			log.Printf("user %d failed to %s user %d: %s", userID, action, other.ID, err)
+			writeInternalError(w, r)
+			return
+		}
+
+		w.WriteHeader(204)
+	}
+}
+
+func (cfg *APIConfig) HandlerBlock(w http.ResponseWriter, r *http.Request) {
+	cfg.handleRelationship("block", cfg.DB.Block)(w, r)
+}
+
+func (cfg *APIConfig) HandlerUnblock(w http.ResponseWriter, r *http.Request) {
+	cfg.handleRelationship("unblock", cfg.DB.Unblock)(w, r)
+}
+
+func (cfg *APIConfig) HandlerMute(w http.ResponseWriter, r *http.Request) {
+	cfg.handleRelationship("mute", cfg.DB.Mute)(w, r)
+}
+
+func (cfg *APIConfig) HandlerUnmute(w http.ResponseWriter, r *http.Request) {
+	cfg.handleRelationship("unmute", cfg.DB.Unmute)(w, r)
+}
+
+// Lists the users that the authenticated user has blocked or muted
+func (cfg *APIConfig) writeRelationshipList(w http.ResponseWriter, r *http.Request, getUsers func(int) ([]database.User, error)) {
+	params, fieldErrors := parsePageParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	ownerID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	users, err := getUsers(ownerID)
+	if err != nil {
+		log.Printf("failed to get blocked or muted users of user %d: %s", ownerID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	total := len(users)
+	pageItems, next, prev := paginate(users, userID, params)
+	cfg.writeUserPageItems(w, r, pageItems, next, prev, params, &total)
+}
+
+func (cfg *APIConfig) HandlerGetBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	cfg.writeRelationshipList(w, r, cfg.DB.GetBlockedUsers)
+}
+
+func (cfg *APIConfig) HandlerGetMutedUsers(w http.ResponseWriter, r *http.Request) {
+	cfg.writeRelationshipList(w, r, cfg.DB.GetMutedUsers)
+}
+
+// Returns the users whose chirps the viewer shouldn't see, which is nil for
+// unauthenticated requests. Writes a problem response and returns false if
+// they can't be looked up
+func (cfg *APIConfig) viewerHiddenUserIDs(w http.ResponseWriter, r *http.Request) (map[int]bool, bool) {
+	viewerID, ok := viewerID(r)
+	if !ok {
+		return nil, true
+	}
+
+	hidden, err := cfg.DB.GetHiddenUserIDs(viewerID)
+	if err != nil {
+		log.Printf("failed to get users hidden from user %d: %s", viewerID, err)
+		writeInternalError(w, r)
+		return nil, false
+	}
+
+	return hidden, true
+}
+
+func withoutHiddenAuthors(chirps []database.Chirp, hidden map[int]bool) []database.Chirp {
+	return slices.DeleteFunc(chirps, func(chirp database.Chirp) bool {
+		return hidden[chirp.AuthorID]
+	})
+}
@@ -1,142 +1,340 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/golang-jwt/jwt/v5"
+	"time"
 
 	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/events"
+	"github.com/mawkler/go-web-server/moderation"
 )
 
-func cleanMessage(msg string) string {
-	profanities := []string{"kerfuffle", "sharbert", "fornax"}
-	newWords := []string{}
+// The rules for a chirp's body, shared by chirp validation and creation. The
+// maximum length depends on the author's plan, so it's checked separately
+type chirpRequest struct {
+	Body string `json:"body" validate:"required"`
+}
 
-	for _, w := range strings.Split(msg, " ") {
-		newWord := w
-		for _, p := range profanities {
-			if strings.ToLower(w) == p {
-				newWord = "****"
-			}
+type createChirpRequest struct {
+	chirpRequest
+	InReplyToID *int `json:"in_reply_to_id,omitempty"`
+	// IDs of media that the author has uploaded
+	AttachmentIDs []int `json:"attachment_ids,omitempty" validate:"max=4"`
+}
+
+type validateChirpResponse struct {
+	CleanedBody string             `json:"cleaned_body"`
+	Action      moderation.Action  `json:"action,omitempty"`
+	Matches     []moderation.Match `json:"matches"`
+}
+
+func writeChirpRejected(w http.ResponseWriter, r *http.Request, result moderation.Result) {
+	p := newProblem(r, 422, codeChirpRejected, "Chirp contains words that aren't allowed")
+	for _, match := range result.Matches {
+		if match.Action == moderation.ActionReject {
+			p.Errors = append(p.Errors, fieldError{
+				Field:   "body",
+				Code:    "prohibited_word",
+				Message: fmt.Sprintf("%q at position %d is not allowed", match.Text, match.Start),
+			})
 		}
-		newWords = append(newWords, newWord)
+	}
+	sendProblem(p, w)
+}
+
+// Writes a validation problem and returns false if the chirp is longer than
+// the user's plan allows
+func (cfg *APIConfig) checkChirpLength(w http.ResponseWriter, r *http.Request, userID int, body string) bool {
+	userEntitlements, err := cfg.entitlements(userID)
+	if err != nil {
+		log.Printf("failed to get entitlements of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return false
+	}
+
+	if fieldErr := userEntitlements.chirpLengthError(body); fieldErr != nil {
+		writeValidationProblem(w, r, []fieldError{*fieldErr})
+		return false
 	}
 
-	return strings.Join(newWords, " ")
+	return true
 }
 
+// Anonymous requests are validated against the limits of users without
+// Chirpy Red
 func (cfg *APIConfig) HandlerValidateChirp(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		Body string `json:"body"`
+	req := chirpRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if userID, authenticated := viewerID(r); authenticated {
+		if !cfg.checkChirpLength(w, r, userID, req.Body) {
+			return
+		}
+	} else if fieldErr := cfg.planEntitlements(false).chirpLengthError(req.Body); fieldErr != nil {
+		writeValidationProblem(w, r, []fieldError{*fieldErr})
+		return
 	}
 
-	type okResponse struct {
-		CleanedBody string `json:"cleaned_body"`
+	result := cfg.moderation.Check(req.Body)
+	if result.Action == moderation.ActionReject {
+		writeChirpRejected(w, r, result)
+		return
 	}
 
-	req := request{}
-	err := json.NewDecoder(r.Body).Decode(&req)
+	res := validateChirpResponse{CleanedBody: result.Text, Action: result.Action, Matches: result.Matches}
+	writeResponse(res, 200, w)
+}
+
+func (cfg *APIConfig) HandlerCreateChirp(w http.ResponseWriter, r *http.Request) {
+	req := createChirpRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if !cfg.checkChirpLength(w, r, userID, req.Body) {
+		return
+	}
+
+	result := cfg.moderation.Check(req.Body)
+	if result.Action == moderation.ActionReject {
+		writeChirpRejected(w, r, result)
+		return
+	}
+
+	chirp, err := cfg.DB.CreateChirp(result.Text, userID, req.InReplyToID, req.AttachmentIDs)
+	if errors.Is(err, database.ErrChirpNotFound) {
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "in_reply_to_id",
+			Code:    "not_found",
+			Message: fmt.Sprintf("Chirp %d does not exist", *req.InReplyToID),
+		}})
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		writeProblem(w, r, 403, codeBlocked, "You can't reply to users that you have blocked or that have blocked you")
+		return
+	}
+	if errors.Is(err, database.ErrMediaNotFound) {
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "attachment_ids",
+			Code:    "not_found",
+			Message: "Must be IDs of media that you have uploaded",
+		}})
+		return
+	}
+	if errors.Is(err, database.ErrMediaAttached) {
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "attachment_ids",
+			Code:    "already_attached",
+			Message: "Media can only be attached to one chirp",
+		}})
+		return
+	}
 	if err != nil {
-		writeResponse(errResponse{Error: "Invalid JSON body"}, 400, w)
-	} else if len(req.Body) > 140 {
-		writeResponse(errResponse{Error: "Chirp is too long"}, 400, w)
-	} else {
-		writeResponse(okResponse{CleanedBody: cleanMessage(req.Body)}, 200, w)
+		log.Printf("failed to create chirp: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	cfg.flagChirp(chirp.ID, result)
+	cfg.events.Publish(events.ChirpCreated{Chirp: chirp})
+	cfg.publishReply(chirp)
+	cfg.publishMentions(chirp, nil)
+
+	cfg.writeChirp(w, r, chirp, 201)
+}
+
+// Flags the chirp for review if it matched any rules with the flag action
+func (cfg *APIConfig) flagChirp(chirpID int, result moderation.Result) {
+	if result.Action != moderation.ActionFlag {
+		return
+	}
+
+	words := []string{}
+	for _, match := range result.Matches {
+		if match.Action == moderation.ActionFlag {
+			words = append(words, match.Word)
+		}
+	}
+
+	if err := cfg.DB.FlagChirp(chirpID, words); err != nil {
+		log.Printf("failed to flag chirp %d for review: %s", chirpID, err)
 	}
 }
 
-func getSubject(token *jwt.Token, w http.ResponseWriter) (int, error) {
-	userIDString, err := token.Claims.GetSubject()
+// Handles both PUT and PATCH, since body is the only editable field
+func (cfg *APIConfig) HandlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		w.WriteHeader(500)
-		return 0, fmt.Errorf("failed to get subject from token: %s", err)
+		writeInvalidPathID(w, r)
+		return
 	}
 
-	userID, err := strconv.Atoi(userIDString)
+	req := chirpRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(chirpID)
 	if err != nil {
-		w.WriteHeader(403)
-		return 0, fmt.Errorf("token subject is non-numeric")
+		log.Printf("failed to get chirp %d: %s", chirpID, err)
+		writeInternalError(w, r)
+		return
 	}
 
-	return userID, nil
-}
+	if chirp == nil {
+		writeNotFound(w, r, fmt.Sprintf("Chirp %d does not exist", chirpID))
+		return
+	}
 
-func (cfg *APIConfig) HandlerCreateChirp(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		Body string `json:"body"`
+	if chirp.AuthorID != userID {
+		writeProblem(w, r, 403, codeForbidden, "You can only edit your own chirps")
+		return
+	}
+
+	userEntitlements, err := cfg.entitlements(userID)
+	if err != nil {
+		log.Printf("failed to get entitlements of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	editWindow := userEntitlements.editWindow()
+	if time.Since(chirp.CreatedAt) > editWindow {
+		detail := fmt.Sprintf("Chirps can only be edited within %s of being created", editWindow)
+		writeProblem(w, r, 403, codeEditWindowClosed, detail)
+		return
 	}
 
-	token, ok := r.Context().Value(authorizedJWTKey).(*jwt.Token)
-	if !ok || token == nil {
-		log.Printf("context does not contain authorized access token")
-		w.WriteHeader(500)
+	if fieldErr := userEntitlements.chirpLengthError(req.Body); fieldErr != nil {
+		writeValidationProblem(w, r, []fieldError{*fieldErr})
 		return
 	}
 
-	req := request{}
-	err := json.NewDecoder(r.Body).Decode(&req)
+	result := cfg.moderation.Check(req.Body)
+	if result.Action == moderation.ActionReject {
+		writeChirpRejected(w, r, result)
+		return
+	}
+
+	updatedChirp, err := cfg.DB.UpdateChirp(chirpID, result.Text)
 	if err != nil {
-		writeResponse(errResponse{Error: "Invalid JSON body"}, 400, w)
+		log.Printf("failed to update chirp %d: %s", chirpID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if updatedChirp == nil {
+		writeNotFound(w, r, fmt.Sprintf("Chirp %d does not exist", chirpID))
 		return
 	}
 
-	userID, err := getSubject(token, w)
+	cfg.flagChirp(chirpID, result)
+	cfg.publishMentions(*updatedChirp, chirp.Entities)
+
+	cfg.writeChirp(w, r, *updatedChirp, 200)
+}
+
+func (cfg *APIConfig) HandlerGetChirpHistory(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		log.Print(err)
+		writeInvalidPathID(w, r)
 		return
 	}
 
-	chirp, err := cfg.DB.CreateChirp(req.Body, userID)
+	chirp, err := cfg.DB.GetChirp(chirpID)
 	if err != nil {
-		w.WriteHeader(500)
+		log.Printf("failed to get chirp %d: %s", chirpID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if chirp == nil || !cfg.visibleChirp(r, *chirp) {
+		writeNotFound(w, r, fmt.Sprintf("Chirp %d does not exist", chirpID))
+		return
 	}
-	writeResponse(chirp, 201, w)
+
+	if !cfg.checkAuthorNotBlocked(w, r, chirp.AuthorID) {
+		return
+	}
+
+	revisions, err := cfg.DB.GetChirpHistory(chirpID)
+	if err != nil {
+		log.Printf("failed to get history of chirp %d: %s", chirpID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(revisions, 200, w)
 }
 
 func (cfg *APIConfig) HandlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
 	chirpID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		writeResponse("Query parameter `id` is non-numeric", 400, w)
+		writeInvalidPathID(w, r)
 		return
 	}
 
 	chirp, err := cfg.DB.GetChirp(chirpID)
 	if err != nil {
 		log.Printf("tried to delete chirp %d, but something went wrong when retrieving it: %s", chirpID, err)
-		w.WriteHeader(500)
+		writeInternalError(w, r)
 		return
 	}
 
-	token, ok := r.Context().Value(authorizedJWTKey).(*jwt.Token)
-	if !ok || token == nil {
-		log.Printf("context does not contain authorized access token")
-		w.WriteHeader(500)
+	if chirp == nil {
+		writeNotFound(w, r, fmt.Sprintf("Chirp %d does not exist", chirpID))
 		return
 	}
 
-	userID, err := getSubject(token, w)
-	if err != nil {
-		log.Print(err)
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
 		return
 	}
 
 	if chirp.AuthorID != userID {
-		w.WriteHeader(403)
+		writeProblem(w, r, 403, codeForbidden, "You can only delete your own chirps")
+		return
+	}
+
+	attachments, err := cfg.DB.GetChirpMedia(*chirp)
+	if err != nil {
+		log.Printf("failed to get attachments of chirp %d: %s", chirpID, err)
+		writeInternalError(w, r)
 		return
 	}
 
 	if err := cfg.DB.DeleteChirp(chirpID); err != nil {
 		log.Printf("could not delete chirp %d: %s", chirpID, err)
-		w.WriteHeader(500)
+		writeInternalError(w, r)
 		return
 	}
 
+	for _, attachment := range attachments {
+		cfg.deleteBlobs(attachment.Key, attachment.ThumbnailKey)
+	}
+
+	cfg.events.Publish(events.ChirpDeleted{Chirp: *chirp})
+
 	w.WriteHeader(204)
 }
 
@@ -149,59 +347,147 @@ func sortChirps(chirps []database.Chirp, order string) {
 	})
 }
 
-func (cfg *APIConfig) HandlerGetChirps(w http.ResponseWriter, r *http.Request) {
-	authorIDString := r.URL.Query().Get("author_id")
+// Parses a list of IDs, which can be given both as a comma separated list and
+// as repeated parameters
+func parseIDList(query url.Values, name string) ([]int, *fieldError) {
+	ids := []int{}
+	for _, list := range query[name] {
+		for _, idString := range strings.Split(list, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(idString))
+			if err != nil {
+				return nil, &fieldError{
+					Field:   name,
+					Code:    "invalid_type",
+					Message: "Must be a comma separated list of IDs",
+				}
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
 
-	var chirps []database.Chirp
-	var err error
+// Parses the filters of the chirp list from the query parameters
+func parseChirpFilter(query url.Values) (database.ChirpFilter, []fieldError) {
+	filter := database.ChirpFilter{Contains: query.Get("contains")}
+	fieldErrors := []fieldError{}
 
-	if r.URL.Query().Get("author_id") == "" {
-		chirps, err = cfg.DB.GetChirps()
-		if err != nil {
-			fmt.Printf("Failed to get chirps by author ID: %s", err)
-			w.WriteHeader(500)
-			return
+	authorIDs, err := parseIDList(query, "author_id")
+	if err != nil {
+		fieldErrors = append(fieldErrors, *err)
+	}
+	filter.AuthorIDs = authorIDs
+
+	for _, name := range []string{"created_after", "created_before"} {
+		value := query.Get(name)
+		if value == "" {
+			continue
 		}
-	} else {
-		authorID, err := strconv.Atoi(authorIDString)
+
+		t, err := time.Parse(time.RFC3339, value)
 		if err != nil {
-			w.WriteHeader(400)
-			writeResponse("query parameter authorid_id is non-numeric", 400, w)
-			return
+			fieldErrors = append(fieldErrors, fieldError{
+				Field:   name,
+				Code:    "invalid_type",
+				Message: "Must be an RFC 3339 timestamp",
+			})
+			continue
 		}
 
-		chirps, err = cfg.DB.GetChirpsByAuthor(authorID)
-		if err != nil {
-			log.Printf("Failed to get chirps by author: %s", err)
-			w.WriteHeader(500)
-			return
+		if name == "created_after" {
+			filter.CreatedAfter = &t
+		} else {
+			filter.CreatedBefore = &t
 		}
 	}
 
-	if order := r.URL.Query().Get("sort"); order != "" {
+	return filter, fieldErrors
+}
+
+func chirpID(chirp database.Chirp) int {
+	return chirp.ID
+}
+
+func (cfg *APIConfig) HandlerGetChirps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	params, pageErrors := parsePageParams(r)
+	filter, filterErrors := parseChirpFilter(query)
+
+	order := query.Get("sort")
+	if order != "" && order != "asc" && order != "desc" {
+		filterErrors = append(filterErrors, fieldError{Field: "sort", Code: "invalid_value", Message: "Must be `asc` or `desc`"})
+	}
+
+	if fieldErrors := append(pageErrors, filterErrors...); len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	hidden, ok := cfg.viewerHiddenUserIDs(w, r)
+	if !ok {
+		return
+	}
+	filter.HiddenAuthorIDs = hidden
+
+	chirps, err := cfg.DB.FilterChirps(filter)
+	if err != nil {
+		log.Printf("Failed to get chirps: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if order != "" {
 		sortChirps(chirps, order)
 	}
+	params.descending = order == "desc"
 
-	writeResponse(chirps, 200, w)
+	cfg.writeChirpPage(w, r, chirps, params)
 }
 
 func (cfg *APIConfig) HandlerGetChirp(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		writeResponse("Query parameter `id` is non-numeric", 400, w)
+		writeInvalidPathID(w, r)
 		return
 	}
 
 	chirp, err := cfg.DB.GetChirp(id)
 	if err != nil {
-		w.WriteHeader(500)
+		log.Printf("failed to get chirp %d: %s", id, err)
+		writeInternalError(w, r)
 		return
 	}
 
-	if chirp == nil {
-		w.WriteHeader(404)
+	if chirp == nil || !cfg.visibleChirp(r, *chirp) {
+		writeNotFound(w, r, fmt.Sprintf("Chirp %d does not exist", id))
 		return
 	}
 
-	writeResponse(chirp, 200, w)
+	if !cfg.checkAuthorNotBlocked(w, r, chirp.AuthorID) {
+		return
+	}
+
+	cfg.writeChirp(w, r, *chirp, 200)
+}
+
+// Muted users' chirps can still be opened directly, but blocked ones can't
+func (cfg *APIConfig) checkAuthorNotBlocked(w http.ResponseWriter, r *http.Request, authorID int) bool {
+	viewerID, ok := viewerID(r)
+	if !ok {
+		return true
+	}
+
+	blocked, err := cfg.DB.IsBlocked(viewerID, authorID)
+	if err != nil {
+		log.Printf("failed to check if user %d is blocked: %s", authorID, err)
+		writeInternalError(w, r)
+		return false
+	}
+	if blocked {
+		writeProblem(w, r, 403, codeBlocked, "You can't view chirps of users that you have blocked or that have blocked you")
+		return false
+	}
+
+	return true
 }
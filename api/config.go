@@ -1,9 +1,24 @@
 package api
 
-import "github.com/mawkler/go-web-server/database"
+import (
+	"net/netip"
+	"time"
 
-type errResponse struct {
-	Error string `json:"error"`
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/events"
+	"github.com/mawkler/go-web-server/mail"
+	"github.com/mawkler/go-web-server/media"
+	"github.com/mawkler/go-web-server/moderation"
+	"github.com/mawkler/go-web-server/stream"
+)
+
+type ChirpLimits struct {
+	// The maximum number of characters in a chirp
+	MaxLength    int
+	RedMaxLength int
+	// How long after creation a chirp can be edited
+	EditWindow    time.Duration
+	RedEditWindow time.Duration
 }
 
 type APIConfig struct {
@@ -11,8 +26,66 @@ type APIConfig struct {
 	jwtSecret      string
 	polkaAPIKey    string
 	fileserverHits int
+	mailer         mail.Mailer
+	baseURL        string
+	// Block requests other than reads made with impersonation tokens
+	blockImpersonatedWrites bool
+	moderation              *moderation.Filter
+	chirpLimits             ChirpLimits
+	events                  *events.Bus
+	broker                  *stream.Broker
+	blobs                   media.BlobStore
+	// How long after asking to be deleted accounts are deleted
+	accountDeletionGracePeriod time.Duration
+	// Number of users that have to report a chirp for it to be hidden
+	// automatically. Zero disables automatic hiding
+	reportHideThreshold int
+	loginThrottle       *loginThrottle
+	// Proxies whose X-Forwarded-For headers are trusted
+	trustedProxies []netip.Prefix
+}
+
+// Settings and dependencies of the API, which are passed to NewAPIConfig
+type Options struct {
+	JWTSecret   string
+	PolkaAPIKey string
+	Mailer      mail.Mailer
+	// The URL that links in emails point to
+	BaseURL string
+	// Block requests other than reads made with impersonation tokens
+	BlockImpersonatedWrites bool
+	Moderation              *moderation.Filter
+	ChirpLimits             ChirpLimits
+	Events                  *events.Bus
+	Broker                  *stream.Broker
+	Blobs                   media.BlobStore
+	// How long after asking to be deleted accounts are deleted
+	AccountDeletionGracePeriod time.Duration
+	// Number of users that have to report a chirp for it to be hidden
+	// automatically. Zero disables automatic hiding
+	ReportHideThreshold int
+	// Proxies whose X-Forwarded-For headers are trusted to tell the client's IP
+	// address. Without any, every request is attributed to the address it
+	// comes from
+	TrustedProxies []netip.Prefix
 }
 
-func NewAPIConfig(database *database.DB, jwtSecret, polkaAPIKey string, fileserverHits int) APIConfig {
-	return APIConfig{DB: database, polkaAPIKey: polkaAPIKey, jwtSecret: jwtSecret, fileserverHits: fileserverHits}
+func NewAPIConfig(database *database.DB, options Options) APIConfig {
+	return APIConfig{
+		DB:                         database,
+		polkaAPIKey:                options.PolkaAPIKey,
+		jwtSecret:                  options.JWTSecret,
+		mailer:                     options.Mailer,
+		baseURL:                    options.BaseURL,
+		blockImpersonatedWrites:    options.BlockImpersonatedWrites,
+		moderation:                 options.Moderation,
+		chirpLimits:                options.ChirpLimits,
+		events:                     options.Events,
+		broker:                     options.Broker,
+		blobs:                      options.Blobs,
+		accountDeletionGracePeriod: options.AccountDeletionGracePeriod,
+		reportHideThreshold:        options.ReportHideThreshold,
+		loginThrottle:              newLoginThrottle(),
+		trustedProxies:             options.TrustedProxies,
+	}
 }
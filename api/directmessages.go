@@ -0,0 +1,316 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/events"
+)
+
+type createConversationRequest struct {
+	// Conversations are one-to-one or between a small group, so at most 9
+	// users besides the creator
+	ParticipantIDs []int `json:"participant_ids" validate:"required,min=1,max=9"`
+}
+
+type directMessageRequest struct {
+	Body string `json:"body" validate:"required,max=1000"`
+}
+
+type directMessagePreferencesRequest struct {
+	AllowFrom database.DirectMessagePolicy `json:"allow_from" validate:"required"`
+}
+
+func conversationActivity(summary database.ConversationSummary) int {
+	return summary.Activity
+}
+
+func directMessageID(message database.DirectMessage) int {
+	return message.ID
+}
+
+// Requires an access token belonging to a participant of the conversation in
+// the `id` path parameter. Conversations that the user isn't part of are
+// reported as missing, so that their existence isn't revealed
+func (cfg *APIConfig) MiddlewareConversationParticipant(next http.Handler) http.Handler {
+	return cfg.MiddlewareAuthorization(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conversationID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeInvalidPathID(w, r)
+			return
+		}
+
+		userID, ok := authorizedUserID(w, r)
+		if !ok {
+			return
+		}
+
+		conversation, err := cfg.DB.GetConversation(conversationID)
+		if err != nil {
+			log.Printf("failed to get conversation %d: %s", conversationID, err)
+			writeInternalError(w, r)
+			return
+		}
+
+		if conversation == nil || !conversation.HasParticipant(userID) {
+			log.Printf("user %d is not a participant of conversation %d", userID, conversationID)
+			writeNotFound(w, r, fmt.Sprintf("Conversation %d does not exist", conversationID))
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), conversationKey, conversation))
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// Gets the conversation that MiddlewareConversationParticipant put in the
+// request context
+func authorizedConversation(w http.ResponseWriter, r *http.Request) (*database.Conversation, bool) {
+	conversation, ok := r.Context().Value(conversationKey).(*database.Conversation)
+	if !ok || conversation == nil {
+		log.Printf("context does not contain a conversation")
+		writeInternalError(w, r)
+		return nil, false
+	}
+
+	return conversation, true
+}
+
+// Responds with 201 if the conversation was created, and with 200 if the
+// participants already had a conversation
+func (cfg *APIConfig) HandlerCreateConversation(w http.ResponseWriter, r *http.Request) {
+	req := createConversationRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, created, err := cfg.DB.CreateConversation(userID, req.ParticipantIDs)
+	if errors.Is(err, database.ErrUserNotFound) {
+		log.Printf("failed to create conversation: %s", err)
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "participant_ids",
+			Code:    "not_found",
+			Message: "Not all participants exist",
+		}})
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		log.Printf("user %d can't message participants: %s", userID, err)
+		writeProblem(w, r, 403, codeBlocked, "You can't message users that you have blocked or that have blocked you")
+		return
+	}
+	if errors.Is(err, database.ErrDirectMessagesRestricted) {
+		log.Printf("user %d can't message participants: %s", userID, err)
+		writeProblem(w, r, 403, codeDirectMessagesRestricted, "A participant only accepts direct messages from users they follow")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to create conversation: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	summary, err := cfg.DB.GetConversationSummary(conversation.ID, userID)
+	if err != nil || summary == nil {
+		log.Printf("failed to get conversation %d: %s", conversation.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	status := 200
+	if created {
+		status = 201
+	}
+	writeResponse(summary, status, w)
+}
+
+// Lists the user's conversations, with the most recently active first. The
+// cursors point at the conversations' activity rather than their IDs
+func (cfg *APIConfig) HandlerGetConversations(w http.ResponseWriter, r *http.Request) {
+	params, fieldErrors := parsePageParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	conversations, err := cfg.DB.GetConversations(userID)
+	if err != nil {
+		log.Printf("failed to get conversations of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	params.descending = true
+	writePage(w, r, conversations, conversationActivity, params)
+}
+
+func (cfg *APIConfig) HandlerGetConversation(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := authorizedConversation(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	summary, err := cfg.DB.GetConversationSummary(conversation.ID, userID)
+	if err != nil || summary == nil {
+		log.Printf("failed to get conversation %d: %s", conversation.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(summary, 200, w)
+}
+
+// Pages through the conversation's history, newest first
+func (cfg *APIConfig) HandlerGetDirectMessages(w http.ResponseWriter, r *http.Request) {
+	params, fieldErrors := parsePageParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	conversation, ok := authorizedConversation(w, r)
+	if !ok {
+		return
+	}
+
+	messages, err := cfg.DB.GetDirectMessages(conversation.ID)
+	if err != nil {
+		log.Printf("failed to get messages of conversation %d: %s", conversation.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	params.descending = true
+	slices.Reverse(messages)
+	writePage(w, r, messages, directMessageID, params)
+}
+
+func (cfg *APIConfig) HandlerSendDirectMessage(w http.ResponseWriter, r *http.Request) {
+	req := directMessageRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	conversation, ok := authorizedConversation(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	message, err := cfg.DB.SendDirectMessage(conversation.ID, userID, req.Body)
+	if errors.Is(err, database.ErrBlocked) {
+		log.Printf("user %d can't message conversation %d: %s", userID, conversation.ID, err)
+		writeProblem(w, r, 403, codeBlocked, "You can't message users that you have blocked or that have blocked you")
+		return
+	}
+	if errors.Is(err, database.ErrDirectMessagesRestricted) {
+		log.Printf("user %d can't message conversation %d: %s", userID, conversation.ID, err)
+		writeProblem(w, r, 403, codeDirectMessagesRestricted, "The recipient only accepts direct messages from users they follow")
+		return
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		writeNotFound(w, r, fmt.Sprintf("Conversation %d does not exist", conversation.ID))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to send message to conversation %d: %s", conversation.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	cfg.events.Publish(events.DirectMessageCreated{Message: *message, ParticipantIDs: conversation.ParticipantIDs})
+
+	writeResponse(message, 201, w)
+}
+
+func (cfg *APIConfig) HandlerMarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := authorizedConversation(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.MarkConversationRead(conversation.ID, userID); err != nil {
+		log.Printf("failed to mark conversation %d as read: %s", conversation.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *APIConfig) HandlerGetDirectMessagePreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	preferences, err := cfg.DB.GetDirectMessagePreferences(userID)
+	if err != nil {
+		log.Printf("failed to get direct message preferences of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(preferences, 200, w)
+}
+
+func (cfg *APIConfig) HandlerUpdateDirectMessagePreferences(w http.ResponseWriter, r *http.Request) {
+	req := directMessagePreferencesRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.AllowFrom != database.DirectMessagesFromEveryone && req.AllowFrom != database.DirectMessagesFromFollowing {
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "allow_from",
+			Code:    "invalid_value",
+			Message: fmt.Sprintf("Must be %q or %q", database.DirectMessagesFromEveryone, database.DirectMessagesFromFollowing),
+		}})
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	preferences := database.DirectMessagePreferences{AllowFrom: req.AllowFrom}
+	if err := cfg.DB.UpdateDirectMessagePreferences(userID, preferences); err != nil {
+		log.Printf("failed to update direct message preferences of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(preferences, 200, w)
+}
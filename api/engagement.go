@@ -0,0 +1,153 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/mawkler/go-web-server/database"
+)
+
+// A chirp along with the authenticated user's engagement with it, which is
+// left out for unauthenticated requests
+type chirpResponse struct {
+	database.Chirp
+	LikedByMe     *bool `json:"liked_by_me,omitempty"`
+	RechirpedByMe *bool `json:"rechirped_by_me,omitempty"`
+}
+
+// Adds the authenticated user's engagement to the chirps
+func (cfg *APIConfig) withEngagement(r *http.Request, chirps []database.Chirp) ([]chirpResponse, error) {
+	responses := make([]chirpResponse, 0, len(chirps))
+	for _, chirp := range chirps {
+		responses = append(responses, chirpResponse{Chirp: chirp})
+	}
+
+	userID, ok := viewerID(r)
+	if !ok {
+		return responses, nil
+	}
+
+	chirpIDs := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirpIDs = append(chirpIDs, chirp.ID)
+	}
+
+	engagement, err := cfg.DB.GetEngagement(userID, chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range responses {
+		liked := engagement[responses[i].ID].Liked
+		rechirped := engagement[responses[i].ID].Rechirped
+		responses[i].LikedByMe = &liked
+		responses[i].RechirpedByMe = &rechirped
+	}
+
+	return responses, nil
+}
+
+// Paginates the chirps and writes them along with the authenticated user's
+// engagement, which only gets looked up for the chirps on the page
+func (cfg *APIConfig) writeChirpPage(w http.ResponseWriter, r *http.Request, chirps []database.Chirp, params pageParams) {
+	pageChirps, next, prev := paginate(chirps, chirpID, params)
+	cfg.writeChirpPageItems(w, r, pageChirps, next, prev, params)
+}
+
+func (cfg *APIConfig) writeChirpPageItems(w http.ResponseWriter, r *http.Request, pageChirps []database.Chirp, next, prev string, params pageParams) {
+	responses, err := cfg.withEngagement(r, pageChirps)
+	if err != nil {
+		log.Printf("failed to get engagement with chirps: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writePageItems(w, r, responses, next, prev, params, nil)
+}
+
+func (cfg *APIConfig) writeChirp(w http.ResponseWriter, r *http.Request, chirp database.Chirp, code int) {
+	responses, err := cfg.withEngagement(r, []database.Chirp{chirp})
+	if err != nil {
+		log.Printf("failed to get engagement with chirp %d: %s", chirp.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(responses[0], code, w)
+}
+
+// Handles liking, unliking, rechirping and undoing rechirps, which all respond
+// with the updated chirp
+func (cfg *APIConfig) handleEngagement(action string, engage func(userID, chirpID int) (*database.Chirp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chirpID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeInvalidPathID(w, r)
+			return
+		}
+
+		userID, ok := authorizedUserID(w, r)
+		if !ok {
+			return
+		}
+
+		chirp, err := engage(userID, chirpID)
+		if errors.Is(err, database.ErrChirpNotFound) {
+			writeNotFound(w, r, fmt.Sprintf("Chirp %d does not exist", chirpID))
+			return
+		}
+		if err != nil {
+			log.Printf("user %d failed to %s chirp %d: %s", userID, action, chirpID, err)
+			writeInternalError(w, r)
+			return
+		}
+
+		cfg.writeChirp(w, r, *chirp, 200)
+	}
+}
+
+func (cfg *APIConfig) HandlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleEngagement("like", cfg.DB.LikeChirp)(w, r)
+}
+
+func (cfg *APIConfig) HandlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleEngagement("unlike", cfg.DB.UnlikeChirp)(w, r)
+}
+
+func (cfg *APIConfig) HandlerRechirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleEngagement("rechirp", cfg.DB.Rechirp)(w, r)
+}
+
+func (cfg *APIConfig) HandlerUndoRechirp(w http.ResponseWriter, r *http.Request) {
+	cfg.handleEngagement("undo rechirp of", cfg.DB.UndoRechirp)(w, r)
+}
+
+func (cfg *APIConfig) HandlerGetLikedChirps(w http.ResponseWriter, r *http.Request) {
+	params, fieldErrors := parsePageParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	user, ok := cfg.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	chirps, err := cfg.DB.GetLikedChirps(user.ID)
+	if err != nil {
+		log.Printf("failed to get chirps liked by user %d: %s", user.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	hidden, ok := cfg.viewerHiddenUserIDs(w, r)
+	if !ok {
+		return
+	}
+
+	cfg.writeChirpPage(w, r, withoutHiddenAuthors(chirps, hidden), params)
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Machine readable error codes
+const (
+	codeInvalidJSON      = "invalid_json"
+	codeValidationFailed = "validation_failed"
+	codeInvalidParameter = "invalid_parameter"
+	codeUnauthorized     = "unauthorized"
+	codeInvalidToken     = "invalid_token"
+	codeWrongTokenType   = "wrong_token_type"
+	codeInvalidCSRFToken = "invalid_csrf_token"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeConflict         = "conflict"
+	codeRequestTooLarge  = "request_too_large"
+	// Uploads that aren't one of the supported image types
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeChirpRejected        = "chirp_rejected"
+	codeEditWindowClosed     = "edit_window_closed"
+	// The recipient only accepts direct messages from users they follow
+	codeDirectMessagesRestricted = "direct_messages_restricted"
+	// One of the users has blocked the other
+	codeBlocked          = "blocked"
+	codeAccountSuspended = "account_suspended"
+	// Too many failed logins from the client's IP address
+	codeTooManyFailedLogins = "too_many_failed_logins"
+	codeInternalError       = "internal_error"
+)
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// An RFC 7807 problem details object
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+func newProblem(r *http.Request, status int, code, detail string) problem {
+	return problem{
+		Type:      fmt.Sprintf("/problems/%s", code),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: getRequestID(r),
+	}
+}
+
+func sendProblem(p problem, w http.ResponseWriter) {
+	resp, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Error marshalling problem: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(resp)
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	sendProblem(newProblem(r, status, code, detail), w)
+}
+
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, fieldErrors []fieldError) {
+	p := newProblem(r, 400, codeValidationFailed, "The request failed validation")
+	p.Errors = fieldErrors
+	sendProblem(p, w)
+}
+
+// Internal error details get logged, but are not exposed to the client
+func writeInternalError(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, 500, codeInternalError, "Something went wrong on our end")
+}
+
+func writeInvalidJSON(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, 400, codeInvalidJSON, "Invalid JSON body")
+}
+
+func writeNotFound(w http.ResponseWriter, r *http.Request, detail string) {
+	writeProblem(w, r, 404, codeNotFound, detail)
+}
+
+func writeInvalidPathID(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, 400, codeInvalidParameter, "Path parameter `id` is non-numeric")
+}
+
+// Gets the token that MiddlewareAuthorization put in the request context
+func authorizedToken(w http.ResponseWriter, r *http.Request) (*jwt.Token, bool) {
+	token, ok := r.Context().Value(authorizedJWTKey).(*jwt.Token)
+	if !ok || token == nil {
+		log.Printf("context does not contain authorized access token")
+		writeInternalError(w, r)
+		return nil, false
+	}
+
+	return token, true
+}
+
+// Gets the token from the request context and ensures that it was issued by
+// issuer
+func authorizedTokenWithIssuer(w http.ResponseWriter, r *http.Request, issuer string) (*jwt.Token, bool) {
+	token, ok := authorizedToken(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	tokenIssuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		log.Printf("failed to get issuer from token: %s", err)
+		writeProblem(w, r, 401, codeInvalidToken, "Token has no issuer")
+		return nil, false
+	}
+
+	if tokenIssuer != issuer {
+		log.Printf("jwt has issuer %s, expected %s", tokenIssuer, issuer)
+		writeProblem(w, r, 401, codeWrongTokenType, fmt.Sprintf("Expected a token issued by %s", issuer))
+		return nil, false
+	}
+
+	return token, true
+}
+
+func getSubject(token *jwt.Token) (int, error) {
+	userIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get subject from token: %s", err)
+	}
+
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		return 0, fmt.Errorf("token subject is non-numeric")
+	}
+
+	return userID, nil
+}
+
+// Gets the ID of the authenticated user on endpoints where authentication is
+// optional. Returns false if the request is unauthenticated
+func viewerID(r *http.Request) (int, bool) {
+	token, ok := r.Context().Value(authorizedJWTKey).(*jwt.Token)
+	if !ok || token == nil {
+		return 0, false
+	}
+
+	userID, err := getSubject(token)
+	if err != nil {
+		return 0, false
+	}
+
+	return userID, true
+}
+
+// Gets the user ID from the subject of the token in the request context
+func authorizedUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	token, ok := authorizedToken(w, r)
+	if !ok {
+		return 0, false
+	}
+
+	userID, err := getSubject(token)
+	if err != nil {
+		log.Print(err)
+		writeProblem(w, r, 401, codeInvalidToken, "Token subject is not a user ID")
+		return 0, false
+	}
+
+	return userID, true
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/events"
+)
+
+// Gets the user from the `id` path parameter. Writes a problem response and
+// returns false if it's invalid or the user doesn't exist
+func (cfg *APIConfig) pathUser(w http.ResponseWriter, r *http.Request) (*database.User, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeInvalidPathID(w, r)
+		return nil, false
+	}
+
+	user, err := cfg.DB.GetUser(id)
+	if err != nil {
+		log.Printf("failed to get user %d: %s", id, err)
+		writeInternalError(w, r)
+		return nil, false
+	}
+
+	if user == nil {
+		writeNotFound(w, r, fmt.Sprintf("User %d does not exist", id))
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (cfg *APIConfig) HandlerFollow(w http.ResponseWriter, r *http.Request) {
+	followerID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	followee, ok := cfg.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	if followee.ID == followerID {
+		writeProblem(w, r, 400, codeInvalidParameter, "You can't follow yourself")
+		return
+	}
+
+	followed, err := cfg.DB.Follow(followerID, followee.ID)
+	if errors.Is(err, database.ErrBlocked) {
+		writeProblem(w, r, 403, codeBlocked, "You can't follow users that you have blocked or that have blocked you")
+		return
+	}
+	if err != nil {
+		log.Printf("user %d failed to follow user %d: %s", followerID, followee.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if followed {
+		cfg.events.Publish(events.FollowCreated{FollowerID: followerID, FolloweeID: followee.ID})
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *APIConfig) HandlerUnfollow(w http.ResponseWriter, r *http.Request) {
+	followerID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	followee, ok := cfg.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.Unfollow(followerID, followee.ID); err != nil {
+		log.Printf("user %d failed to unfollow user %d: %s", followerID, followee.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func userID(user database.User) int {
+	return user.ID
+}
+
+func (cfg *APIConfig) writeFollowList(w http.ResponseWriter, r *http.Request, getUsers func(int) ([]database.User, error)) {
+	params, fieldErrors := parsePageParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	user, ok := cfg.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	users, err := getUsers(user.ID)
+	if err != nil {
+		log.Printf("failed to get follow list of user %d: %s", user.ID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	total := len(users)
+	pageItems, next, prev := paginate(users, userID, params)
+	cfg.writeUserPageItems(w, r, pageItems, next, prev, params, &total)
+}
+
+func (cfg *APIConfig) HandlerGetFollowers(w http.ResponseWriter, r *http.Request) {
+	cfg.writeFollowList(w, r, cfg.DB.GetFollowers)
+}
+
+func (cfg *APIConfig) HandlerGetFollowing(w http.ResponseWriter, r *http.Request) {
+	cfg.writeFollowList(w, r, cfg.DB.GetFollowing)
+}
+
+// The chirps of the authenticated user and the users they follow, newest
+// first. Only supports paging towards older chirps with `after`
+func (cfg *APIConfig) HandlerTimeline(w http.ResponseWriter, r *http.Request) {
+	params, fieldErrors := parsePageParams(r)
+	if params.before != nil {
+		fieldErrors = append(fieldErrors, fieldError{
+			Field:   "before",
+			Code:    "unsupported",
+			Message: "Timelines can only be paged with `after`",
+		})
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	chirps, hasMore, err := cfg.DB.Timeline(userID, params.after, params.limit)
+	if err != nil {
+		log.Printf("failed to get timeline of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	// There's no previous page, since clients get newer chirps by reloading the
+	// first page
+	next := ""
+	if hasMore && len(chirps) > 0 {
+		next = encodeCursor(chirps[len(chirps)-1].ID)
+	}
+
+	cfg.writeChirpPageItems(w, r, chirps, next, "", params)
+}
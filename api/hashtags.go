@@ -0,0 +1,114 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/entities"
+	"github.com/mawkler/go-web-server/events"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+	maxTrendingLimit      = 50
+)
+
+// Publishes an event for each user mentioned in the chirp that wasn't already
+// mentioned in previousEntities, so that edits don't announce mentions twice
+func (cfg *APIConfig) publishMentions(chirp database.Chirp, previousEntities []entities.Entity) {
+	mentioned := func(chirpEntities []entities.Entity, userID int) bool {
+		return slices.ContainsFunc(chirpEntities, func(entity entities.Entity) bool {
+			return entity.Type == entities.TypeMention && entity.UserID != nil && *entity.UserID == userID
+		})
+	}
+
+	published := []int{}
+	for _, entity := range chirp.Entities {
+		if entity.Type != entities.TypeMention || entity.UserID == nil {
+			continue
+		}
+
+		userID := *entity.UserID
+		if userID == chirp.AuthorID || slices.Contains(published, userID) || mentioned(previousEntities, userID) {
+			continue
+		}
+
+		cfg.events.Publish(events.MentionCreated{ChirpID: chirp.ID, AuthorID: chirp.AuthorID, UserID: userID})
+		published = append(published, userID)
+	}
+}
+
+func (cfg *APIConfig) HandlerGetHashtagChirps(w http.ResponseWriter, r *http.Request) {
+	params, fieldErrors := parsePageParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	tag := r.PathValue("tag")
+	chirps, err := cfg.DB.GetChirpsByHashtag(tag)
+	if err != nil {
+		log.Printf("failed to get chirps with hashtag %s: %s", tag, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	hidden, ok := cfg.viewerHiddenUserIDs(w, r)
+	if !ok {
+		return
+	}
+
+	cfg.writeChirpPage(w, r, withoutHiddenAuthors(chirps, hidden), params)
+}
+
+func (cfg *APIConfig) HandlerTrendingHashtags(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fieldErrors := []fieldError{}
+
+	window := defaultTrendingWindow
+	if windowString := query.Get("window"); windowString != "" {
+		var err error
+		window, err = time.ParseDuration(windowString)
+		if err != nil || window < time.Minute || window > maxTrendingWindow {
+			fieldErrors = append(fieldErrors, fieldError{
+				Field:   "window",
+				Code:    "out_of_range",
+				Message: fmt.Sprintf("Must be a duration, such as `1h`, between 1m and %s", maxTrendingWindow),
+			})
+		}
+	}
+
+	limit := defaultTrendingLimit
+	if limitString := query.Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 || limit > maxTrendingLimit {
+			fieldErrors = append(fieldErrors, fieldError{
+				Field:   "limit",
+				Code:    "out_of_range",
+				Message: fmt.Sprintf("Must be an integer between 1 and %d", maxTrendingLimit),
+			})
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	trending, err := cfg.DB.TrendingHashtags(window, limit)
+	if err != nil {
+		log.Printf("failed to get trending hashtags: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(trending, 200, w)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/mawkler/go-web-server/auth"
+	"github.com/mawkler/go-web-server/database"
+)
+
+type tokenInfo struct {
+	TokenType    string         `json:"token_type"`
+	Scopes       []string       `json:"scopes"`
+	ExpiresAt    *time.Time     `json:"expires_at,omitempty"`
+	IssuedAt     *time.Time     `json:"issued_at,omitempty"`
+	SessionID    string         `json:"session_id,omitempty"`
+	Impersonator *int           `json:"impersonator_id,omitempty"`
+	User         *database.User `json:"user,omitempty"`
+}
+
+func tokenScopes(tokenType string, isAdmin bool) []string {
+	switch tokenType {
+	case "access":
+		if isAdmin {
+			return []string{"chirps:write", "users:write", "admin"}
+		}
+		return []string{"chirps:write", "users:write"}
+	case "impersonation":
+		return []string{"chirps:write", "users:write"}
+	case "refresh":
+		return []string{"token:refresh"}
+	case "magic_link":
+		return []string{"login"}
+	default:
+		return []string{}
+	}
+}
+
+// Describes a token that has already been validated by auth.Authorize
+func (cfg *APIConfig) describeToken(token *jwt.Token) (*tokenInfo, error) {
+	claims := auth.GetClaims(token)
+	info := tokenInfo{
+		TokenType: auth.TokenType(token),
+		SessionID: claims.SessionID,
+	}
+
+	if claims.ExpiresAt != nil {
+		info.ExpiresAt = &claims.ExpiresAt.Time
+	}
+	if claims.IssuedAt != nil {
+		info.IssuedAt = &claims.IssuedAt.Time
+	}
+	if adminIDString, impersonating := auth.Impersonator(token); impersonating {
+		if adminID, err := strconv.Atoi(adminIDString); err == nil {
+			info.Impersonator = &adminID
+		}
+	}
+
+	isAdmin := false
+	if userID, err := strconv.Atoi(claims.Subject); err == nil {
+		user, err := cfg.DB.GetUser(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if user != nil && info.TokenType == "access" {
+			isAdmin, err = cfg.isAdmin(userID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		info.User = user
+	}
+	info.Scopes = tokenScopes(info.TokenType, isAdmin)
+
+	return &info, nil
+}
+
+func (cfg *APIConfig) HandlerMe(w http.ResponseWriter, r *http.Request) {
+	token, ok := authorizedToken(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := cfg.describeToken(token)
+	if err != nil {
+		log.Printf("failed to describe token: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if info.User == nil {
+		log.Print("token does not belong to an existing user")
+		writeProblem(w, r, 401, codeInvalidToken, "Token does not belong to an existing user")
+		return
+	}
+
+	writeResponse(info, 200, w)
+}
+
+type introspectionRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type introspectionResponse struct {
+	Active bool   `json:"active"`
+	Reason string `json:"reason,omitempty"`
+	*tokenInfo
+}
+
+func (cfg *APIConfig) HandlerIntrospect(w http.ResponseWriter, r *http.Request) {
+	req := introspectionRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	token, err := auth.Authorize(req.Token, cfg.jwtSecret)
+	if err != nil {
+		writeResponse(introspectionResponse{Active: false, Reason: auth.InvalidTokenReason(err)}, 200, w)
+		return
+	}
+
+	info, err := cfg.describeToken(token)
+	if err != nil {
+		log.Printf("failed to describe token: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	res := introspectionResponse{Active: true, tokenInfo: info}
+
+	switch info.TokenType {
+	case "refresh":
+		refreshToken, err := cfg.DB.GetRefreshToken(token.Raw)
+		if err != nil {
+			log.Printf("failed to get refresh token from database: %s", err)
+			writeInternalError(w, r)
+			return
+		}
+		if refreshToken == nil {
+			res.Active, res.Reason = false, "revoked"
+		}
+	case "magic_link":
+		link, err := cfg.DB.GetMagicLink(token.Raw)
+		if err != nil {
+			log.Printf("failed to get magic link from database: %s", err)
+			writeInternalError(w, r)
+			return
+		}
+		if link == nil {
+			res.Active, res.Reason = false, "used"
+		}
+	case "unknown":
+		res.Active, res.Reason = false, "unknown_issuer"
+	}
+
+	if res.Active && info.User == nil && info.TokenType != "magic_link" {
+		res.Active, res.Reason = false, "unknown_user"
+	}
+
+	writeResponse(res, 200, w)
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/media"
+)
+
+const (
+	maxUploadSize = 5 << 20
+	// Room for the multipart boundaries and headers around the file
+	multipartOverhead = 1 << 10
+	thumbnailSize     = 320
+	// Blob keys are never reused, so media can be cached indefinitely
+	mediaCacheControl = "public, max-age=31536000, immutable"
+)
+
+// Only documents the form, since uploads aren't JSON
+type uploadMediaRequest struct {
+	File string `json:"file" validate:"required" format:"binary"`
+}
+
+type mediaResponse struct {
+	ID           int       `json:"id"`
+	OwnerID      int       `json:"owner_id"`
+	ContentType  string    `json:"content_type"`
+	Size         int       `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	ChirpID      *int      `json:"chirp_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (cfg *APIConfig) newMediaResponse(m database.Media) mediaResponse {
+	return mediaResponse{
+		ID:           m.ID,
+		OwnerID:      m.OwnerID,
+		ContentType:  m.ContentType,
+		Size:         m.Size,
+		Width:        m.Width,
+		Height:       m.Height,
+		URL:          fmt.Sprintf("%s/media/%s", cfg.baseURL, m.Key),
+		ThumbnailURL: fmt.Sprintf("%s/media/%s", cfg.baseURL, m.ThumbnailKey),
+		ChirpID:      m.ChirpID,
+		CreatedAt:    m.CreatedAt,
+	}
+}
+
+// Random, so that uploads that haven't been attached to a chirp yet can't be
+// found by guessing
+func newBlobKey() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate blob key: %s", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// Reads the `file` field of a multipart form. Writes a problem response and
+// returns false if it's missing or too large
+func readUpload(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+multipartOverhead)
+	file, _, err := r.FormFile("file")
+
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesError):
+		writeProblem(w, r, 413, codeRequestTooLarge, fmt.Sprintf("Files must not be larger than %d bytes", maxUploadSize))
+		return nil, false
+	case errors.Is(err, http.ErrNotMultipart), errors.Is(err, http.ErrMissingFile):
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "file",
+			Code:    "required",
+			Message: "Must be uploaded as multipart/form-data",
+		}})
+		return nil, false
+	case err != nil:
+		writeProblem(w, r, 400, codeInvalidParameter, "Invalid multipart form")
+		return nil, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadSize+1))
+	if err != nil {
+		log.Printf("failed to read upload: %s", err)
+		writeInternalError(w, r)
+		return nil, false
+	}
+	if len(data) > maxUploadSize {
+		writeProblem(w, r, 413, codeRequestTooLarge, fmt.Sprintf("Files must not be larger than %d bytes", maxUploadSize))
+		return nil, false
+	}
+
+	return data, true
+}
+
+// Stores the image and its thumbnail, and returns their keys
+func (cfg *APIConfig) storeImage(img *media.Image) (string, string, error) {
+	id, err := newBlobKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	key := id + "." + media.Extension(img.ContentType)
+	thumbnailKey := id + "_thumb." + media.Extension(img.ThumbnailContentType)
+
+	if err := cfg.blobs.Put(key, bytes.NewReader(img.Data), img.ContentType); err != nil {
+		return "", "", err
+	}
+	if err := cfg.blobs.Put(thumbnailKey, bytes.NewReader(img.Thumbnail), img.ThumbnailContentType); err != nil {
+		cfg.deleteBlobs(key)
+		return "", "", err
+	}
+
+	return key, thumbnailKey, nil
+}
+
+// Failures are only logged, since the blobs are unreachable once their media
+// is gone
+func (cfg *APIConfig) deleteBlobs(keys ...string) {
+	for _, key := range keys {
+		if err := cfg.blobs.Delete(key); err != nil {
+			log.Printf("failed to delete blob %s: %s", key, err)
+		}
+	}
+}
+
+// Uploaded images can then be attached to a chirp by their ID
+func (cfg *APIConfig) HandlerUploadMedia(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	data, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	img, err := media.Process(data, thumbnailSize)
+	switch {
+	case errors.Is(err, media.ErrUnsupportedType):
+		writeProblem(w, r, 415, codeUnsupportedMediaType, "Only JPEG, PNG and GIF images are supported")
+		return
+	case errors.Is(err, media.ErrTooManyPixels):
+		writeProblem(w, r, 413, codeRequestTooLarge, fmt.Sprintf("Images must not have more than %d pixels", media.MaxPixels))
+		return
+	case errors.Is(err, media.ErrInvalidImage):
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "file",
+			Code:    "invalid_value",
+			Message: "Must be a valid image",
+		}})
+		return
+	case err != nil:
+		log.Printf("failed to process upload of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	key, thumbnailKey, err := cfg.storeImage(img)
+	if err != nil {
+		log.Printf("failed to store upload of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	created, err := cfg.DB.CreateMedia(database.Media{
+		OwnerID:      userID,
+		ContentType:  img.ContentType,
+		Size:         len(img.Data),
+		Width:        img.Width,
+		Height:       img.Height,
+		Key:          key,
+		ThumbnailKey: thumbnailKey,
+	})
+	if err != nil {
+		log.Printf("failed to create media for user %d: %s", userID, err)
+		cfg.deleteBlobs(key, thumbnailKey)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(cfg.newMediaResponse(created), 201, w)
+}
+
+func (cfg *APIConfig) HandlerGetMedia(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeInvalidPathID(w, r)
+		return
+	}
+
+	m, err := cfg.DB.GetMedia(id)
+	if err != nil {
+		log.Printf("failed to get media %d: %s", id, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if m == nil {
+		writeNotFound(w, r, fmt.Sprintf("Media %d does not exist", id))
+		return
+	}
+
+	writeResponse(cfg.newMediaResponse(*m), 200, w)
+}
+
+// Serves the blobs of uploaded media. Their content was checked on upload, so
+// browsers are told not to sniff it
+func (cfg *APIConfig) HandlerServeMedia(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if !media.ValidKey(key) {
+		writeNotFound(w, r, fmt.Sprintf("Media file %s does not exist", key))
+		return
+	}
+
+	blob, err := cfg.blobs.Get(key)
+	if errors.Is(err, media.ErrBlobNotFound) {
+		writeNotFound(w, r, fmt.Sprintf("Media file %s does not exist", key))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get blob %s: %s", key, err)
+		writeInternalError(w, r)
+		return
+	}
+	defer blob.Close()
+
+	etag := fmt.Sprintf("%q", key)
+	w.Header().Set("Cache-Control", mediaCacheControl)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(304)
+		return
+	}
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("failed to serve blob %s: %s", key, err)
+	}
+}
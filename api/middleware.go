@@ -2,8 +2,13 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/mawkler/go-web-server/auth"
@@ -11,7 +16,46 @@ import (
 
 type contextKey string
 
-const authorizedJWTKey contextKey = "authorizedJWT"
+const (
+	authorizedJWTKey contextKey = "authorizedJWT"
+	cookieSessionKey contextKey = "cookieSession"
+	requestIDKey     contextKey = "requestID"
+	conversationKey  contextKey = "conversation"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+func contextWithCookieSession(ctx context.Context, fromCookie bool) context.Context {
+	return context.WithValue(ctx, cookieSessionKey, fromCookie)
+}
+
+func isCookieSession(r *http.Request) bool {
+	fromCookie, _ := r.Context().Value(cookieSessionKey).(bool)
+	return fromCookie
+}
+
+// Tags each request with an ID, so that error responses can be correlated with
+// the server's logs. An ID sent by the client is reused
+func MiddlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" {
+			id := make([]byte, 8)
+			rand.Read(id)
+			requestID = hex.EncodeToString(id)
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, requestID))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+	return requestID
+}
 
 func (cfg *APIConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -20,24 +64,110 @@ func (cfg *APIConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
+var errInvalidCSRFToken = errors.New("missing or invalid CSRF token")
+
 func getBearerToken(r *http.Request) string {
 	bearerToken := r.Header.Get("Authorization")
 	return strings.TrimPrefix(bearerToken, "Bearer ")
 }
 
-func (cfg *APIConfig) MiddlewareAuthorization(next http.Handler) http.Handler {
+// Gets the token from the Authorization header, or from the session cookie if
+// the header is missing. Cookie authenticated requests with unsafe methods have
+// to pass the CSRF check
+func getRequestToken(r *http.Request, cookieName string) (string, bool, error) {
+	if r.Header.Get("Authorization") != "" {
+		return getBearerToken(r), false, nil
+	}
+
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return "", false, errors.New("request has neither an Authorization header nor a session cookie")
+	}
+
+	if !isSafeMethod(r.Method) && !validCSRFToken(r) {
+		return "", true, errInvalidCSRFToken
+	}
+
+	return cookie.Value, true, nil
+}
+
+// Only accepts tokens issued by issuer, so that e.g. refresh tokens can't be
+// used as access tokens
+func (cfg *APIConfig) middlewareAuthorization(next http.Handler, cookieName, issuer string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenString := getBearerToken(r)
+		tokenString, fromCookie, err := getRequestToken(r, cookieName)
+		if errors.Is(err, errInvalidCSRFToken) {
+			log.Printf("Unauthorized: %s", err)
+			writeProblem(w, r, 403, codeInvalidCSRFToken, "Missing or invalid CSRF token")
+			return
+		} else if err != nil {
+			log.Printf("Unauthorized: %s", err)
+			writeProblem(w, r, 401, codeUnauthorized, "Authentication required")
+			return
+		}
+
 		token, err := auth.Authorize(tokenString, cfg.jwtSecret)
 		if err != nil {
 			log.Printf("Invalid jwt: %s", err)
-			w.WriteHeader(401)
+			writeProblem(w, r, 401, codeInvalidToken, fmt.Sprintf("Invalid token: %s", auth.InvalidTokenReason(err)))
 			return
 		}
 
+		if tokenIssuer := auth.GetClaims(token).Issuer; tokenIssuer != issuer {
+			log.Printf("jwt has issuer %s, expected %s", tokenIssuer, issuer)
+			writeProblem(w, r, 401, codeWrongTokenType, fmt.Sprintf("Expected a token issued by %s", issuer))
+			return
+		}
+
+		if userID, err := strconv.Atoi(auth.GetClaims(token).Subject); err == nil {
+			suspended, err := cfg.DB.IsSuspended(userID)
+			if err != nil {
+				log.Printf("failed to check suspension of user %d: %s", userID, err)
+				writeInternalError(w, r)
+				return
+			}
+			if suspended {
+				log.Printf("Unauthorized: user %d is suspended", userID)
+				writeProblem(w, r, 403, codeAccountSuspended, "Account is suspended")
+				return
+			}
+		}
+
+		if adminID, impersonating := auth.Impersonator(token); impersonating {
+			if !cfg.auditImpersonatedRequest(token, adminID, r) {
+				writeProblem(w, r, 403, codeForbidden, "Only reads are allowed while impersonating")
+				return
+			}
+		}
+
 		context := context.WithValue(r.Context(), authorizedJWTKey, token)
+		context = contextWithCookieSession(context, fromCookie)
 		r = r.WithContext(context)
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (cfg *APIConfig) MiddlewareAuthorization(next http.Handler) http.Handler {
+	return cfg.middlewareAuthorization(next, accessTokenCookie, "chirpy-access")
+}
+
+// Same as MiddlewareAuthorization, but lets requests without any credentials
+// through unauthenticated. Meant for public endpoints that personalize their
+// responses for authenticated users
+func (cfg *APIConfig) MiddlewareOptionalAuthorization(next http.Handler) http.Handler {
+	authorized := cfg.MiddlewareAuthorization(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie(accessTokenCookie); err != nil && r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authorized.ServeHTTP(w, r)
+	})
+}
+
+// Same as MiddlewareAuthorization, but only accepts refresh tokens and falls
+// back to the refresh token cookie
+func (cfg *APIConfig) MiddlewareRefreshAuthorization(next http.Handler) http.Handler {
+	return cfg.middlewareAuthorization(next, refreshTokenCookie, "chirpy-refresh")
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/moderation"
+)
+
+type moderationRuleRequest struct {
+	Action string `json:"action" validate:"required"`
+}
+
+func (cfg *APIConfig) HandlerGetModerationRules(w http.ResponseWriter, _ *http.Request) {
+	writeResponse(cfg.moderation.Rules(), 200, w)
+}
+
+// Adds or replaces the rule for a word
+func (cfg *APIConfig) HandlerSetModerationRule(w http.ResponseWriter, r *http.Request) {
+	req := moderationRuleRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	action, err := moderation.ParseAction(req.Action)
+	if err != nil {
+		writeValidationProblem(w, r, []fieldError{{Field: "action", Code: "invalid_value", Message: err.Error()}})
+		return
+	}
+
+	rule := moderation.Rule{Word: r.PathValue("word"), Action: action}
+	if err := cfg.moderation.SetRule(rule); err != nil {
+		writeProblem(w, r, 400, codeInvalidParameter, err.Error())
+		return
+	}
+
+	dbRule := database.ModerationRule{Word: rule.Word, Action: string(rule.Action)}
+	if err := cfg.DB.SaveModerationRule(dbRule); err != nil {
+		log.Printf("failed to save moderation rule: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(rule, 200, w)
+}
+
+func (cfg *APIConfig) HandlerDeleteModerationRule(w http.ResponseWriter, r *http.Request) {
+	word := r.PathValue("word")
+	if !cfg.moderation.RemoveRule(word) {
+		writeNotFound(w, r, fmt.Sprintf("There is no rule for %q", word))
+		return
+	}
+
+	if err := cfg.DB.DeleteModerationRule(word); err != nil {
+		log.Printf("failed to delete moderation rule: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *APIConfig) HandlerGetChirpFlags(w http.ResponseWriter, r *http.Request) {
+	flags, err := cfg.DB.GetChirpFlags()
+	if err != nil {
+		log.Printf("failed to get flagged chirps: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(flags, 200, w)
+}
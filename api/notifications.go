@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/mawkler/go-web-server/database"
+)
+
+type notificationsResponse struct {
+	page[database.Notification]
+	UnreadCount int `json:"unread_count"`
+}
+
+type notificationPreferencesRequest struct {
+	Reply   *bool `json:"reply,omitempty"`
+	Mention *bool `json:"mention,omitempty"`
+	Follow  *bool `json:"follow,omitempty"`
+}
+
+func notificationID(notification database.Notification) int {
+	return notification.ID
+}
+
+func (cfg *APIConfig) HandlerGetNotifications(w http.ResponseWriter, r *http.Request) {
+	params, fieldErrors := parsePageParams(r)
+	if len(params.fields) > 0 {
+		fieldErrors = append(fieldErrors, fieldError{
+			Field:   "fields",
+			Code:    "unsupported",
+			Message: "Notifications can't be projected",
+		})
+	}
+
+	unreadOnly := false
+	if unread := r.URL.Query().Get("unread"); unread != "" {
+		var err error
+		unreadOnly, err = strconv.ParseBool(unread)
+		if err != nil {
+			fieldErrors = append(fieldErrors, fieldError{Field: "unread", Code: "invalid_type", Message: "Must be a boolean"})
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	notifications, unreadCount, err := cfg.DB.GetNotifications(userID, unreadOnly)
+	if err != nil {
+		log.Printf("failed to get notifications of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	// Newest first
+	params.descending = true
+	items, next, prev := paginate(notifications, notificationID, params)
+
+	res := notificationsResponse{page: page[database.Notification]{Items: items}, UnreadCount: unreadCount}
+	res.NextCursor, res.PrevCursor = setPageLinks(w, r, next, prev)
+
+	writeResponse(res, 200, w)
+}
+
+func (cfg *APIConfig) HandlerMarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeInvalidPathID(w, r)
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	notification, err := cfg.DB.MarkNotificationRead(userID, id)
+	if err != nil {
+		log.Printf("failed to mark notification %d as read: %s", id, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if notification == nil {
+		writeNotFound(w, r, fmt.Sprintf("Notification %d does not exist", id))
+		return
+	}
+
+	writeResponse(notification, 200, w)
+}
+
+func (cfg *APIConfig) HandlerMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.MarkAllNotificationsRead(userID); err != nil {
+		log.Printf("failed to mark notifications of user %d as read: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *APIConfig) HandlerGetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	preferences, err := cfg.DB.GetNotificationPreferences(userID)
+	if err != nil {
+		log.Printf("failed to get notification preferences of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(preferences, 200, w)
+}
+
+// Only changes the preferences that are in the request
+func (cfg *APIConfig) HandlerUpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	req := notificationPreferencesRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	preferences, err := cfg.DB.UpdateNotificationPreferences(userID, func(preferences *database.NotificationPreferences) {
+		if req.Reply != nil {
+			preferences.Reply = *req.Reply
+		}
+		if req.Mention != nil {
+			preferences.Mention = *req.Mention
+		}
+		if req.Follow != nil {
+			preferences.Follow = *req.Follow
+		}
+	})
+	if err != nil {
+		log.Printf("failed to update notification preferences of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writeResponse(preferences, 200, w)
+}
@@ -0,0 +1,670 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/moderation"
+	"github.com/mawkler/go-web-server/openapi"
+)
+
+func documented(op openapi.Operation) openapi.Operation {
+	op.ErrorContentType = "application/problem+json"
+
+	// Any authenticated request can fail because of an invalid token or a
+	// suspended account
+	if op.Security == openapi.SecurityBearer || op.Security == openapi.SecurityOptionalBearer {
+		if op.Responses == nil {
+			op.Responses = map[int]any{}
+		}
+		for _, status := range []int{401, 403} {
+			if _, exists := op.Responses[status]; !exists {
+				op.Responses[status] = problem{}
+			}
+		}
+	}
+
+	return op
+}
+
+var pageParameters = []openapi.Parameter{
+	{Name: "limit", Type: "integer", Description: fmt.Sprintf("Page size, at most %d", maxPageLimit)},
+	{Name: "after", Description: "Cursor from `next_cursor`"},
+	{Name: "before", Description: "Cursor from `prev_cursor`"},
+	{Name: "fields", Description: "Comma separated fields to include in each item"},
+}
+
+var editChirpOperation = documented(openapi.Operation{
+	Summary:     "Edit one of your chirps",
+	Description: "Only possible within the edit window after creating the chirp. Chirpy Red users get a longer edit window and longer chirps.",
+	Tags:        []string{"Chirps"},
+	Security:    openapi.SecurityBearer,
+	Request:     chirpRequest{},
+	Responses:   map[int]any{200: chirpResponse{}, 400: problem{}, 401: problem{}, 403: problem{}, 404: problem{}, 422: problem{}},
+})
+
+var updateUserOperation = documented(openapi.Operation{
+	Summary:     "Update your account and profile",
+	Description: "Only the fields in the request are changed.",
+	Tags:        []string{"Users"},
+	Security:    openapi.SecurityBearer,
+	Request:     updateUserRequest{},
+	Responses:   map[int]any{200: database.User{}, 400: problem{}, 401: problem{}, 404: problem{}, 409: problem{}},
+})
+
+var streamParameters = []openapi.Parameter{
+	{Name: "author_id", Description: "Comma separated IDs of authors to stream chirp events from"},
+	{Name: "hashtag", Description: "Comma separated hashtags to stream chirp events with"},
+	{Name: "last_event_id", Type: "integer", Description: "ID of the last received event, to resume after"},
+}
+
+// Likes and rechirps are idempotent and respond with the updated chirp
+func engagementOperation(summary string) openapi.Operation {
+	return documented(openapi.Operation{
+		Summary:   summary,
+		Tags:      []string{"Chirps"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: chirpResponse{}, 401: problem{}, 404: problem{}},
+	})
+}
+
+// OpenAPI documentation for each route, keyed by the route's pattern
+var Operations = map[string]openapi.Operation{
+	// Health
+	"GET /api/healthz": {
+		Summary:   "Health check",
+		Tags:      []string{"Health"},
+		Responses: map[int]any{200: nil},
+	},
+	"GET /admin/metrics": {
+		Summary:   "File server hit counter as an HTML page",
+		Tags:      []string{"Health"},
+		Responses: map[int]any{200: nil},
+	},
+	"GET /api/reset": {
+		Summary:   "Reset the file server hit counter",
+		Tags:      []string{"Health"},
+		Responses: map[int]any{200: nil},
+	},
+
+	// Documentation
+	"GET /api/openapi.json": {
+		Summary:   "This OpenAPI document",
+		Tags:      []string{"Documentation"},
+		Responses: map[int]any{200: map[string]any{}},
+	},
+	"GET /api/docs": {
+		Summary:   "Interactive API documentation",
+		Tags:      []string{"Documentation"},
+		Responses: map[int]any{200: nil},
+	},
+
+	// Authentication
+	"POST /api/login": documented(openapi.Operation{
+		Summary: "Log in with email and password",
+		Description: "Returns an access token and a refresh token. With `use_cookies`, the tokens are set as HttpOnly cookies instead and only the user is returned. " +
+			"After ten failed logins from an IP address within 15 minutes, its logins are rejected with 429 until the 15 minutes have passed. " +
+			"The IP address is taken from `X-Forwarded-For` only for requests from the proxies in `TRUSTED_PROXIES`. " +
+			"Wrong passwords are also counted on the account for admins to see, but never lock it.",
+		Tags:      []string{"Authentication"},
+		Request:   loginRequest{},
+		Responses: map[int]any{200: loginResponse{}, 400: problem{}, 401: problem{}, 403: problem{}, 429: problem{}},
+	}),
+	"POST /api/login/magic": documented(openapi.Operation{
+		Summary:     "Email a magic login link",
+		Description: "Always responds with 202, regardless of whether an account with the email exists.",
+		Tags:        []string{"Authentication"},
+		Request:     magicLinkRequest{},
+		Responses:   map[int]any{202: nil, 400: problem{}},
+	}),
+	"GET /api/login/magic/verify": documented(openapi.Operation{
+		Summary:     "Show a page for confirming a magic link login",
+		Description: "The link in the email. Doesn't use up the link, since mail scanners may open it; the page's button does that with a POST.",
+		Tags:        []string{"Authentication"},
+		Query:       []openapi.Parameter{{Name: "token", Required: true, Description: "Token from the login link"}},
+		Responses:   map[int]any{200: nil, 401: problem{}},
+	}),
+	"POST /api/login/magic/verify": documented(openapi.Operation{
+		Summary:     "Exchange a magic login link for tokens",
+		Description: "Uses up the link. Creates a passwordless account if none exists for the email.",
+		Tags:        []string{"Authentication"},
+		Query:       []openapi.Parameter{{Name: "token", Required: true, Description: "Token from the login link"}},
+		Responses:   map[int]any{200: loginResponse{}, 401: problem{}, 403: problem{}},
+	}),
+	"POST /api/refresh": documented(openapi.Operation{
+		Summary:   "Create a new access token from a refresh token",
+		Tags:      []string{"Authentication"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: refreshResponse{}, 204: nil, 401: problem{}},
+	}),
+	"POST /api/revoke": documented(openapi.Operation{
+		Summary:   "Revoke a refresh token",
+		Tags:      []string{"Authentication"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{204: nil, 401: problem{}},
+	}),
+	"GET /api/me": documented(openapi.Operation{
+		Summary:   "Get the authenticated user and information about their token",
+		Tags:      []string{"Authentication"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: tokenInfo{}, 401: problem{}},
+	}),
+
+	// Chirps
+	"POST /api/validate_chirp": documented(openapi.Operation{
+		Summary:     "Validate and clean a chirp without creating it",
+		Description: "Checks the length against your plan's limit when authenticated, and otherwise against the limit for users without Chirpy Red.",
+		Tags:        []string{"Chirps"},
+		Security:    openapi.SecurityOptionalBearer,
+		Request:     chirpRequest{},
+		Responses:   map[int]any{200: validateChirpResponse{}, 400: problem{}, 422: problem{}},
+	}),
+	"POST /api/chirps": documented(openapi.Operation{
+		Summary:     "Create a chirp, optionally as a reply to another chirp",
+		Description: "Images uploaded with `POST /api/media` can be attached by their IDs. Chirpy Red users can write longer chirps.",
+		Tags:        []string{"Chirps"},
+		Security:    openapi.SecurityBearer,
+		Request:     createChirpRequest{},
+		Responses:   map[int]any{201: chirpResponse{}, 400: problem{}, 401: problem{}, 403: problem{}, 422: problem{}},
+	}),
+	"DELETE /api/chirps/{id}": documented(openapi.Operation{
+		Summary:     "Delete one of your chirps",
+		Description: "Chirps with replies are replaced by a placeholder without body or author, so that the thread stays connected.",
+		Tags:        []string{"Chirps"},
+		Security:    openapi.SecurityBearer,
+		Responses:   map[int]any{204: nil, 401: problem{}, 403: problem{}, 404: problem{}},
+	}),
+	"GET /api/chirps": documented(openapi.Operation{
+		Summary:     "List chirps",
+		Description: "For authenticated users, leaves out chirps by users that they have blocked or muted, or that have blocked them.",
+		Tags:        []string{"Chirps"},
+		Security:    openapi.SecurityOptionalBearer,
+		Query: append([]openapi.Parameter{
+			{Name: "author_id", Description: "Comma separated IDs of authors to include chirps from"},
+			{Name: "created_after", Description: "RFC 3339 timestamp"},
+			{Name: "created_before", Description: "RFC 3339 timestamp"},
+			{Name: "contains", Description: "Case-insensitive text that the body has to contain"},
+			{Name: "sort", Description: "`asc` or `desc` by ID"},
+		}, pageParameters...),
+		Responses: map[int]any{200: page[chirpResponse]{}, 400: problem{}},
+	}),
+	"GET /api/chirps/{id}": documented(openapi.Operation{
+		Summary:     "Get a chirp",
+		Description: "Hidden chirps can only be opened by their authors and moderators.",
+		Tags:        []string{"Chirps"},
+		Security:    openapi.SecurityOptionalBearer,
+		Responses:   map[int]any{200: chirpResponse{}, 403: problem{}, 404: problem{}},
+	}),
+	"POST /api/chirps/{id}/report": documented(openapi.Operation{
+		Summary: "Report a chirp to the moderators",
+		Description: "`reason` is `spam`, `harassment`, `hate_speech`, `violence`, `misinformation` or `other`. " +
+			"Chirps reported by enough users are hidden until a moderator has reviewed them.",
+		Tags:      []string{"Chirps"},
+		Security:  openapi.SecurityBearer,
+		Request:   reportChirpRequest{},
+		Responses: map[int]any{201: database.Report{}, 400: problem{}, 401: problem{}, 403: problem{}, 404: problem{}, 409: problem{}},
+	}),
+	"POST /api/chirps/{id}/like":      engagementOperation("Like a chirp"),
+	"DELETE /api/chirps/{id}/like":    engagementOperation("Unlike a chirp"),
+	"POST /api/chirps/{id}/rechirp":   engagementOperation("Rechirp a chirp"),
+	"DELETE /api/chirps/{id}/rechirp": engagementOperation("Undo a rechirp"),
+	"GET /api/users/{id}/likes": documented(openapi.Operation{
+		Summary:     "List the chirps that a user has liked",
+		Description: "For authenticated users, leaves out chirps by users that they have blocked or muted, or that have blocked them.",
+		Tags:        []string{"Chirps"},
+		Security:    openapi.SecurityOptionalBearer,
+		Query:       pageParameters,
+		Responses:   map[int]any{200: page[chirpResponse]{}, 400: problem{}, 404: problem{}},
+	}),
+	"PUT /api/chirps/{id}":   editChirpOperation,
+	"PATCH /api/chirps/{id}": editChirpOperation,
+	"GET /api/chirps/{id}/history": documented(openapi.Operation{
+		Summary:   "Get the previous versions of a chirp, oldest first",
+		Tags:      []string{"Chirps"},
+		Security:  openapi.SecurityOptionalBearer,
+		Responses: map[int]any{200: []database.ChirpRevision{}, 404: problem{}},
+	}),
+	"GET /api/chirps/{id}/thread": documented(openapi.Operation{
+		Summary: "Get the conversation around a chirp",
+		Description: "Returns the chirps it replies to and a page of its replies, with their own replies nested up to `depth` levels down. " +
+			"Chirps by users that you have blocked or muted, or that have blocked you, are replaced by placeholders. The chirp itself can't be viewed if either of you has blocked the other.",
+		Tags:     []string{"Chirps"},
+		Security: openapi.SecurityOptionalBearer,
+		Query: append([]openapi.Parameter{
+			{Name: "depth", Description: fmt.Sprintf("How many levels of replies to include, between 1 and %d", maxThreadDepth)},
+		}, pageParameters...),
+		Responses: map[int]any{200: threadResponse{}, 400: problem{}, 403: problem{}, 404: problem{}},
+	}),
+
+	// Notifications
+	"GET /api/notifications": documented(openapi.Operation{
+		Summary:  "List your notifications, newest first",
+		Tags:     []string{"Notifications"},
+		Security: openapi.SecurityBearer,
+		Query: append([]openapi.Parameter{
+			{Name: "unread", Type: "boolean", Description: "Only list unread notifications"},
+		}, pageParameters[:3]...),
+		Responses: map[int]any{200: notificationsResponse{}, 400: problem{}, 401: problem{}},
+	}),
+	"POST /api/notifications/{id}/read": documented(openapi.Operation{
+		Summary:   "Mark a notification as read",
+		Tags:      []string{"Notifications"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: database.Notification{}, 401: problem{}, 404: problem{}},
+	}),
+	"POST /api/notifications/read": documented(openapi.Operation{
+		Summary:   "Mark all your notifications as read",
+		Tags:      []string{"Notifications"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{204: nil, 401: problem{}},
+	}),
+	"GET /api/notifications/preferences": documented(openapi.Operation{
+		Summary:   "Get which types of notifications you get",
+		Tags:      []string{"Notifications"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: database.NotificationPreferences{}, 401: problem{}},
+	}),
+	"PATCH /api/notifications/preferences": documented(openapi.Operation{
+		Summary:     "Change which types of notifications you get",
+		Description: "Types that are left out of the request are unchanged.",
+		Tags:        []string{"Notifications"},
+		Security:    openapi.SecurityBearer,
+		Request:     notificationPreferencesRequest{},
+		Responses:   map[int]any{200: database.NotificationPreferences{}, 400: problem{}, 401: problem{}},
+	}),
+
+	// Direct messages
+	"POST /api/conversations": documented(openapi.Operation{
+		Summary:     "Start a conversation with one or more users",
+		Description: "Responds with 200 and the existing conversation if you already have one with exactly these users.",
+		Tags:        []string{"Direct messages"},
+		Security:    openapi.SecurityBearer,
+		Request:     createConversationRequest{},
+		Responses:   map[int]any{200: database.ConversationSummary{}, 201: database.ConversationSummary{}, 400: problem{}, 401: problem{}, 403: problem{}},
+	}),
+	"GET /api/conversations": documented(openapi.Operation{
+		Summary:   "List your conversations, most recently active first",
+		Tags:      []string{"Direct messages"},
+		Security:  openapi.SecurityBearer,
+		Query:     pageParameters,
+		Responses: map[int]any{200: page[database.ConversationSummary]{}, 400: problem{}, 401: problem{}},
+	}),
+	"GET /api/conversations/{id}": documented(openapi.Operation{
+		Summary:   "Get one of your conversations",
+		Tags:      []string{"Direct messages"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: database.ConversationSummary{}, 401: problem{}, 404: problem{}},
+	}),
+	"GET /api/conversations/{id}/messages": documented(openapi.Operation{
+		Summary:   "List the messages in one of your conversations, newest first",
+		Tags:      []string{"Direct messages"},
+		Security:  openapi.SecurityBearer,
+		Query:     pageParameters,
+		Responses: map[int]any{200: page[database.DirectMessage]{}, 400: problem{}, 401: problem{}, 404: problem{}},
+	}),
+	"POST /api/conversations/{id}/messages": documented(openapi.Operation{
+		Summary:   "Send a message to one of your conversations",
+		Tags:      []string{"Direct messages"},
+		Security:  openapi.SecurityBearer,
+		Request:   directMessageRequest{},
+		Responses: map[int]any{201: database.DirectMessage{}, 400: problem{}, 401: problem{}, 403: problem{}, 404: problem{}},
+	}),
+	"POST /api/conversations/{id}/read": documented(openapi.Operation{
+		Summary:   "Mark all messages in one of your conversations as read",
+		Tags:      []string{"Direct messages"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{204: nil, 401: problem{}, 404: problem{}},
+	}),
+	"GET /api/conversations/preferences": documented(openapi.Operation{
+		Summary:   "Get who can send you direct messages",
+		Tags:      []string{"Direct messages"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: database.DirectMessagePreferences{}, 401: problem{}},
+	}),
+	"PUT /api/conversations/preferences": documented(openapi.Operation{
+		Summary:     "Change who can send you direct messages",
+		Description: "With `following`, only users you follow can start conversations with you or message you one-to-one.",
+		Tags:        []string{"Direct messages"},
+		Security:    openapi.SecurityBearer,
+		Request:     directMessagePreferencesRequest{},
+		Responses:   map[int]any{200: database.DirectMessagePreferences{}, 400: problem{}, 401: problem{}},
+	}),
+
+	// Streaming
+	"GET /api/stream": documented(openapi.Operation{
+		Summary: "Stream events as Server-Sent Events",
+		Description: "Pushes `chirp.created`, `chirp.deleted` and, to authenticated users, their own `notification.created` " +
+			"and `direct_message.created` events. " +
+			"Reconnecting clients resume after the event in the `Last-Event-ID` header or the `last_event_id` parameter, " +
+			"and get a `stream.resync` event if events have been missed since. " +
+			"Authenticated users don't get events by users that they have blocked or muted, or that have blocked them, as of connecting.",
+		Tags:      []string{"Streaming"},
+		Security:  openapi.SecurityOptionalBearer,
+		Query:     streamParameters,
+		Responses: map[int]any{200: nil, 400: problem{}},
+	}),
+	"GET /api/stream/ws": documented(openapi.Operation{
+		Summary:     "Stream events over a WebSocket",
+		Description: "Sends the same events as `/api/stream`, as JSON text messages with `id`, `event` and `data`.",
+		Tags:        []string{"Streaming"},
+		Security:    openapi.SecurityOptionalBearer,
+		Query:       streamParameters,
+		Responses:   map[int]any{101: nil, 400: problem{}, 403: problem{}},
+	}),
+
+	// Hashtags
+	"GET /api/hashtags/{tag}/chirps": documented(openapi.Operation{
+		Summary:     "List chirps with a hashtag",
+		Description: "For authenticated users, leaves out chirps by users that they have blocked or muted, or that have blocked them.",
+		Tags:        []string{"Hashtags"},
+		Security:    openapi.SecurityOptionalBearer,
+		Query:       pageParameters,
+		Responses:   map[int]any{200: page[chirpResponse]{}, 400: problem{}},
+	}),
+	"GET /api/hashtags/trending": documented(openapi.Operation{
+		Summary:     "List trending hashtags",
+		Description: "Hashtags used in the most chirps within a sliding window, along with their use in the window before it.",
+		Tags:        []string{"Hashtags"},
+		Query: []openapi.Parameter{
+			{Name: "window", Description: fmt.Sprintf("A duration such as `1h`, at most %s. Defaults to %s", maxTrendingWindow, defaultTrendingWindow)},
+			{Name: "limit", Type: "integer", Description: fmt.Sprintf("Between 1 and %d. Defaults to %d", maxTrendingLimit, defaultTrendingLimit)},
+		},
+		Responses: map[int]any{200: []database.TrendingHashtag{}, 400: problem{}},
+	}),
+
+	// Search
+	"GET /api/search/chirps": documented(openapi.Operation{
+		Summary: "Search chirps",
+		Description: "Words are matched after stemming and ranked by BM25. `\"quoted phrases\"`, `from:<user ID or handle>` " +
+			"and `#hashtags` are required to match.",
+		Tags:     []string{"Chirps"},
+		Security: openapi.SecurityOptionalBearer,
+		Query: []openapi.Parameter{
+			{Name: "q", Required: true, Description: "Search query"},
+			{Name: "limit", Type: "integer", Description: "Maximum number of results, at most 100"},
+		},
+		Responses: map[int]any{200: searchResponse{}, 400: problem{}},
+	}),
+
+	// Media
+	"POST /api/media": documented(openapi.Operation{
+		Summary: "Upload an image to attach to a chirp",
+		Description: fmt.Sprintf("JPEG, PNG and GIF images of up to %d bytes are accepted, based on their content. ", maxUploadSize) +
+			"Metadata like EXIF is removed, and a thumbnail is generated.",
+		Tags:               []string{"Media"},
+		Security:           openapi.SecurityBearer,
+		Request:            uploadMediaRequest{},
+		RequestContentType: "multipart/form-data",
+		Responses:          map[int]any{201: mediaResponse{}, 400: problem{}, 401: problem{}, 413: problem{}, 415: problem{}},
+	}),
+	"GET /api/media/{id}": documented(openapi.Operation{
+		Summary:   "Get an uploaded image",
+		Tags:      []string{"Media"},
+		Responses: map[int]any{200: mediaResponse{}, 404: problem{}},
+	}),
+
+	// Users
+	"POST /api/users": documented(openapi.Operation{
+		Summary:   "Create a user",
+		Tags:      []string{"Users"},
+		Request:   credentialsRequest{},
+		Responses: map[int]any{201: database.User{}, 400: problem{}, 409: problem{}},
+	}),
+	"GET /api/users": documented(openapi.Operation{
+		Summary:     "List users",
+		Description: "Emails are only included for yourself, or for everyone if you're an admin.",
+		Tags:        []string{"Users"},
+		Security:    openapi.SecurityOptionalBearer,
+		Query:       pageParameters,
+		Responses:   map[int]any{200: page[database.User]{}, 400: problem{}},
+	}),
+	"GET /api/users/{id}": documented(openapi.Operation{
+		Summary:     "Get a user's profile",
+		Description: "The email is only included for yourself, or if you're an admin.",
+		Tags:        []string{"Users"},
+		Security:    openapi.SecurityOptionalBearer,
+		Responses:   map[int]any{200: userProfile{}, 404: problem{}},
+	}),
+	"GET /api/handles/{handle}": documented(openapi.Operation{
+		Summary:     "Get a user's profile by their handle",
+		Description: "Handles are case-insensitive. The email is only included for yourself, or if you're an admin.",
+		Tags:        []string{"Users"},
+		Security:    openapi.SecurityOptionalBearer,
+		Responses:   map[int]any{200: userProfile{}, 404: problem{}},
+	}),
+	"POST /api/users/{id}/follow": documented(openapi.Operation{
+		Summary:   "Follow a user",
+		Tags:      []string{"Follows"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{204: nil, 400: problem{}, 401: problem{}, 403: problem{}, 404: problem{}},
+	}),
+	"DELETE /api/users/{id}/follow": documented(openapi.Operation{
+		Summary:   "Unfollow a user",
+		Tags:      []string{"Follows"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{204: nil, 401: problem{}, 404: problem{}},
+	}),
+	"GET /api/users/{id}/followers": documented(openapi.Operation{
+		Summary:   "List the users that follow a user",
+		Tags:      []string{"Follows"},
+		Security:  openapi.SecurityOptionalBearer,
+		Query:     pageParameters,
+		Responses: map[int]any{200: page[database.User]{}, 400: problem{}, 404: problem{}},
+	}),
+	"GET /api/users/{id}/following": documented(openapi.Operation{
+		Summary:   "List the users that a user follows",
+		Tags:      []string{"Follows"},
+		Security:  openapi.SecurityOptionalBearer,
+		Query:     pageParameters,
+		Responses: map[int]any{200: page[database.User]{}, 400: problem{}, 404: problem{}},
+	}),
+	"GET /api/timeline": documented(openapi.Operation{
+		Summary:     "Your home timeline",
+		Description: "Chirps by you and the users you follow and haven't muted, newest first. Page with `after`.",
+		Tags:        []string{"Follows"},
+		Security:    openapi.SecurityBearer,
+		Query:       pageParameters,
+		Responses:   map[int]any{200: page[chirpResponse]{}, 400: problem{}, 401: problem{}},
+	}),
+	"POST /api/users/{id}/block": documented(openapi.Operation{
+		Summary:     "Block a user",
+		Description: "Hides both users' chirps from each other, removes follows between them, and keeps them from following, replying to, mentioning and messaging each other.",
+		Tags:        []string{"Blocks and mutes"},
+		Security:    openapi.SecurityBearer,
+		Responses:   map[int]any{204: nil, 400: problem{}, 401: problem{}, 404: problem{}},
+	}),
+	"DELETE /api/users/{id}/block": documented(openapi.Operation{
+		Summary:   "Unblock a user",
+		Tags:      []string{"Blocks and mutes"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{204: nil, 400: problem{}, 401: problem{}, 404: problem{}},
+	}),
+	"POST /api/users/{id}/mute": documented(openapi.Operation{
+		Summary:     "Mute a user",
+		Description: "Hides the user's chirps from your chirp lists and timeline, without them knowing.",
+		Tags:        []string{"Blocks and mutes"},
+		Security:    openapi.SecurityBearer,
+		Responses:   map[int]any{204: nil, 400: problem{}, 401: problem{}, 404: problem{}},
+	}),
+	"DELETE /api/users/{id}/mute": documented(openapi.Operation{
+		Summary:   "Unmute a user",
+		Tags:      []string{"Blocks and mutes"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{204: nil, 400: problem{}, 401: problem{}, 404: problem{}},
+	}),
+	"GET /api/users/me/blocks": documented(openapi.Operation{
+		Summary:   "List the users you have blocked",
+		Tags:      []string{"Blocks and mutes"},
+		Security:  openapi.SecurityBearer,
+		Query:     pageParameters,
+		Responses: map[int]any{200: page[database.User]{}, 400: problem{}, 401: problem{}},
+	}),
+	"GET /api/users/me/mutes": documented(openapi.Operation{
+		Summary:   "List the users you have muted",
+		Tags:      []string{"Blocks and mutes"},
+		Security:  openapi.SecurityBearer,
+		Query:     pageParameters,
+		Responses: map[int]any{200: page[database.User]{}, 400: problem{}, 401: problem{}},
+	}),
+	"PUT /api/users":   updateUserOperation,
+	"PATCH /api/users": updateUserOperation,
+	"DELETE /api/users/me": documented(openapi.Operation{
+		Summary: "Delete your account",
+		Description: "Requires your password. The account is deleted along with your chirps, likes, rechirps and follows " +
+			"after a grace period, and logging in before then cancels the deletion. All your sessions are ended.",
+		Tags:      []string{"Users"},
+		Security:  openapi.SecurityBearer,
+		Request:   deleteAccountRequest{},
+		Responses: map[int]any{202: deleteAccountResponse{}, 400: problem{}, 401: problem{}, 403: problem{}},
+	}),
+	"GET /api/users/me/export": documented(openapi.Operation{
+		Summary:     "Export everything stored about you",
+		Description: "Returns a zip archive with your data in `data.json` and your uploaded images in `media/`.",
+		Tags:        []string{"Users"},
+		Security:    openapi.SecurityBearer,
+		Responses:   map[int]any{200: nil, 401: problem{}, 403: problem{}},
+	}),
+	"GET /api/users/me/subscription": documented(openapi.Operation{
+		Summary:     "Get your Chirpy Red subscription",
+		Description: "Includes the subscription's history and what your plan currently lets you do.",
+		Tags:        []string{"Users"},
+		Security:    openapi.SecurityBearer,
+		Responses:   map[int]any{200: subscriptionResponse{}, 401: problem{}},
+	}),
+
+	// Webhooks
+	"POST /api/polka/webhooks": documented(openapi.Operation{
+		Summary: "Polka payment events",
+		Description: "Handles `user.upgraded`, `user.renewed`, `user.canceled` and `user.downgraded`, and ignores other events. " +
+			"Upgrades don't shorten the current period, and keep subscriptions without an end, such as granted ones, that way. " +
+			"Canceled subscriptions last until the end of the paid period, or for 30 days if they had no end. " +
+			"Retried renewals are ignored: ones with `period_end` when it's already the current period end, and ones without when more than 30 days are left.",
+		Tags:      []string{"Webhooks"},
+		Security:  openapi.SecurityAPIKey,
+		Request:   polkaWebhookRequest{},
+		Responses: map[int]any{204: nil, 401: problem{}, 404: problem{}},
+	}),
+
+	// Admin
+	"POST /admin/users/{id}/impersonate": documented(openapi.Operation{
+		Summary:     "Create an impersonation token for a user",
+		Description: "The token is a short-lived access token with an `act` claim naming the admin. Requests made with it are recorded in the audit log.",
+		Tags:        []string{"Admin"},
+		Security:    openapi.SecurityBearer,
+		Responses:   map[int]any{200: impersonationResponse{}, 403: problem{}, 404: problem{}},
+	}),
+	"GET /admin/users": documented(openapi.Operation{
+		Summary:  "Search users by email or handle",
+		Tags:     []string{"Admin"},
+		Security: openapi.SecurityBearer,
+		Query: append([]openapi.Parameter{
+			{Name: "q", Description: "Case-insensitive text that the email or handle has to contain", Required: true},
+		}, pageParameters...),
+		Responses: map[int]any{200: page[database.User]{}, 400: problem{}, 403: problem{}},
+	}),
+	"GET /admin/users/{id}": documented(openapi.Operation{
+		Summary:     "Get a user's account state",
+		Description: "Includes the user's role, suspension, failed logins in a row, scheduled deletion and sessions.",
+		Tags:        []string{"Admin"},
+		Security:    openapi.SecurityBearer,
+		Responses:   map[int]any{200: accountStateResponse{}, 403: problem{}, 404: problem{}},
+	}),
+	"POST /admin/users/{id}/suspend": documented(openapi.Operation{
+		Summary:     "Suspend a user",
+		Description: "Ends the user's sessions. Suspended users can't log in, and their access tokens are rejected with `account_suspended`.",
+		Tags:        []string{"Admin"},
+		Security:    openapi.SecurityBearer,
+		Request:     suspendUserRequest{},
+		Responses:   map[int]any{200: accountStateResponse{}, 400: problem{}, 403: problem{}, 404: problem{}},
+	}),
+	"POST /admin/users/{id}/reactivate": documented(openapi.Operation{
+		Summary:     "Reactivate a user",
+		Description: "Lifts the user's suspension.",
+		Tags:        []string{"Admin"},
+		Security:    openapi.SecurityBearer,
+		Responses:   map[int]any{200: accountStateResponse{}, 403: problem{}, 404: problem{}},
+	}),
+	"POST /admin/users/{id}/password-reset": documented(openapi.Operation{
+		Summary:     "Force a password reset",
+		Description: "Removes the user's password, ends their sessions and emails them a login link so that they can set a new one.",
+		Tags:        []string{"Admin"},
+		Security:    openapi.SecurityBearer,
+		Responses:   map[int]any{200: accountStateResponse{}, 403: problem{}, 404: problem{}},
+	}),
+	"PUT /admin/users/{id}/role": documented(openapi.Operation{
+		Summary:     "Change a user's role",
+		Description: "`role` is `user`, `moderator` or `admin`. The first admin is made with the `-make-admin` flag.",
+		Tags:        []string{"Admin"},
+		Security:    openapi.SecurityBearer,
+		Request:     setRoleRequest{},
+		Responses:   map[int]any{200: accountStateResponse{}, 400: problem{}, 403: problem{}, 404: problem{}},
+	}),
+	"POST /admin/users/{id}/chirpy-red": documented(openapi.Operation{
+		Summary:   "Grant Chirpy Red to a user",
+		Tags:      []string{"Admin"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: accountStateResponse{}, 403: problem{}, 404: problem{}},
+	}),
+	"DELETE /admin/users/{id}/chirpy-red": documented(openapi.Operation{
+		Summary:   "Revoke Chirpy Red from a user",
+		Tags:      []string{"Admin"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: accountStateResponse{}, 403: problem{}, 404: problem{}},
+	}),
+	"GET /admin/audit": documented(openapi.Operation{
+		Summary:   "Get the audit log",
+		Tags:      []string{"Admin"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: []database.AuditEvent{}, 403: problem{}},
+	}),
+	"POST /admin/introspect": documented(openapi.Operation{
+		Summary:   "Validate a token and describe it",
+		Tags:      []string{"Admin"},
+		Security:  openapi.SecurityBearer,
+		Request:   introspectionRequest{},
+		Responses: map[int]any{200: introspectionResponse{}, 403: problem{}},
+	}),
+	"GET /admin/moderation/rules": documented(openapi.Operation{
+		Summary:   "List the profanity filter's rules",
+		Tags:      []string{"Admin"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: []moderation.Rule{}, 403: problem{}},
+	}),
+	"PUT /admin/moderation/rules/{word}": documented(openapi.Operation{
+		Summary:     "Add or replace the rule for a word",
+		Description: "`action` is `mask`, `flag` or `reject`.",
+		Tags:        []string{"Admin"},
+		Security:    openapi.SecurityBearer,
+		Request:     moderationRuleRequest{},
+		Responses:   map[int]any{200: moderation.Rule{}, 400: problem{}, 403: problem{}},
+	}),
+	"DELETE /admin/moderation/rules/{word}": documented(openapi.Operation{
+		Summary:   "Remove the rule for a word",
+		Tags:      []string{"Admin"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{204: nil, 403: problem{}, 404: problem{}},
+	}),
+	"GET /admin/moderation/flags": documented(openapi.Operation{
+		Summary:   "List chirps flagged for review by the profanity filter",
+		Tags:      []string{"Admin"},
+		Security:  openapi.SecurityBearer,
+		Responses: map[int]any{200: []database.ChirpFlag{}, 403: problem{}},
+	}),
+	"GET /admin/moderation/reports": documented(openapi.Operation{
+		Summary:     "List reported chirps along with their unresolved reports",
+		Description: "Available to moderators and admins.",
+		Tags:        []string{"Admin"},
+		Security:    openapi.SecurityBearer,
+		Query:       pageParameters,
+		Responses:   map[int]any{200: page[database.ReportedChirp]{}, 400: problem{}, 403: problem{}},
+	}),
+	"POST /admin/moderation/chirps/{id}/actions": documented(openapi.Operation{
+		Summary: "Act on a chirp",
+		Description: "`action` is `dismiss`, `hide`, `delete` or `warn`. Dismissing unhides the chirp, and warnings need a `note`. " +
+			"Resolves the chirp's open reports and notifies its author. Available to moderators and admins.",
+		Tags:      []string{"Admin"},
+		Security:  openapi.SecurityBearer,
+		Request:   moderateChirpRequest{},
+		Responses: map[int]any{200: moderationResponse{}, 400: problem{}, 403: problem{}, 404: problem{}},
+	}),
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+type page[T any] struct {
+	Items      []T     `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+	PrevCursor *string `json:"prev_cursor"`
+	// Size of the whole collection, for collections where it's cheap to count
+	Total *int `json:"total,omitempty"`
+}
+
+type pageParams struct {
+	limit      int
+	after      *int
+	before     *int
+	fields     []string
+	descending bool
+}
+
+// Cursors are opaque to clients, but are just base64 encoded IDs
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("id:%d", id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+
+	idString, found := strings.CutPrefix(string(decoded), "id:")
+	if !found {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+
+	return strconv.Atoi(idString)
+}
+
+// Parses `limit`, `after`, `before` and `fields`. Returns field errors for
+// invalid parameters
+func parsePageParams(r *http.Request) (pageParams, []fieldError) {
+	query := r.URL.Query()
+	params := pageParams{limit: defaultPageLimit}
+	fieldErrors := []fieldError{}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			fieldErrors = append(fieldErrors, fieldError{
+				Field:   "limit",
+				Code:    "out_of_range",
+				Message: fmt.Sprintf("Must be an integer between 1 and %d", maxPageLimit),
+			})
+		}
+		params.limit = n
+	}
+
+	for _, name := range []string{"after", "before"} {
+		cursor := query.Get(name)
+		if cursor == "" {
+			continue
+		}
+
+		id, err := decodeCursor(cursor)
+		if err != nil {
+			fieldErrors = append(fieldErrors, fieldError{Field: name, Code: "invalid_cursor", Message: "Invalid cursor"})
+			continue
+		}
+
+		if name == "after" {
+			params.after = &id
+		} else {
+			params.before = &id
+		}
+	}
+
+	if params.after != nil && params.before != nil {
+		fieldErrors = append(fieldErrors, fieldError{
+			Field:   "before",
+			Code:    "conflict",
+			Message: "`after` and `before` can't be combined",
+		})
+	}
+
+	if fields := query.Get("fields"); fields != "" {
+		params.fields = strings.Split(fields, ",")
+	}
+
+	return params, fieldErrors
+}
+
+// Index of the first item that comes after the item with the given ID. The
+// item with the ID doesn't need to exist anymore
+func cursorPosition[T any](items []T, id func(T) int, cursorID int, descending bool) int {
+	for i, item := range items {
+		if descending && id(item) < cursorID || !descending && id(item) > cursorID {
+			return i
+		}
+	}
+	return len(items)
+}
+
+// Returns the page of items described by params. Items have to already be
+// sorted by ID, and id has to return an item's ID. Also returns the cursors to
+// the next and previous pages, which are empty if there is no such page
+func paginate[T any](items []T, id func(T) int, params pageParams) ([]T, string, string) {
+	start, end := 0, len(items)
+
+	if params.after != nil {
+		start = cursorPosition(items, id, *params.after, params.descending)
+		end = min(start+params.limit, len(items))
+	} else if params.before != nil {
+		end = cursorPosition(items, id, *params.before, params.descending)
+		if end > 0 && id(items[end-1]) == *params.before {
+			end--
+		}
+		start = max(end-params.limit, 0)
+	} else {
+		end = min(params.limit, len(items))
+	}
+
+	pageItems := items[start:end]
+
+	next, prev := "", ""
+	if end < len(items) && len(pageItems) > 0 {
+		next = encodeCursor(id(pageItems[len(pageItems)-1]))
+	}
+	if start > 0 && len(pageItems) > 0 {
+		prev = encodeCursor(id(pageItems[0]))
+	}
+
+	return pageItems, next, prev
+}
+
+// The JSON field names of a struct type, including promoted fields
+func jsonFieldNames(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	names := []string{}
+	if t.Kind() != reflect.Struct {
+		return names
+	}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+		if field.Anonymous && strings.Split(field.Tag.Get("json"), ",")[0] == "" {
+			names = append(names, jsonFieldNames(field.Type)...)
+		} else if field.IsExported() {
+			names = append(names, jsonFieldName(field))
+		}
+	}
+
+	return names
+}
+
+// Keeps only the given JSON fields of each item. Returns an error naming the
+// first field that doesn't exist
+func projectFields[T any](items []T, fields []string) ([]any, error) {
+	knownFields := jsonFieldNames(reflect.TypeFor[T]())
+	for _, field := range fields {
+		if !slices.Contains(knownFields, field) {
+			return nil, fmt.Errorf("unknown field %s", field)
+		}
+	}
+
+	projected := make([]any, 0, len(items))
+
+	for _, item := range items {
+		marshalled, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+
+		full := map[string]any{}
+		if err := json.Unmarshal(marshalled, &full); err != nil {
+			return nil, err
+		}
+
+		selected := map[string]any{}
+		for _, field := range fields {
+			if value, exists := full[field]; exists {
+				selected[field] = value
+			}
+		}
+		projected = append(projected, selected)
+	}
+
+	return projected, nil
+}
+
+func pageLink(r *http.Request, direction, cursor string, rel string) string {
+	query := r.URL.Query()
+	query.Del("after")
+	query.Del("before")
+	query.Set(direction, cursor)
+
+	link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return fmt.Sprintf(`<%s>; rel="%s"`, link.String(), rel)
+}
+
+// Paginates and projects items and writes them along with Link headers
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T, id func(T) int, params pageParams) {
+	pageItems, next, prev := paginate(items, id, params)
+	writePageItems(w, r, pageItems, next, prev, params, nil)
+}
+
+// Projects an already paginated page of items and writes it along with Link
+// headers
+func writePageItems[T any](w http.ResponseWriter, r *http.Request, pageItems []T, next, prev string, params pageParams, total *int) {
+	res := page[any]{Items: make([]any, 0, len(pageItems)), Total: total}
+	if len(params.fields) > 0 {
+		projected, err := projectFields(pageItems, params.fields)
+		if err != nil {
+			writeValidationProblem(w, r, []fieldError{{Field: "fields", Code: "unknown_field", Message: err.Error()}})
+			return
+		}
+		res.Items = projected
+	} else {
+		for _, item := range pageItems {
+			res.Items = append(res.Items, item)
+		}
+	}
+
+	res.NextCursor, res.PrevCursor = setPageLinks(w, r, next, prev)
+
+	writeResponse(res, 200, w)
+}
+
+// Sets the Link header for the cursors that aren't empty, and returns them as
+// optional cursors
+func setPageLinks(w http.ResponseWriter, r *http.Request, next, prev string) (*string, *string) {
+	var nextCursor, prevCursor *string
+
+	links := []string{}
+	if next != "" {
+		nextCursor = &next
+		links = append(links, pageLink(r, "after", next, "next"))
+	}
+	if prev != "" {
+		prevCursor = &prev
+		links = append(links, pageLink(r, "before", prev, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	return nextCursor, prevCursor
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/events"
+)
+
+type reportChirpRequest struct {
+	Reason  database.ReportReason `json:"reason" validate:"required"`
+	Details string                `json:"details,omitempty" validate:"max=500"`
+}
+
+type moderateChirpRequest struct {
+	Action database.ModerationAction `json:"action" validate:"required"`
+	// Shown to the author. Required for warnings
+	Note string `json:"note,omitempty" validate:"max=500"`
+}
+
+type moderationResponse struct {
+	ChirpID         int                       `json:"chirp_id"`
+	Action          database.ModerationAction `json:"action"`
+	ModeratorID     int                       `json:"moderator_id"`
+	Note            string                    `json:"note,omitempty"`
+	ResolvedReports int                       `json:"resolved_reports"`
+}
+
+// The audit log actions of each moderation action
+var moderationAuditActions = map[database.ModerationAction]string{
+	database.ModerationDismiss: "reports_dismissed",
+	database.ModerationHide:    "chirp_hidden",
+	database.ModerationDelete:  "chirp_deleted",
+	database.ModerationWarn:    "author_warned",
+}
+
+func reportedChirpID(reported database.ReportedChirp) int {
+	return reported.Chirp.ID
+}
+
+// Formats values as a list like `"a", "b" or "c"`
+func quotedList[T ~string](values []T) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, strconv.Quote(string(value)))
+	}
+	if len(quoted) < 2 {
+		return strings.Join(quoted, "")
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+}
+
+// Hidden chirps can only be opened by their authors and moderators. Failing to
+// check counts as not visible
+func (cfg *APIConfig) visibleChirp(r *http.Request, chirp database.Chirp) bool {
+	if !chirp.Hidden {
+		return true
+	}
+
+	viewerID, authenticated := viewerID(r)
+	if !authenticated {
+		return false
+	}
+	if viewerID == chirp.AuthorID {
+		return true
+	}
+
+	isModerator, err := cfg.isModerator(viewerID)
+	if err != nil {
+		log.Print(err)
+	}
+	return isModerator
+}
+
+func (cfg *APIConfig) HandlerReportChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeInvalidPathID(w, r)
+		return
+	}
+
+	req := reportChirpRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if !slices.Contains(database.ReportReasons, req.Reason) {
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "reason",
+			Code:    "invalid_value",
+			Message: "Must be " + quotedList(database.ReportReasons),
+		}})
+		return
+	}
+
+	reporterID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	report, hidden, err := cfg.DB.ReportChirp(chirpID, reporterID, req.Reason, req.Details, cfg.reportHideThreshold)
+	if errors.Is(err, database.ErrChirpNotFound) {
+		writeNotFound(w, r, fmt.Sprintf("Chirp %d does not exist", chirpID))
+		return
+	}
+	if errors.Is(err, database.ErrOwnChirp) {
+		writeProblem(w, r, 403, codeForbidden, "You can't report your own chirps")
+		return
+	}
+	if errors.Is(err, database.ErrAlreadyReported) {
+		writeProblem(w, r, 409, codeConflict, "You have already reported this chirp")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to report chirp %d: %s", chirpID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if hidden {
+		log.Printf("chirp %d was hidden after being reported by %d users", chirpID, cfg.reportHideThreshold)
+		chirp, err := cfg.DB.GetChirp(chirpID)
+		if err != nil || chirp == nil {
+			log.Printf("failed to get chirp %d: %s", chirpID, err)
+		} else {
+			cfg.events.Publish(events.ChirpModerated{
+				ChirpID:  chirpID,
+				AuthorID: chirp.AuthorID,
+				Action:   database.ModerationHide,
+				Note:     "Hidden automatically after being reported by several users, until a moderator has reviewed it",
+			})
+		}
+	}
+
+	writeResponse(report, 201, w)
+}
+
+// Lists the chirps with unresolved reports, along with the reports
+func (cfg *APIConfig) HandlerGetReportQueue(w http.ResponseWriter, r *http.Request) {
+	params, fieldErrors := parsePageParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	queue, err := cfg.DB.GetReportQueue()
+	if err != nil {
+		log.Printf("failed to get report queue: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	writePage(w, r, queue, reportedChirpID, params)
+}
+
+// Dismisses the reports on a chirp, hides or deletes the chirp, or warns its
+// author. The chirp's open reports are resolved, and the author is notified
+func (cfg *APIConfig) HandlerModerateChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeInvalidPathID(w, r)
+		return
+	}
+
+	req := moderateChirpRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if !slices.Contains(database.ModerationActions, req.Action) {
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "action",
+			Code:    "invalid_value",
+			Message: "Must be " + quotedList(database.ModerationActions),
+		}})
+		return
+	}
+	if req.Action == database.ModerationWarn && req.Note == "" {
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   "note",
+			Code:    "required",
+			Message: "Warnings need a note for the author",
+		}})
+		return
+	}
+
+	moderatorID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	chirp, resolved, attachments, err := cfg.DB.ModerateChirp(chirpID, moderatorID, req.Action, req.Note)
+	if errors.Is(err, database.ErrChirpNotFound) {
+		writeNotFound(w, r, fmt.Sprintf("Chirp %d does not exist", chirpID))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to moderate chirp %d: %s", chirpID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	log.Printf("moderator %d: %s on chirp %d", moderatorID, req.Action, chirpID)
+	details := fmt.Sprintf("chirp %d", chirpID)
+	if req.Note != "" {
+		details = fmt.Sprintf("chirp %d: %s", chirpID, req.Note)
+	}
+	if err := cfg.DB.RecordAuditEvent(moderatorID, chirp.AuthorID, moderationAuditActions[req.Action], details); err != nil {
+		log.Printf("failed to record moderation of chirp %d in audit log: %s", chirpID, err)
+	}
+
+	if req.Action == database.ModerationDelete {
+		for _, attachment := range attachments {
+			cfg.deleteBlobs(attachment.Key, attachment.ThumbnailKey)
+		}
+		cfg.events.Publish(events.ChirpDeleted{Chirp: *chirp})
+	}
+
+	// Authors don't know about reports on their chirps, so dismissals are only
+	// worth notifying about if they unhid the chirp
+	if req.Action != database.ModerationDismiss || chirp.Hidden {
+		cfg.events.Publish(events.ChirpModerated{
+			ChirpID:     chirpID,
+			AuthorID:    chirp.AuthorID,
+			ModeratorID: moderatorID,
+			Action:      req.Action,
+			Note:        req.Note,
+		})
+	}
+
+	res := moderationResponse{
+		ChirpID:         chirpID,
+		Action:          req.Action,
+		ModeratorID:     moderatorID,
+		Note:            req.Note,
+		ResolvedReports: resolved,
+	}
+	writeResponse(res, 200, w)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/search"
+)
+
+// A search result along with the authenticated user's engagement, like
+// chirpResponse
+type searchResultResponse struct {
+	chirpResponse
+	Score float64 `json:"score"`
+}
+
+type searchResponse struct {
+	Items []searchResultResponse `json:"items"`
+	Total int                    `json:"total"`
+}
+
+func (cfg *APIConfig) HandlerSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := search.ParseQuery(r.URL.Query().Get("q"))
+	if query.IsEmpty() {
+		writeValidationProblem(w, r, []fieldError{{Field: "q", Code: "required", Message: "Query must not be empty"}})
+		return
+	}
+
+	limit := 20
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		n, err := strconv.Atoi(limitString)
+		if err != nil || n < 1 || n > maxPageLimit {
+			writeValidationProblem(w, r, []fieldError{{
+				Field:   "limit",
+				Code:    "out_of_range",
+				Message: fmt.Sprintf("Must be an integer between 1 and %d", maxPageLimit),
+			}})
+			return
+		}
+		limit = n
+	}
+
+	results, total, err := cfg.DB.SearchChirps(query, limit)
+	if err != nil {
+		log.Printf("failed to search chirps: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	chirps := make([]database.Chirp, 0, len(results))
+	for _, result := range results {
+		chirps = append(chirps, result.Chirp)
+	}
+	responses, err := cfg.withEngagement(r, chirps)
+	if err != nil {
+		log.Printf("failed to get engagement with search results: %s", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	res := searchResponse{Items: make([]searchResultResponse, 0, len(results)), Total: total}
+	for i, result := range results {
+		res.Items = append(res.Items, searchResultResponse{chirpResponse: responses[i], Score: result.Score})
+	}
+	writeResponse(res, 200, w)
+}
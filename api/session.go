@@ -0,0 +1,59 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+)
+
+const (
+	accessTokenCookie  = "chirpy_access"
+	refreshTokenCookie = "chirpy_refresh"
+	csrfTokenCookie    = "chirpy_csrf"
+	csrfTokenHeader    = "X-CSRF-Token"
+)
+
+func newSessionCookie(name, value string, maxAge time.Duration, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: httpOnly,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// The CSRF cookie is deliberately readable from JavaScript so that the
+// frontend can echo it back in the X-CSRF-Token header (double-submit)
+func setSessionCookies(w http.ResponseWriter, accessToken, refreshToken, csrfToken string, accessTokenExpiry time.Duration) {
+	refreshTokenExpiry := 60 * 24 * time.Hour
+	http.SetCookie(w, newSessionCookie(accessTokenCookie, accessToken, accessTokenExpiry, true))
+	http.SetCookie(w, newSessionCookie(refreshTokenCookie, refreshToken, refreshTokenExpiry, true))
+	http.SetCookie(w, newSessionCookie(csrfTokenCookie, csrfToken, refreshTokenExpiry, false))
+}
+
+func setAccessTokenCookie(w http.ResponseWriter, accessToken string, expiresIn time.Duration) {
+	http.SetCookie(w, newSessionCookie(accessTokenCookie, accessToken, expiresIn, true))
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{accessTokenCookie, refreshTokenCookie, csrfTokenCookie} {
+		http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1, Secure: true})
+	}
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfTokenCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	header := r.Header.Get(csrfTokenHeader)
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
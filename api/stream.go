@@ -0,0 +1,264 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mawkler/go-web-server/entities"
+	"github.com/mawkler/go-web-server/stream"
+)
+
+const (
+	// Keeps proxies from closing idle connections
+	streamHeartbeatInterval = 15 * time.Second
+	// How long SSE clients wait before reconnecting
+	streamRetryDelay = 3 * time.Second
+)
+
+// Sent when a client resumes from an event that has already been dropped from
+// the replay buffer, so that it knows to reload its state
+const eventStreamResync = "stream.resync"
+
+// The shape of WebSocket messages. SSE sends the same fields as the `id`,
+// `event` and `data` fields of each event
+type streamMessage struct {
+	ID    *int64          `json:"id,omitempty"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// Parses the filters and the event ID to resume from. The ID is taken from the
+// `Last-Event-ID` header that EventSource sends when reconnecting, or from the
+// `last_event_id` parameter
+func parseStreamParams(r *http.Request) (stream.Filter, *int64, []fieldError) {
+	query := r.URL.Query()
+	fieldErrors := []fieldError{}
+
+	authorIDs, err := parseIDList(query, "author_id")
+	if err != nil {
+		fieldErrors = append(fieldErrors, *err)
+	}
+
+	hashtags, hashtagErrors := parseHashtagList(query)
+	fieldErrors = append(fieldErrors, hashtagErrors...)
+
+	var lastEventID *int64
+	lastEventIDString := r.Header.Get("Last-Event-ID")
+	if lastEventIDString == "" {
+		lastEventIDString = query.Get("last_event_id")
+	}
+	if lastEventIDString != "" {
+		id, err := strconv.ParseInt(lastEventIDString, 10, 64)
+		if err != nil || id < 0 {
+			fieldErrors = append(fieldErrors, fieldError{
+				Field:   "last_event_id",
+				Code:    "invalid_type",
+				Message: "Must be the ID of a previously received event",
+			})
+		}
+		lastEventID = &id
+	}
+
+	filter := stream.Filter{AuthorIDs: authorIDs, Hashtags: hashtags}
+	if userID, ok := viewerID(r); ok {
+		filter.UserID = userID
+	}
+
+	return filter, lastEventID, fieldErrors
+}
+
+func parseHashtagList(query url.Values) ([]string, []fieldError) {
+	hashtags := []string{}
+	for _, list := range query["hashtag"] {
+		for _, hashtag := range strings.Split(list, ",") {
+			normalized := entities.NormalizeHashtag(strings.TrimSpace(hashtag))
+			if normalized == "" {
+				return nil, []fieldError{{
+					Field:   "hashtag",
+					Code:    "invalid_value",
+					Message: fmt.Sprintf("%q is not a valid hashtag", hashtag),
+				}}
+			}
+			hashtags = append(hashtags, normalized)
+		}
+	}
+	return hashtags, nil
+}
+
+func writeServerSentEvent(w http.ResponseWriter, message stream.Message) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", message.ID, message.Event, message.Data)
+}
+
+// Streams events as Server-Sent Events until the client disconnects. Clients
+// that fall too far behind get disconnected, and catch up on the missed events
+// when EventSource reconnects
+func (cfg *APIConfig) HandlerStream(w http.ResponseWriter, r *http.Request) {
+	filter, lastEventID, fieldErrors := parseStreamParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	hidden, ok := cfg.viewerHiddenUserIDs(w, r)
+	if !ok {
+		return
+	}
+	filter.HiddenAuthorIDs = hidden
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Print("response writer does not support flushing")
+		writeInternalError(w, r)
+		return
+	}
+
+	sub := cfg.broker.Subscribe(filter, lastEventID)
+	defer cfg.broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryDelay.Milliseconds())
+	if sub.ReplayIncomplete {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventStreamResync)
+	}
+	for _, message := range sub.Replay {
+		writeServerSentEvent(w, message)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case message, ok := <-sub.Messages:
+			if !ok {
+				if sub.Overflowed() {
+					log.Printf("disconnected slow stream client %s", r.RemoteAddr)
+				}
+				return
+			}
+			writeServerSentEvent(w, message)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// Cookies are sent along with cross-site WebSocket handshakes, and browsers
+// don't apply CORS to them, so cookie authenticated connections have to come
+// from the same origin
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	return err == nil && originURL.Host == r.Host
+}
+
+// Streams the same events as HandlerStream over a WebSocket. Each message is a
+// JSON object with the event's `id`, `event` and `data`
+func (cfg *APIConfig) HandlerStreamWebSocket(w http.ResponseWriter, r *http.Request) {
+	filter, lastEventID, fieldErrors := parseStreamParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	hidden, ok := cfg.viewerHiddenUserIDs(w, r)
+	if !ok {
+		return
+	}
+	filter.HiddenAuthorIDs = hidden
+
+	if !stream.IsWebSocketUpgrade(r) {
+		writeProblem(w, r, 400, codeInvalidParameter, "Request is not a WebSocket upgrade")
+		return
+	}
+
+	if isCookieSession(r) && !sameOrigin(r) {
+		writeProblem(w, r, 403, codeForbidden, "Cross-origin WebSocket connections can't use cookie sessions")
+		return
+	}
+
+	ws, err := stream.Upgrade(w, r)
+	if err != nil {
+		log.Printf("WebSocket handshake failed: %s", err)
+		writeProblem(w, r, 400, codeInvalidParameter, err.Error())
+		return
+	}
+
+	sub := cfg.broker.Subscribe(filter, lastEventID)
+	defer cfg.broker.Unsubscribe(sub)
+
+	closed := make(chan struct{})
+	go func() {
+		ws.ReadUntilClosed()
+		close(closed)
+	}()
+
+	send := func(message streamMessage) bool {
+		encoded, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("failed to encode stream message: %s", err)
+			return true
+		}
+		return ws.WriteText(encoded) == nil
+	}
+	sendMessage := func(message stream.Message) bool {
+		return send(streamMessage{ID: &message.ID, Event: message.Event, Data: message.Data})
+	}
+
+	if sub.ReplayIncomplete && !send(streamMessage{Event: eventStreamResync}) {
+		return
+	}
+	for _, message := range sub.Replay {
+		if !sendMessage(message) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case message, ok := <-sub.Messages:
+			if !ok {
+				if sub.Overflowed() {
+					log.Printf("disconnected slow stream client %s", r.RemoteAddr)
+					ws.Close(1013, "Client fell too far behind, reconnect with last_event_id")
+				} else {
+					ws.Close(1001, "Stream ended")
+				}
+				return
+			}
+			if !sendMessage(message) {
+				ws.Close(1011, "Failed to send message")
+				return
+			}
+		case <-heartbeat.C:
+			if err := ws.Ping(); err != nil {
+				ws.Close(1011, "Failed to send ping")
+				return
+			}
+		}
+	}
+}
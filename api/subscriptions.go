@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mawkler/go-web-server/database"
+)
+
+// What a user's plan lets them do. Premium features should check these rather
+// than the user's subscription, so that canceled subscriptions keep working
+// until their period ends
+type entitlements struct {
+	ChirpyRed      bool `json:"chirpy_red"`
+	MaxChirpLength int  `json:"max_chirp_length"`
+	// How long after creation the user's chirps can be edited
+	EditWindowSeconds int `json:"edit_window_seconds"`
+}
+
+type subscriptionResponse struct {
+	// Null if the user has never subscribed
+	Subscription *database.Subscription       `json:"subscription"`
+	History      []database.SubscriptionEvent `json:"history"`
+	Entitlements entitlements                 `json:"entitlements"`
+}
+
+func (cfg *APIConfig) planEntitlements(chirpyRed bool) entitlements {
+	if chirpyRed {
+		return entitlements{
+			ChirpyRed:         true,
+			MaxChirpLength:    cfg.chirpLimits.RedMaxLength,
+			EditWindowSeconds: int(cfg.chirpLimits.RedEditWindow.Seconds()),
+		}
+	}
+	return entitlements{
+		MaxChirpLength:    cfg.chirpLimits.MaxLength,
+		EditWindowSeconds: int(cfg.chirpLimits.EditWindow.Seconds()),
+	}
+}
+
+// Returns ErrUserNotFound if the user doesn't exist
+func (cfg *APIConfig) entitlements(userID int) (entitlements, error) {
+	subscription, _, err := cfg.DB.GetSubscription(userID)
+	if err != nil {
+		return entitlements{}, err
+	}
+	return cfg.planEntitlements(subscription.Active(time.Now())), nil
+}
+
+func (e entitlements) editWindow() time.Duration {
+	return time.Duration(e.EditWindowSeconds) * time.Second
+}
+
+// Returns nil if the user's plan allows chirps as long as body
+func (e entitlements) chirpLengthError(body string) *fieldError {
+	if utf8.RuneCountInString(body) <= e.MaxChirpLength {
+		return nil
+	}
+	return &fieldError{
+		Field:   "body",
+		Code:    "too_long",
+		Message: fmt.Sprintf("Must be at most %d characters", e.MaxChirpLength),
+	}
+}
+
+func (cfg *APIConfig) HandlerGetSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authorizedUserID(w, r)
+	if !ok {
+		return
+	}
+
+	subscription, history, err := cfg.DB.GetSubscription(userID)
+	if err != nil {
+		log.Printf("failed to get subscription of user %d: %s", userID, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	res := subscriptionResponse{
+		Subscription: subscription,
+		History:      history,
+		Entitlements: cfg.planEntitlements(subscription.Active(time.Now())),
+	}
+	writeResponse(res, 200, w)
+}
+
+// Expires the subscriptions whose period has ended without being renewed
+func (cfg *APIConfig) ExpireSubscriptions() {
+	ids, err := cfg.DB.ExpireSubscriptions(time.Now())
+	if err != nil {
+		log.Printf("failed to expire subscriptions: %s", err)
+		return
+	}
+
+	for _, id := range ids {
+		log.Printf("subscription of user %d expired", id)
+	}
+}
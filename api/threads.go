@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/events"
+)
+
+const (
+	defaultThreadDepth = 3
+	maxThreadDepth     = 10
+)
+
+// A chirp in a thread along with the authenticated user's engagement, like
+// chirpResponse, and its replies
+type threadNodeResponse struct {
+	chirpResponse
+	Replies []threadNodeResponse `json:"replies"`
+}
+
+type threadResponse struct {
+	// The chain of chirps that the chirp replies to, starting with the root of
+	// the conversation
+	Ancestors []chirpResponse `json:"ancestors"`
+	Chirp     chirpResponse   `json:"chirp"`
+	// The direct replies to the chirp, each with their own replies nested up to
+	// `depth` levels down
+	Replies page[threadNodeResponse] `json:"replies"`
+}
+
+func (cfg *APIConfig) publishReply(chirp database.Chirp) {
+	if chirp.InReplyToID == nil {
+		return
+	}
+
+	parent, err := cfg.DB.GetChirp(*chirp.InReplyToID)
+	if err != nil || parent == nil {
+		log.Printf("failed to get chirp %d that chirp %d replies to: %s", *chirp.InReplyToID, chirp.ID, err)
+		return
+	}
+
+	cfg.events.Publish(events.ReplyCreated{
+		ChirpID:        chirp.ID,
+		AuthorID:       chirp.AuthorID,
+		ParentID:       parent.ID,
+		ParentAuthorID: parent.AuthorID,
+	})
+}
+
+func threadNodeID(node database.ThreadNode) int {
+	return node.ID
+}
+
+// Adds the authenticated user's engagement to every chirp in the thread, which
+// is looked up all at once
+func (cfg *APIConfig) threadWithEngagement(r *http.Request, ancestors []database.Chirp, chirp database.Chirp, replies []database.ThreadNode) (*threadResponse, error) {
+	chirps := append(slices.Clone(ancestors), chirp)
+	var collect func(nodes []database.ThreadNode)
+	collect = func(nodes []database.ThreadNode) {
+		for _, node := range nodes {
+			chirps = append(chirps, node.Chirp)
+			collect(node.Replies)
+		}
+	}
+	collect(replies)
+
+	responses, err := cfg.withEngagement(r, chirps)
+	if err != nil {
+		return nil, err
+	}
+
+	// The responses are in the same order as the chirps were collected
+	next := len(ancestors) + 1
+	var nodeResponses func(nodes []database.ThreadNode) []threadNodeResponse
+	nodeResponses = func(nodes []database.ThreadNode) []threadNodeResponse {
+		res := make([]threadNodeResponse, 0, len(nodes))
+		for _, node := range nodes {
+			nodeResponse := threadNodeResponse{chirpResponse: responses[next]}
+			next++
+			nodeResponse.Replies = nodeResponses(node.Replies)
+			res = append(res, nodeResponse)
+		}
+		return res
+	}
+
+	return &threadResponse{
+		Ancestors: responses[:len(ancestors)],
+		Chirp:     responses[len(ancestors)],
+		Replies:   page[threadNodeResponse]{Items: nodeResponses(replies)},
+	}, nil
+}
+
+func (cfg *APIConfig) HandlerGetThread(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeInvalidPathID(w, r)
+		return
+	}
+
+	params, fieldErrors := parsePageParams(r)
+
+	depth := defaultThreadDepth
+	if depthString := r.URL.Query().Get("depth"); depthString != "" {
+		depth, err = strconv.Atoi(depthString)
+		if err != nil || depth < 1 || depth > maxThreadDepth {
+			fieldErrors = append(fieldErrors, fieldError{
+				Field:   "depth",
+				Code:    "out_of_range",
+				Message: fmt.Sprintf("Must be an integer between 1 and %d", maxThreadDepth),
+			})
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	hidden, ok := cfg.viewerHiddenUserIDs(w, r)
+	if !ok {
+		return
+	}
+
+	thread, err := cfg.DB.GetThread(id, depth, hidden)
+	if err != nil {
+		log.Printf("failed to get thread of chirp %d: %s", id, err)
+		writeInternalError(w, r)
+		return
+	}
+
+	if thread == nil {
+		writeNotFound(w, r, fmt.Sprintf("Chirp %d does not exist", id))
+		return
+	}
+
+	if !cfg.checkAuthorNotBlocked(w, r, thread.Chirp.AuthorID) {
+		return
+	}
+
+	replies, next, prev := paginate(thread.Replies, threadNodeID, params)
+	res, err := cfg.threadWithEngagement(r, thread.Ancestors, thread.Chirp, replies)
+	if err != nil {
+		log.Printf("failed to get engagement with thread of chirp %d: %s", id, err)
+		writeInternalError(w, r)
+		return
+	}
+	res.Replies.NextCursor, res.Replies.PrevCursor = setPageLinks(w, r, next, prev)
+
+	writeResponse(res, 200, w)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Failed logins from one IP address before it's throttled
+	maxFailedLogins = 10
+	// How long failed logins are counted for, and so the longest an IP address
+	// can be throttled for
+	failedLoginWindow = 15 * time.Minute
+)
+
+type failedLogins struct {
+	count   int
+	resetAt time.Time
+}
+
+// Throttles logins by IP address rather than by account, so that nobody can
+// lock other users out of their accounts and responses don't reveal which
+// emails are registered
+type loginThrottle struct {
+	mux      *sync.Mutex
+	failures map[string]failedLogins
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{mux: &sync.Mutex{}, failures: map[string]failedLogins{}}
+}
+
+// Returns how long until the IP address can try again, or zero if it isn't
+// throttled
+func (t *loginThrottle) retryAfter(ip string, now time.Time) time.Duration {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	failures, exists := t.failures[ip]
+	if !exists || failures.count < maxFailedLogins || !now.Before(failures.resetAt) {
+		return 0
+	}
+	return failures.resetAt.Sub(now)
+}
+
+func (t *loginThrottle) recordFailure(ip string, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for key, failures := range t.failures {
+		if !now.Before(failures.resetAt) {
+			delete(t.failures, key)
+		}
+	}
+
+	failures, exists := t.failures[ip]
+	if !exists {
+		failures = failedLogins{resetAt: now.Add(failedLoginWindow)}
+	}
+	failures.count++
+	t.failures[ip] = failures
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Proxy headers are only trusted when the request comes from one of
+// trustedProxies, since clients can set them to anything. The client is then
+// the last address in X-Forwarded-For that isn't a trusted proxy itself
+func clientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(addr, trustedProxies) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		if !isTrustedProxy(addr, trustedProxies) {
+			return addr.Unmap().String()
+		}
+	}
+
+	return host
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	throttle := newLoginThrottle()
+	now := time.Now()
+
+	for range maxFailedLogins - 1 {
+		throttle.recordFailure("192.0.2.1", now)
+	}
+	if retryAfter := throttle.retryAfter("192.0.2.1", now); retryAfter != 0 {
+		t.Fatalf("throttled after %d failed logins", maxFailedLogins-1)
+	}
+
+	throttle.recordFailure("192.0.2.1", now)
+	if retryAfter := throttle.retryAfter("192.0.2.1", now); retryAfter != failedLoginWindow {
+		t.Errorf("got retry after %s, want %s", retryAfter, failedLoginWindow)
+	}
+	if retryAfter := throttle.retryAfter("192.0.2.2", now); retryAfter != 0 {
+		t.Error("throttled another IP address")
+	}
+
+	if retryAfter := throttle.retryAfter("192.0.2.1", now.Add(failedLoginWindow)); retryAfter != 0 {
+		t.Errorf("still throttled after %s", failedLoginWindow)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted proxy", "192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed header", "10.0.0.1:1234", []string{"203.0.113.1, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.0.0.1:1234", []string{"198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		{"invalid header", "10.0.0.1:1234", []string{"nonsense"}, "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/login", nil)
+			r.RemoteAddr = test.remoteAddr
+			for _, value := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r, trustedProxies); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -2,131 +2,238 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"net/url"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/mawkler/go-web-server/database"
 )
 
 func writeResponse[T any](response T, code int, w http.ResponseWriter) {
-	w.Header().Add("Content-Type", "text/json")
 	resp, err := json.Marshal(response)
 	if err != nil {
 		log.Printf("Error marshalling JSON: %s", err)
 		w.WriteHeader(500)
 	} else {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(code)
 		w.Write(resp)
 	}
 }
 
-func (cfg *APIConfig) HandlerCreateUser(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+// bcrypt ignores everything after 72 bytes
+type credentialsRequest struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,maxbytes=72"`
+}
 
-	req := request{}
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		writeResponse(errResponse{Error: "Invalid JSON body"}, 400, w)
+func (cfg *APIConfig) HandlerCreateUser(w http.ResponseWriter, r *http.Request) {
+	req := credentialsRequest{}
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
 	user, err := cfg.DB.CreateUser(req.Email, req.Password, false)
+	if errors.Is(err, database.ErrEmailTaken) {
+		writeProblem(w, r, 409, codeConflict, "Email is already taken")
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to create user %s: %s", req.Email, err)
-		w.WriteHeader(500)
+		writeInternalError(w, r)
 		return
 	}
 	writeResponse(user, 201, w)
 }
 
-func (cfg *APIConfig) HandlerGetUser(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(r.PathValue("id"))
+// Only changes the fields that are in the request. Empty strings clear the
+// profile fields
+type updateUserRequest struct {
+	Email       *string `json:"email,omitempty" validate:"min=1,email,max=254"`
+	Password    *string `json:"password,omitempty" validate:"min=1,maxbytes=72"`
+	Handle      *string `json:"handle,omitempty"`
+	DisplayName *string `json:"display_name,omitempty" validate:"max=50"`
+	Bio         *string `json:"bio,omitempty" validate:"max=160"`
+	AvatarURL   *string `json:"avatar_url,omitempty" validate:"max=2048"`
+}
+
+type userProfile struct {
+	database.User
+	FollowerCount  int `json:"follower_count"`
+	FollowingCount int `json:"following_count"`
+}
+
+// Emails are private, so they're removed from the users unless the
+// authenticated user is an admin. Users always see their own email
+func (cfg *APIConfig) hideEmails(r *http.Request, users []database.User) ([]database.User, error) {
+	viewerID, authenticated := viewerID(r)
+	if authenticated {
+		isAdmin, err := cfg.isAdmin(viewerID)
+		if err != nil {
+			return nil, err
+		}
+		if isAdmin {
+			return users, nil
+		}
+	}
+
+	visible := make([]database.User, 0, len(users))
+	for _, user := range users {
+		if !authenticated || user.ID != viewerID {
+			user.Email = ""
+		}
+		visible = append(visible, user)
+	}
+
+	return visible, nil
+}
+
+func (cfg *APIConfig) writeUserProfile(w http.ResponseWriter, r *http.Request, user database.User) {
+	followers, following, err := cfg.DB.GetFollowCounts(user.ID)
 	if err != nil {
-		writeResponse("Query parameter `id` is non-numeric", 400, w)
+		log.Printf("failed to get follow counts of user %d: %s", user.ID, err)
+		writeInternalError(w, r)
 		return
 	}
 
-	user, err := cfg.DB.GetUser(id)
+	visible, err := cfg.hideEmails(r, []database.User{user})
 	if err != nil {
-		w.WriteHeader(500)
+		log.Print(err)
+		writeInternalError(w, r)
 		return
 	}
 
-	if user == nil {
-		w.WriteHeader(404)
+	writeResponse(userProfile{User: visible[0], FollowerCount: followers, FollowingCount: following}, 200, w)
+}
+
+func (cfg *APIConfig) HandlerGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.pathUser(w, r)
+	if !ok {
 		return
 	}
 
-	writeResponse(user, 200, w)
+	cfg.writeUserProfile(w, r, *user)
 }
 
-func (cfg *APIConfig) HandlerGetUsers(w http.ResponseWriter, _ *http.Request) {
-	chirps, err := cfg.DB.GetUsers()
+func (cfg *APIConfig) HandlerGetUserByHandle(w http.ResponseWriter, r *http.Request) {
+	handle := r.PathValue("handle")
+	user, err := cfg.DB.GetUserByHandle(handle)
 	if err != nil {
-		w.WriteHeader(500)
+		log.Printf("failed to get user @%s: %s", handle, err)
+		writeInternalError(w, r)
+		return
 	}
-	writeResponse(chirps, 200, w)
+
+	if user == nil {
+		writeNotFound(w, r, fmt.Sprintf("User @%s does not exist", handle))
+		return
+	}
+
+	cfg.writeUserProfile(w, r, *user)
 }
 
-func (cfg *APIConfig) HandlerUpdateUser(w http.ResponseWriter, r *http.Request) {
-	token, ok := r.Context().Value(authorizedJWTKey).(*jwt.Token)
-	if !ok {
-		log.Printf("context does not contain authorized access token")
-		w.WriteHeader(500)
+func (cfg *APIConfig) HandlerGetUsers(w http.ResponseWriter, r *http.Request) {
+	params, fieldErrors := parsePageParams(r)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
 		return
 	}
 
-	issuer, err := token.Claims.GetIssuer()
+	users, err := cfg.DB.GetUsers()
 	if err != nil {
-		log.Printf("failed to get issuer from access token: %s", err)
-		w.WriteHeader(401)
+		log.Printf("failed to get users: %s", err)
+		writeInternalError(w, r)
 		return
 	}
 
-	if issuer != "chirpy-access" {
-		log.Printf("jwt is not an access token. Issuer: %s", issuer)
-		w.WriteHeader(401)
+	pageItems, next, prev := paginate(users, userID, params)
+	cfg.writeUserPageItems(w, r, pageItems, next, prev, params, nil)
+}
+
+// Writes a page of users with their emails hidden from others
+func (cfg *APIConfig) writeUserPageItems(w http.ResponseWriter, r *http.Request, pageUsers []database.User, next, prev string, params pageParams, total *int) {
+	visible, err := cfg.hideEmails(r, pageUsers)
+	if err != nil {
+		log.Print(err)
+		writeInternalError(w, r)
 		return
 	}
 
-	idString, err := token.Claims.GetSubject()
-	if err != nil {
-		log.Printf("token has no subject: %s", err)
-		w.WriteHeader(401)
+	writePageItems(w, r, visible, next, prev, params, total)
+}
+
+func validateUserUpdate(req updateUserRequest) []fieldError {
+	fieldErrors := []fieldError{}
+
+	if req.Handle != nil && !database.ValidHandle(*req.Handle) {
+		fieldErrors = append(fieldErrors, fieldError{
+			Field:   "handle",
+			Code:    "invalid_value",
+			Message: "Must be 3 to 15 letters, digits and underscores",
+		})
+	}
+
+	if req.AvatarURL != nil && *req.AvatarURL != "" {
+		avatarURL, err := url.Parse(*req.AvatarURL)
+		if err != nil || (avatarURL.Scheme != "https" && avatarURL.Scheme != "http") || avatarURL.Host == "" {
+			fieldErrors = append(fieldErrors, fieldError{
+				Field:   "avatar_url",
+				Code:    "invalid_value",
+				Message: "Must be an absolute http or https URL",
+			})
+		}
+	}
+
+	return fieldErrors
+}
+
+// Handles both PUT and PATCH, which both only change the fields in the request
+func (cfg *APIConfig) HandlerUpdateUser(w http.ResponseWriter, r *http.Request) {
+	_, ok := authorizedTokenWithIssuer(w, r, "chirpy-access")
+	if !ok {
 		return
 	}
 
-	id, err := strconv.Atoi(idString)
-	if err != nil {
-		log.Print("JWT subject (user ID) is non-numeric")
-		w.WriteHeader(403)
+	id, ok := authorizedUserID(w, r)
+	if !ok {
 		return
 	}
 
-	type request struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+	req := updateUserRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
 	}
 
-	req := request{}
-	err = json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		writeResponse(errResponse{Error: "Invalid JSON body"}, 400, w)
+	if fieldErrors := validateUserUpdate(req); len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
 		return
 	}
 
-	user, err := cfg.DB.UpdateUser(id, req.Email, req.Password)
+	user, err := cfg.DB.UpdateUser(id, database.UserUpdate{
+		Email:       req.Email,
+		Password:    req.Password,
+		Handle:      req.Handle,
+		DisplayName: req.DisplayName,
+		Bio:         req.Bio,
+		AvatarURL:   req.AvatarURL,
+	})
+	if errors.Is(err, database.ErrHandleTaken) {
+		writeProblem(w, r, 409, codeConflict, "Handle is already taken")
+		return
+	}
+	if errors.Is(err, database.ErrEmailTaken) {
+		writeProblem(w, r, 409, codeConflict, "Email is already taken")
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to update user: %s", err)
-		w.WriteHeader(500)
+		writeInternalError(w, r)
 		return
 	}
 	if user == nil {
-		writeResponse("User not found", 404, w)
+		writeNotFound(w, r, fmt.Sprintf("User %d does not exist", id))
 	} else {
 		writeResponse(user, 200, w)
 	}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxRequestBodySize = 1 << 20
+
+// Decodes the JSON request body into dst and validates it against the rules
+// in dst's `validate` struct tags. Writes a problem response and returns false
+// if the body can't be decoded or is invalid.
+//
+// Supported rules are `required`, `min=N`, `max=N`, `maxbytes=N` and `email`.
+// For strings, min and max refer to the number of characters, and maxbytes to
+// the number of bytes
+func decodeRequest(w http.ResponseWriter, r *http.Request, dst any) bool {
+	return decodeJSON(w, r, dst, false)
+}
+
+// Like decodeRequest, but ignores unknown fields. Meant for payloads from
+// third parties, that may add fields without notice
+func decodeLenientRequest(w http.ResponseWriter, r *http.Request, dst any) bool {
+	return decodeJSON(w, r, dst, true)
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any, allowUnknownFields bool) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	decoder := json.NewDecoder(r.Body)
+	if !allowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(dst); err != nil {
+		writeDecodeProblem(w, r, err)
+		return false
+	}
+
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		writeProblem(w, r, 400, codeInvalidJSON, "Request body must contain a single JSON object")
+		return false
+	}
+
+	if fieldErrors := validateStruct(dst); len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return false
+	}
+
+	return true
+}
+
+func writeDecodeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesError *http.MaxBytesError
+	var typeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		detail := fmt.Sprintf("Request body must not be larger than %d bytes", maxBytesError.Limit)
+		writeProblem(w, r, 413, codeRequestTooLarge, detail)
+	case errors.As(err, &typeError):
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   typeError.Field,
+			Code:    "invalid_type",
+			Message: fmt.Sprintf("Must be of type %s", typeError.Type),
+		}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeValidationProblem(w, r, []fieldError{{
+			Field:   field,
+			Code:    "unknown_field",
+			Message: "Unknown field",
+		}})
+	case errors.Is(err, io.EOF):
+		writeProblem(w, r, 400, codeInvalidJSON, "Request body must not be empty")
+	default:
+		writeInvalidJSON(w, r)
+	}
+}
+
+func validateStruct(v any) []fieldError {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	return validateFields(value, "")
+}
+
+func validateFields(value reflect.Value, prefix string) []fieldError {
+	fieldErrors := []fieldError{}
+
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+		fieldValue := value.Field(i)
+
+		// Fields of embedded structs get promoted, like encoding/json does
+		if field.Anonymous && fieldValue.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			fieldErrors = append(fieldErrors, validateFields(fieldValue, prefix)...)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name := prefix + jsonFieldName(field)
+
+		if fieldValue.Kind() == reflect.Struct {
+			fieldErrors = append(fieldErrors, validateFields(fieldValue, name+".")...)
+			continue
+		}
+
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "" {
+				continue
+			}
+
+			if err := checkRule(rule, fieldValue); err != nil {
+				err.Field = name
+				fieldErrors = append(fieldErrors, *err)
+				// Only report the first broken rule per field
+				break
+			}
+		}
+	}
+
+	return fieldErrors
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func checkRule(rule string, value reflect.Value) *fieldError {
+	ruleName, arg, _ := strings.Cut(rule, "=")
+
+	if ruleName == "required" {
+		if value.IsZero() {
+			return &fieldError{Code: "required", Message: "Field is required"}
+		}
+		return nil
+	}
+
+	// Optional fields that were left out are only checked for `required`
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	switch ruleName {
+	case "min", "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("invalid validation rule %q", rule))
+		}
+		return checkLength(ruleName, limit, value)
+	case "maxbytes":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("invalid validation rule %q", rule))
+		}
+		if len(value.String()) > limit {
+			return &fieldError{Code: "too_long", Message: fmt.Sprintf("Must be at most %d bytes", limit)}
+		}
+		return nil
+	case "email":
+		address, err := mail.ParseAddress(value.String())
+		if value.String() != "" && (err != nil || address.Address != value.String()) {
+			return &fieldError{Code: "invalid_email", Message: "Must be a valid email address"}
+		}
+		return nil
+	default:
+		panic(fmt.Sprintf("unknown validation rule %q", rule))
+	}
+}
+
+func checkLength(ruleName string, limit int, value reflect.Value) *fieldError {
+	var size int
+	var unit string
+
+	switch value.Kind() {
+	case reflect.String:
+		size, unit = utf8.RuneCountInString(value.String()), " characters"
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		size = int(value.Int())
+	case reflect.Slice, reflect.Map:
+		size, unit = value.Len(), " items"
+	default:
+		return nil
+	}
+
+	tooSmall, tooLarge := "too_short", "too_long"
+	if value.Kind() != reflect.String && value.Kind() != reflect.Slice && value.Kind() != reflect.Map {
+		tooSmall, tooLarge = "too_small", "too_large"
+	}
+
+	if ruleName == "min" && size < limit {
+		return &fieldError{Code: tooSmall, Message: fmt.Sprintf("Must be at least %d%s", limit, unit)}
+	}
+	if ruleName == "max" && size > limit {
+		return &fieldError{Code: tooLarge, Message: fmt.Sprintf("Must be at most %d%s", limit, unit)}
+	}
+
+	return nil
+}
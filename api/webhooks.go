@@ -1,42 +1,71 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/mawkler/go-web-server/database"
 )
 
-func (cfg *APIConfig) HandlerUpgraded(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID int `json:"user_id"`
-		} `json:"data"`
-	}
+type polkaWebhookRequest struct {
+	Event string `json:"event" validate:"required"`
+	Data  struct {
+		UserID int `json:"user_id"`
+		// Defaults to Chirpy Red
+		Plan string `json:"plan,omitempty"`
+		// When the paid period ends. Defaults to 30 days after the upgrade or
+		// the end of the current period. Renewals without it are only applied
+		// when less than 30 days are left, so that retries don't extend twice
+		PeriodEnd *time.Time `json:"period_end,omitempty"`
+	} `json:"data"`
+}
 
-	req := request{}
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		writeResponse(errResponse{Error: "Invalid JSON body"}, 400, w)
-		return
-	}
+// The Polka events that change subscriptions. Other events are ignored
+var polkaSubscriptionEvents = map[string]database.SubscriptionEventType{
+	"user.upgraded":   database.SubscriptionEventUpgraded,
+	"user.renewed":    database.SubscriptionEventRenewed,
+	"user.canceled":   database.SubscriptionEventCanceled,
+	"user.downgraded": database.SubscriptionEventDowngraded,
+}
 
+func (cfg *APIConfig) HandlerPolkaWebhook(w http.ResponseWriter, r *http.Request) {
 	authorization := r.Header.Get("Authorization")
 	apiKey := strings.TrimPrefix(authorization, "ApiKey ")
 	if apiKey != cfg.polkaAPIKey {
-		w.WriteHeader(401)
+		writeProblem(w, r, 401, codeUnauthorized, "Invalid API key")
+		return
+	}
+
+	req := polkaWebhookRequest{}
+	if !decodeLenientRequest(w, r, &req) {
 		return
 	}
 
-	if req.Event != "user.upgraded" {
+	eventType, ok := polkaSubscriptionEvents[req.Event]
+	if !ok {
 		w.WriteHeader(204)
 		return
 	}
 
-	if err := cfg.DB.UpgradeUser(req.Data.UserID); err != nil {
-		log.Printf("failed to upgrade user: %s", err)
-		w.WriteHeader(500)
+	change := database.SubscriptionChange{Type: eventType, Plan: req.Data.Plan, PeriodEnd: req.Data.PeriodEnd}
+	_, err := cfg.DB.UpdateSubscription(req.Data.UserID, change)
+	if errors.Is(err, database.ErrUserNotFound) {
+		writeNotFound(w, r, fmt.Sprintf("User %d does not exist", req.Data.UserID))
+		return
+	}
+	// Polka retries failed webhooks, which wouldn't help
+	if errors.Is(err, database.ErrNoSubscription) {
+		log.Printf("ignoring %s for user %d: %s", req.Event, req.Data.UserID, err)
+		w.WriteHeader(204)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to handle %s for user %d: %s", req.Event, req.Data.UserID, err)
+		writeInternalError(w, r)
 		return
 	}
 
@@ -1,19 +1,43 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func createJwt(userID int, issuer, jwtSecret string, expiresIn time.Duration) (string, error) {
+const (
+	MagicLinkExpiry     = 15 * time.Minute
+	ImpersonationExpiry = 15 * time.Minute
+)
+
+// The party acting on behalf of the subject (RFC 8693)
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+type Claims struct {
+	Act *Actor `json:"act,omitempty"`
+	// Ties together the access and refresh tokens issued at the same login
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func createJwt(subject, issuer, jwtSecret string, expiresIn time.Duration) (string, error) {
+	return createJwtWithClaims(Claims{}, subject, issuer, jwtSecret, expiresIn)
+}
+
+func createJwtWithClaims(claims Claims, subject, issuer, jwtSecret string, expiresIn time.Duration) (string, error) {
 	now := time.Now().UTC()
-	claims := jwt.RegisteredClaims{
+	claims.RegisteredClaims = jwt.RegisteredClaims{
 		Issuer:    issuer,
 		IssuedAt:  jwt.NewNumericDate(now),
 		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
-		Subject:   fmt.Sprint(userID),
+		Subject:   subject,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -25,26 +49,114 @@ func createJwt(userID int, issuer, jwtSecret string, expiresIn time.Duration) (s
 	return signedToken, nil
 }
 
-func CreateAccessToken(userID int, jwtSecret string, expiresInSeconds *int) (string, error) {
+func CreateAccessToken(userID int, sessionID, jwtSecret string, expiresInSeconds *int) (string, error) {
 	expiresIn := 1 * time.Hour
 
 	if expiresInSeconds != nil {
 		expiresIn = time.Duration(*expiresInSeconds) * time.Second
 	}
 
-	return createJwt(userID, "chirpy-access", jwtSecret, expiresIn)
+	claims := Claims{SessionID: sessionID}
+	return createJwtWithClaims(claims, fmt.Sprint(userID), "chirpy-access", jwtSecret, expiresIn)
+}
+
+func CreateRefreshToken(userID int, sessionID, jwtSecret string) (string, error) {
+	claims := Claims{SessionID: sessionID}
+	return createJwtWithClaims(claims, fmt.Sprint(userID), "chirpy-refresh", jwtSecret, 60*24*time.Hour)
+}
+
+func CreateSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %s", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// The subject of a magic link token is the email address rather than a user
+// ID, since the account might not exist until the link is exchanged
+func CreateMagicLinkToken(email, jwtSecret string) (string, error) {
+	return createJwt(email, "chirpy-magic", jwtSecret, MagicLinkExpiry)
+}
+
+// An impersonation token is an access token for the user with an `act` claim
+// naming the admin that is impersonating them
+func CreateImpersonationToken(userID, adminID int, jwtSecret string) (string, error) {
+	claims := Claims{Act: &Actor{Subject: fmt.Sprint(adminID)}}
+	return createJwtWithClaims(claims, fmt.Sprint(userID), "chirpy-access", jwtSecret, ImpersonationExpiry)
 }
 
-func CreateRefreshToken(userID int, jwtSecret string) (string, error) {
-	return createJwt(userID, "chirpy-refresh", jwtSecret, 60*24*time.Hour)
+// Returns the admin's user ID if the token is an impersonation token
+func Impersonator(token *jwt.Token) (string, bool) {
+	claims, ok := token.Claims.(*Claims)
+	if !ok || claims.Act == nil {
+		return "", false
+	}
+
+	return claims.Act.Subject, true
 }
 
 func Authorize(tokenString string, jwtSecret string) (*jwt.Token, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(jwtSecret), nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("invalid token: %s", err)
+		return nil, fmt.Errorf("invalid token: %w", err)
 	}
 	return token, nil
 }
+
+func GetClaims(token *jwt.Token) *Claims {
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return &Claims{}
+	}
+
+	return claims
+}
+
+// Returns "access", "refresh", "magic_link" or "impersonation"
+func TokenType(token *jwt.Token) string {
+	claims := GetClaims(token)
+	switch claims.Issuer {
+	case "chirpy-access":
+		if claims.Act != nil {
+			return "impersonation"
+		}
+		return "access"
+	case "chirpy-refresh":
+		return "refresh"
+	case "chirpy-magic":
+		return "magic_link"
+	default:
+		return "unknown"
+	}
+}
+
+// Returns a machine readable reason for why Authorize rejected a token
+func InvalidTokenReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "malformed"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return "invalid_signature"
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return "not_yet_valid"
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return "unverifiable"
+	default:
+		return "invalid"
+	}
+}
+
+func CreateCSRFToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate CSRF token: %s", err)
+	}
+
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrUserSuspended = errors.New("user is suspended")
+
+// Why and by whom an account was suspended. Suspended users can't log in, and
+// their tokens are rejected
+type Suspension struct {
+	Reason      string    `json:"reason"`
+	SuspendedBy int       `json:"suspended_by"`
+	SuspendedAt time.Time `json:"suspended_at"`
+}
+
+// Everything admins need to know to fix an account
+type AccountState struct {
+	User        User        `json:"user"`
+	Role        string      `json:"role"`
+	HasPassword bool        `json:"has_password"`
+	Suspension  *Suspension `json:"suspension"`
+	// Failed logins in a row since the last successful one
+	FailedLogins      int           `json:"failed_logins"`
+	LastFailedLoginAt *time.Time    `json:"last_failed_login_at"`
+	DeleteAt          *time.Time    `json:"delete_at"`
+	Subscription      *Subscription `json:"subscription"`
+	Sessions          []Session     `json:"sessions"`
+}
+
+func userSessions(data DBStructure, id int) []Session {
+	sessions := []Session{}
+	for _, token := range data.RefreshTokens {
+		if token.UserID == id {
+			sessions = append(sessions, Session{ExpiresAt: token.ExpiresAt})
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ExpiresAt.Before(sessions[j].ExpiresAt) })
+	return sessions
+}
+
+// Finds the users whose email or handle contains query, case-insensitively.
+// A leading `@` is ignored
+func (db *DB) SearchUsers(query string) ([]User, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	query = strings.ToLower(strings.TrimPrefix(query, "@"))
+
+	users := []User{}
+	for _, user := range data.Users {
+		if strings.Contains(strings.ToLower(user.Email), query) || strings.Contains(strings.ToLower(user.Handle), query) {
+			users = append(users, user.User)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return users, nil
+}
+
+// Returns ErrUserNotFound if the user doesn't exist
+func (db *DB) GetAccountState(id int) (*AccountState, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	user, exists := data.Users[id]
+	if !exists {
+		return nil, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+	}
+
+	state := AccountState{
+		User:              user.User,
+		Role:              user.Role,
+		HasPassword:       user.Password != "",
+		Suspension:        user.Suspension,
+		FailedLogins:      user.FailedLogins,
+		LastFailedLoginAt: user.LastFailedLoginAt,
+		DeleteAt:          user.DeleteAt,
+		Subscription:      user.Subscription,
+		Sessions:          userSessions(data, id),
+	}
+	if state.Role == "" {
+		state.Role = RoleUser
+	}
+
+	return &state, nil
+}
+
+// Loads the database, applies change to the user and writes it back. Returns
+// ErrUserNotFound if the user doesn't exist
+func (db *DB) updateFullUser(id int, change func(data *DBStructure, user *FullUser)) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	user, exists := data.Users[id]
+	if !exists {
+		return fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+	}
+
+	change(&data, &user)
+	data.Users[id] = user
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to update user %d: %s", id, err)
+	}
+
+	return nil
+}
+
+// Suspends the user and revokes their refresh tokens
+func (db *DB) SuspendUser(id, adminID int, reason string) error {
+	return db.updateFullUser(id, func(data *DBStructure, user *FullUser) {
+		user.Suspension = &Suspension{Reason: reason, SuspendedBy: adminID, SuspendedAt: time.Now().UTC()}
+		revokeRefreshTokens(data, id)
+	})
+}
+
+// Lifts the user's suspension
+func (db *DB) ReactivateUser(id int) error {
+	return db.updateFullUser(id, func(data *DBStructure, user *FullUser) {
+		user.Suspension = nil
+	})
+}
+
+// Removes the user's password and revokes their refresh tokens, so that they
+// have to log in through a magic link and set a new password
+func (db *DB) ResetPassword(id int) error {
+	return db.updateFullUser(id, func(data *DBStructure, user *FullUser) {
+		user.Password = ""
+		user.FailedLogins = 0
+		revokeRefreshTokens(data, id)
+	})
+}
+
+func (db *DB) SetUserRole(id int, role string) error {
+	return db.updateFullUser(id, func(data *DBStructure, user *FullUser) {
+		user.Role = role
+	})
+}
+
+// Returns false for users that don't exist
+func (db *DB) IsSuspended(id int) (bool, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return false, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return data.Users[id].Suspension != nil, nil
+}
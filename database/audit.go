@@ -0,0 +1,48 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	ActorID int       `json:"actor_id"`
+	UserID  int       `json:"user_id"`
+	Action  string    `json:"action"`
+	Details string    `json:"details"`
+}
+
+func (db *DB) RecordAuditEvent(actorID, userID int, action, details string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	event := AuditEvent{
+		Time:    time.Now().UTC(),
+		ActorID: actorID,
+		UserID:  userID,
+		Action:  action,
+		Details: details,
+	}
+	data.AuditLog = append(data.AuditLog, event)
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to write audit event: %s", err)
+	}
+
+	return nil
+}
+
+func (db *DB) GetAuditLog() ([]AuditEvent, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return data.AuditLog, nil
+}
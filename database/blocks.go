@@ -0,0 +1,174 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// One of the users has blocked the other
+var ErrBlocked = errors.New("user is blocked")
+
+func isBlocked(data DBStructure, userID, otherUserID int) bool {
+	_, blocked := data.Blocks[userID][otherUserID]
+	_, blockedBy := data.Blocks[otherUserID][userID]
+	return blocked || blockedBy
+}
+
+// Adds a relationship to a table such as Blocks, keyed by the user and then
+// the other user. Returns whether the relationship didn't already exist
+func addRelationship(table map[int]map[int]time.Time, userID, otherUserID int) bool {
+	if _, exists := table[userID][otherUserID]; exists {
+		return false
+	}
+	if table[userID] == nil {
+		table[userID] = map[int]time.Time{}
+	}
+	table[userID][otherUserID] = time.Now().UTC()
+	return true
+}
+
+func removeRelationship(table map[int]map[int]time.Time, userID, otherUserID int) {
+	delete(table[userID], otherUserID)
+	if len(table[userID]) == 0 {
+		delete(table, userID)
+	}
+}
+
+// Blocks the user, which also removes any follows between the two users.
+// Blocking someone twice has no effect
+func (db *DB) Block(blockerID, blockedID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	if !addRelationship(data.Blocks, blockerID, blockedID) {
+		return nil
+	}
+	removeFollow(&data, blockerID, blockedID)
+	removeFollow(&data, blockedID, blockerID)
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to block user %d: %s", blockedID, err)
+	}
+
+	return nil
+}
+
+func (db *DB) Unblock(blockerID, blockedID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	removeRelationship(data.Blocks, blockerID, blockedID)
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to unblock user %d: %s", blockedID, err)
+	}
+
+	return nil
+}
+
+// Muting someone twice has no effect
+func (db *DB) Mute(muterID, mutedID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	if !addRelationship(data.Mutes, muterID, mutedID) {
+		return nil
+	}
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to mute user %d: %s", mutedID, err)
+	}
+
+	return nil
+}
+
+func (db *DB) Unmute(muterID, mutedID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	removeRelationship(data.Mutes, muterID, mutedID)
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to unmute user %d: %s", mutedID, err)
+	}
+
+	return nil
+}
+
+// Returns the users that the user has blocked, sorted by ID
+func (db *DB) GetBlockedUsers(userID int) ([]User, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return usersByID(data, data.Blocks[userID]), nil
+}
+
+// Returns the users that the user has muted, sorted by ID
+func (db *DB) GetMutedUsers(userID int) ([]User, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return usersByID(data, data.Mutes[userID]), nil
+}
+
+// Whether either user has blocked the other
+func (db *DB) IsBlocked(userID, otherUserID int) (bool, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return false, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return isBlocked(data, userID, otherUserID), nil
+}
+
+func hiddenUserIDs(data DBStructure, viewerID int) map[int]bool {
+	hidden := map[int]bool{}
+	for id := range data.Blocks[viewerID] {
+		hidden[id] = true
+	}
+	for blockerID, blocked := range data.Blocks {
+		if _, exists := blocked[viewerID]; exists {
+			hidden[blockerID] = true
+		}
+	}
+	for id := range data.Mutes[viewerID] {
+		hidden[id] = true
+	}
+	return hidden
+}
+
+// Returns the users whose chirps the viewer shouldn't see: those that the
+// viewer has blocked or muted, and those that have blocked the viewer
+func (db *DB) GetHiddenUserIDs(viewerID int) (map[int]bool, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return hiddenUserIDs(data, viewerID), nil
+}
@@ -3,25 +3,138 @@ package database
 import (
 	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mawkler/go-web-server/entities"
 )
 
+var ErrChirpNotFound = errors.New("chirp does not exist")
+
 type Chirp struct {
-	Body     string `json:"body"`
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
+	Body        string    `json:"body"`
+	ID          int       `json:"id"`
+	AuthorID    int       `json:"author_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	InReplyToID *int      `json:"in_reply_to_id"`
+	// The ID of the chirp that started the conversation, which is the chirp's
+	// own ID if it isn't a reply
+	ConversationID int `json:"conversation_id"`
+	ReplyCount     int `json:"reply_count"`
+	LikeCount      int `json:"like_count"`
+	RechirpCount   int `json:"rechirp_count"`
+	// Hashtags and mentions in the body
+	Entities []entities.Entity `json:"entities"`
+	// IDs of the attached media, in the order they were attached
+	AttachmentIDs []int `json:"attachment_ids,omitempty"`
+	// Deleted chirps that have replies are kept as placeholders without body or
+	// author, so that their threads stay connected
+	Deleted bool `json:"deleted,omitempty"`
+	// Hidden by a moderator, or automatically after enough reports. Hidden
+	// chirps are left out of listings. In threads, chirps by users hidden from
+	// the viewer are marked as hidden placeholders too
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// A previous version of an edited chirp
+type ChirpRevision struct {
+	Body string `json:"body"`
+	// When this version was created, either by creating or editing the chirp
+	CreatedAt time.Time `json:"created_at"`
+	// When this version was replaced by an edit
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// Criteria for listing chirps. Zero values match all chirps
+type ChirpFilter struct {
+	AuthorIDs     []int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Case-insensitive substring of the body
+	Contains string
+	// Authors to leave out, such as those that the viewer has blocked
+	HiddenAuthorIDs map[int]bool
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
+func (f ChirpFilter) matches(chirp Chirp) bool {
+	if len(f.AuthorIDs) > 0 && !slices.Contains(f.AuthorIDs, chirp.AuthorID) {
+		return false
+	}
+	if f.HiddenAuthorIDs[chirp.AuthorID] {
+		return false
+	}
+	if f.CreatedAfter != nil && !chirp.CreatedAt.After(*f.CreatedAfter) {
+		return false
+	}
+	if f.CreatedBefore != nil && !chirp.CreatedAt.Before(*f.CreatedBefore) {
+		return false
+	}
+	if f.Contains != "" && !strings.Contains(strings.ToLower(chirp.Body), strings.ToLower(f.Contains)) {
+		return false
+	}
+
+	return true
+}
+
+// Creates a chirp, optionally as a reply to another chirp. Returns
+// ErrChirpNotFound if the replied to chirp doesn't exist, and ErrBlocked if
+// its author and the replying user have blocked one another. Returns
+// ErrMediaNotFound or ErrMediaAttached if any of the media can't be attached
+func (db *DB) CreateChirp(body string, authorID int, inReplyToID *int, mediaIDs []int) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
 	data, err := db.loadDB()
 	if err != nil {
 		return Chirp{}, fmt.Errorf("failed to load database: %s", err)
 	}
 
-	id := len(data.Chirps) + 1
-	chirp := Chirp{Body: body, ID: id, AuthorID: authorID}
+	data.LastChirpID++
+	id := data.LastChirpID
+	now := time.Now().UTC()
+	chirp := Chirp{
+		Body:           body,
+		ID:             id,
+		AuthorID:       authorID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ConversationID: id,
+		Entities:       extractEntities(data, body, authorID),
+	}
+
+	if inReplyToID != nil {
+		parent, exists := data.Chirps[*inReplyToID]
+		if !exists || parent.Deleted || parent.Hidden {
+			return Chirp{}, ErrChirpNotFound
+		}
+		if isBlocked(data, authorID, parent.AuthorID) {
+			return Chirp{}, ErrBlocked
+		}
+
+		chirp.InReplyToID = &parent.ID
+		chirp.ConversationID = parent.ConversationID
+		parent.ReplyCount++
+		data.Chirps[parent.ID] = parent
+		data.Replies[parent.ID] = append(data.Replies[parent.ID], id)
+	}
+
+	if len(mediaIDs) > 0 {
+		if err := attachMedia(&data, &chirp, mediaIDs); err != nil {
+			return Chirp{}, err
+		}
+	}
+
 	data.Chirps[id] = chirp
+	data.SearchIndex.add(chirp)
+	data.AuthorIndex[authorID] = append(data.AuthorIndex[authorID], id)
+	addToHashtagIndex(&data, chirp)
 
-	db.writeDB(data)
+	if err := db.writeDB(data); err != nil {
+		return Chirp{}, fmt.Errorf("failed to create chirp: %s", err)
+	}
 
 	return chirp, nil
 }
@@ -35,25 +148,47 @@ func (db *DB) GetChirps() ([]Chirp, error) {
 	chirps := make([]Chirp, 0, len(data.Chirps))
 
 	for _, chirp := range data.Chirps {
-		chirps = append(chirps, chirp)
+		if !chirp.Deleted && !chirp.Hidden {
+			chirps = append(chirps, chirp)
+		}
 	}
 
+	sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+
 	return chirps, nil
 }
 
-func (db *DB) GetChirp(id int) (*Chirp, error) {
+// Returns the chirps that match filter, sorted by ID
+func (db *DB) FilterChirps(filter ChirpFilter) ([]Chirp, error) {
 	chirps, err := db.GetChirps()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get chirp: %s", err)
+		return nil, fmt.Errorf("failed to filter chirps: %s", err)
 	}
 
+	filtered := []Chirp{}
 	for _, chirp := range chirps {
-		if chirp.ID == id {
-			return &chirp, nil
+		if filter.matches(chirp) {
+			filtered = append(filtered, chirp)
 		}
 	}
 
-	return nil, nil
+	return filtered, nil
+}
+
+// Unlike GetChirps, this also returns hidden chirps. Returns nil if the chirp
+// doesn't exist
+func (db *DB) GetChirp(id int) (*Chirp, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chirp: %s", err)
+	}
+
+	chirp, exists := data.Chirps[id]
+	if !exists || chirp.Deleted {
+		return nil, nil
+	}
+
+	return &chirp, nil
 }
 
 func (db *DB) GetChirpsByAuthor(authorID int) ([]Chirp, error) {
@@ -73,13 +208,17 @@ func (db *DB) GetChirpsByAuthor(authorID int) ([]Chirp, error) {
 	return authorChirps, nil
 }
 
+// Deletes the chirp. If it has replies, a placeholder is left in its place
 func (db *DB) DeleteChirp(id int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
 	data, err := db.loadDB()
 	if err != nil {
 		return errors.New("failed to load database")
 	}
 
-	delete(data.Chirps, id)
+	deleteChirp(&data, id)
 
 	if err := db.writeDB(data); err != nil {
 		return fmt.Errorf("failed to delete chirp %d: %s", id, err)
@@ -87,3 +226,93 @@ func (db *DB) DeleteChirp(id int) error {
 
 	return nil
 }
+
+func deleteChirp(data *DBStructure, id int) {
+	chirp, exists := data.Chirps[id]
+	if !exists || chirp.Deleted {
+		return
+	}
+
+	data.SearchIndex.remove(chirp)
+	removeFromHashtagIndex(data, chirp)
+	data.AuthorIndex[chirp.AuthorID] = slices.DeleteFunc(data.AuthorIndex[chirp.AuthorID], func(chirpID int) bool {
+		return chirpID == id
+	})
+	delete(data.ChirpFlags, id)
+	deleteOpenReports(data, id)
+	delete(data.ChirpRevisions, id)
+	removeEngagement(data, id)
+	// The blobs are left for the caller to delete
+	for _, mediaID := range chirp.AttachmentIDs {
+		delete(data.Media, mediaID)
+	}
+
+	if len(data.Replies[id]) > 0 {
+		data.Chirps[id] = Chirp{
+			ID:             id,
+			CreatedAt:      chirp.CreatedAt,
+			UpdatedAt:      time.Now().UTC(),
+			InReplyToID:    chirp.InReplyToID,
+			ConversationID: chirp.ConversationID,
+			ReplyCount:     chirp.ReplyCount,
+			Entities:       []entities.Entity{},
+			Deleted:        true,
+		}
+	} else {
+		purgeChirp(data, id)
+	}
+}
+
+// Replaces the chirp's body, keeping the previous body in its edit history.
+// Returns nil if the chirp doesn't exist
+func (db *DB) UpdateChirp(id int, body string) (*Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	chirp, exists := data.Chirps[id]
+	if !exists || chirp.Deleted {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	revision := ChirpRevision{Body: chirp.Body, CreatedAt: chirp.UpdatedAt, ReplacedAt: now}
+	if revision.CreatedAt.IsZero() {
+		revision.CreatedAt = chirp.CreatedAt
+	}
+	data.ChirpRevisions[id] = append(data.ChirpRevisions[id], revision)
+
+	data.SearchIndex.remove(chirp)
+	removeFromHashtagIndex(&data, chirp)
+	chirp.Body = body
+	chirp.UpdatedAt = now
+	chirp.Entities = extractEntities(data, body, chirp.AuthorID)
+	data.Chirps[id] = chirp
+	data.SearchIndex.add(chirp)
+	addToHashtagIndex(&data, chirp)
+
+	if err := db.writeDB(data); err != nil {
+		return nil, fmt.Errorf("failed to update chirp %d: %s", id, err)
+	}
+
+	return &chirp, nil
+}
+
+// Returns the chirp's previous versions, oldest first
+func (db *DB) GetChirpHistory(id int) ([]ChirpRevision, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	revisions := data.ChirpRevisions[id]
+	if revisions == nil {
+		return []ChirpRevision{}, nil
+	}
+
+	return revisions, nil
+}
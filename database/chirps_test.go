@@ -0,0 +1,23 @@
+package database
+
+import "testing"
+
+// Purged chirps are still referenced by notifications and closed reports, so
+// their IDs mustn't be given to new chirps
+func TestChirpIDsAreNotReused(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice@example.com")
+
+	createTestChirp(t, db, "First", alice.ID, nil)
+	purged := createTestChirp(t, db, "Second", alice.ID, nil)
+	if err := db.DeleteChirp(purged.ID); err != nil {
+		t.Fatalf("failed to delete chirp: %s", err)
+	}
+	if _, exists := loadTestDB(t, db).Chirps[purged.ID]; exists {
+		t.Fatal("chirp without replies wasn't purged")
+	}
+
+	if chirp := createTestChirp(t, db, "Third", alice.ID, nil); chirp.ID <= purged.ID {
+		t.Errorf("got ID %d for new chirp, want more than %d", chirp.ID, purged.ID)
+	}
+}
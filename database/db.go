@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"sync"
+	"time"
 )
 
 type DB struct {
@@ -19,6 +21,65 @@ type DBStructure struct {
 	Chirps        map[int]Chirp           `json:"chirps"`
 	Users         map[int]FullUser        `json:"users"`
 	RefreshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	MagicLinks    map[string]MagicLink    `json:"magic_links"`
+	AuditLog      []AuditEvent            `json:"audit_log"`
+	SearchIndex   SearchIndex             `json:"search_index"`
+	// Keyed by word
+	ModerationRules map[string]ModerationRule `json:"moderation_rules"`
+	// Keyed by chirp ID
+	ChirpFlags map[int]ChirpFlag `json:"chirp_flags"`
+	// Keyed by chirp ID
+	ChirpRevisions map[int][]ChirpRevision `json:"chirp_revisions"`
+	// Follower ID -> followed user ID -> when they followed
+	Following map[int]map[int]time.Time `json:"following"`
+	// Followed user ID -> follower ID -> when they followed
+	Followers map[int]map[int]time.Time `json:"followers"`
+	// Author ID -> the author's chirp IDs in ascending order
+	AuthorIndex map[int][]int `json:"author_index"`
+	// Chirp ID -> the IDs of its direct replies in ascending order
+	Replies map[int][]int `json:"replies"`
+	// Normalized hashtag without `#` -> IDs of chirps with it in ascending order
+	HashtagIndex  map[string][]int     `json:"hashtag_index"`
+	Notifications map[int]Notification `json:"notifications"`
+	// User ID -> the IDs of the user's notifications in ascending order
+	NotificationIndex map[int][]int `json:"notification_index"`
+	// Keyed by user ID. Users without preferences get all notifications
+	NotificationPreferences map[int]NotificationPreferences `json:"notification_preferences"`
+	// Chirp ID -> user ID -> when they liked it
+	Likes map[int]map[int]time.Time `json:"likes"`
+	// User ID -> chirp ID -> when they liked it
+	UserLikes map[int]map[int]time.Time `json:"user_likes"`
+	// Chirp ID -> user ID -> when they rechirped it
+	Rechirps      map[int]map[int]time.Time `json:"rechirps"`
+	Conversations map[int]Conversation      `json:"conversations"`
+	// The latest value of Conversation.Activity
+	ConversationActivity int                   `json:"conversation_activity"`
+	DirectMessages       map[int]DirectMessage `json:"direct_messages"`
+	// Conversation ID -> the IDs of its messages in ascending order
+	ConversationMessages map[int][]int `json:"conversation_messages"`
+	// User ID -> the IDs of the user's conversations in ascending order
+	UserConversations map[int][]int `json:"user_conversations"`
+	// Conversation ID -> user ID -> the ID of the last message they've read
+	ConversationReads map[int]map[int]int `json:"conversation_reads"`
+	// Keyed by user ID. Users without preferences accept messages from everyone
+	DirectMessagePreferences map[int]DirectMessagePreferences `json:"direct_message_preferences"`
+	// Blocker ID -> blocked user ID -> when they blocked them
+	Blocks map[int]map[int]time.Time `json:"blocks"`
+	// Muter ID -> muted user ID -> when they muted them
+	Mutes map[int]map[int]time.Time `json:"mutes"`
+	// Lowercased handle -> user ID
+	HandleIndex map[string]int `json:"handle_index"`
+	// The ID of the latest user. IDs of deleted users aren't reused, since
+	// they may still be referenced, e.g. by direct messages and access tokens
+	LastUserID int            `json:"last_user_id"`
+	Media      map[int]Media  `json:"media"`
+	Reports    map[int]Report `json:"reports"`
+	// The IDs of the latest chirp and report, which aren't reused either, since
+	// purged chirps are still referenced by notifications and closed reports
+	LastChirpID  int `json:"last_chirp_id"`
+	LastReportID int `json:"last_report_id"`
+	// User ID -> changes to the user's subscription, oldest first
+	SubscriptionHistory map[int][]SubscriptionEvent `json:"subscription_history"`
 }
 
 func New(path string) *DB {
@@ -31,7 +92,7 @@ func (db *DB) ensureDB() error {
 		return errors.New("failed to create database file")
 	}
 
-	_, err = io.WriteString(f, `{"chirps": {}, "users": {}, "refresh_tokens": {}}`)
+	_, err = io.WriteString(f, `{"chirps": {}, "users": {}, "refresh_tokens": {}, "magic_links": {}, "audit_log": []}`)
 	if err != nil {
 		return fmt.Errorf("failed to write to file: %s", err)
 	}
@@ -57,14 +118,175 @@ func (db *DB) loadDB() (DBStructure, error) {
 		return DBStructure{}, fmt.Errorf("could not marshal database: %s", err)
 	}
 
+	initializeTables(&data)
+
 	return data, nil
 }
 
+// Writes to a temporary file that then replaces the database, so that
+// concurrent readers never see a partially written database
 func (db *DB) writeDB(data DBStructure) error {
 	marshalledData, err := json.Marshal(data)
 	if err != nil {
 		return errors.New("failed to marshall data")
 	}
 
-	return os.WriteFile(db.path, marshalledData, 0666)
+	tmpPath := db.path + ".tmp"
+	if err := os.WriteFile(tmpPath, marshalledData, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, db.path)
+}
+
+// IDs are never reused, even if the row with the highest ID has been deleted,
+// as long as the maximum ID is still present
+func nextID[T any](table map[int]T) int {
+	maxID := 0
+	for id := range table {
+		maxID = max(maxID, id)
+	}
+	return maxID + 1
+}
+
+// Databases created before a table was introduced won't have it in their JSON,
+// so it has to be created before anything gets written to it
+func initializeTables(data *DBStructure) {
+	if data.Chirps == nil {
+		data.Chirps = map[int]Chirp{}
+	}
+	if data.Users == nil {
+		data.Users = map[int]FullUser{}
+	}
+	if data.RefreshTokens == nil {
+		data.RefreshTokens = map[string]RefreshToken{}
+	}
+	if data.MagicLinks == nil {
+		data.MagicLinks = map[string]MagicLink{}
+	}
+	if data.AuditLog == nil {
+		data.AuditLog = []AuditEvent{}
+	}
+	if data.ModerationRules == nil {
+		data.ModerationRules = map[string]ModerationRule{}
+	}
+	migrateModerationRules(data)
+	if data.ChirpFlags == nil {
+		data.ChirpFlags = map[int]ChirpFlag{}
+	}
+	if data.ChirpRevisions == nil {
+		data.ChirpRevisions = map[int][]ChirpRevision{}
+	}
+	if data.Following == nil {
+		data.Following = map[int]map[int]time.Time{}
+	}
+	if data.Followers == nil {
+		data.Followers = map[int]map[int]time.Time{}
+	}
+	if data.Likes == nil {
+		data.Likes = map[int]map[int]time.Time{}
+	}
+	if data.UserLikes == nil {
+		data.UserLikes = map[int]map[int]time.Time{}
+	}
+	if data.Rechirps == nil {
+		data.Rechirps = map[int]map[int]time.Time{}
+	}
+	data.LastUserID = max(data.LastUserID, nextID(data.Users)-1)
+	if data.HandleIndex == nil {
+		buildHandleIndex(data)
+	}
+	if data.Blocks == nil {
+		data.Blocks = map[int]map[int]time.Time{}
+	}
+	if data.Mutes == nil {
+		data.Mutes = map[int]map[int]time.Time{}
+	}
+	if data.Media == nil {
+		data.Media = map[int]Media{}
+	}
+	if data.Reports == nil {
+		data.Reports = map[int]Report{}
+	}
+	if data.SubscriptionHistory == nil {
+		data.SubscriptionHistory = map[int][]SubscriptionEvent{}
+		migrateChirpyRed(data)
+	}
+	if data.Conversations == nil {
+		data.Conversations = map[int]Conversation{}
+	}
+	if data.DirectMessages == nil {
+		data.DirectMessages = map[int]DirectMessage{}
+	}
+	if data.ConversationMessages == nil {
+		data.ConversationMessages = map[int][]int{}
+	}
+	if data.UserConversations == nil {
+		data.UserConversations = map[int][]int{}
+	}
+	if data.ConversationReads == nil {
+		data.ConversationReads = map[int]map[int]int{}
+	}
+	if data.DirectMessagePreferences == nil {
+		data.DirectMessagePreferences = map[int]DirectMessagePreferences{}
+	}
+	if data.AuthorIndex == nil {
+		data.AuthorIndex = buildAuthorIndex(data.Chirps)
+	}
+	if data.Replies == nil {
+		data.Replies = buildReplyIndex(data.Chirps)
+	}
+	// Chirps from before replies were introduced all start their own
+	// conversation
+	for id, chirp := range data.Chirps {
+		if chirp.ConversationID == 0 {
+			chirp.ConversationID = id
+			data.Chirps[id] = chirp
+		}
+	}
+	if data.Notifications == nil {
+		data.Notifications = map[int]Notification{}
+	}
+	if data.NotificationIndex == nil {
+		data.NotificationIndex = map[int][]int{}
+	}
+	if data.NotificationPreferences == nil {
+		data.NotificationPreferences = map[int]NotificationPreferences{}
+	}
+	if data.HashtagIndex == nil {
+		buildHashtagIndex(data)
+	}
+	if data.SearchIndex.Postings == nil || data.SearchIndex.DocLengths == nil {
+		buildSearchIndex(data)
+	}
+	data.LastChirpID = max(data.LastChirpID, highestReferencedChirpID(*data))
+	data.LastReportID = max(data.LastReportID, nextID(data.Reports)-1)
+}
+
+// Chirps that were purged before LastChirpID was introduced may still be
+// referenced, so their IDs mustn't be reused either
+func highestReferencedChirpID(data DBStructure) int {
+	highest := nextID(data.Chirps) - 1
+	for _, report := range data.Reports {
+		highest = max(highest, report.ChirpID)
+	}
+	for _, notification := range data.Notifications {
+		if notification.ChirpID != nil {
+			highest = max(highest, *notification.ChirpID)
+		}
+	}
+	return highest
+}
+
+func buildAuthorIndex(chirps map[int]Chirp) map[int][]int {
+	index := map[int][]int{}
+	for id, chirp := range chirps {
+		if !chirp.Deleted {
+			index[chirp.AuthorID] = append(index[chirp.AuthorID], id)
+		}
+	}
+	for _, chirpIDs := range index {
+		sort.Ints(chirpIDs)
+	}
+	return index
 }
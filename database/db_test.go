@@ -0,0 +1,29 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	return New(filepath.Join(t.TempDir(), "database.json"))
+}
+
+func createTestUser(t *testing.T, db *DB, email string) User {
+	t.Helper()
+	user, err := db.CreateUser(email, "password", false)
+	if err != nil {
+		t.Fatalf("failed to create user %s: %s", email, err)
+	}
+	return user
+}
+
+func loadTestDB(t *testing.T, db *DB) DBStructure {
+	t.Helper()
+	data, err := db.loadDB()
+	if err != nil {
+		t.Fatalf("failed to load database: %s", err)
+	}
+	return data
+}
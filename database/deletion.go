@@ -0,0 +1,224 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrNoPassword = errors.New("user has no password")
+
+// Whether password is the user's password. Returns ErrNoPassword for users
+// that signed up through a magic link
+func (db *DB) CheckPassword(id int, password string) (bool, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return false, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	user, exists := data.Users[id]
+	if !exists {
+		return false, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+	}
+	if user.Password == "" {
+		return false, fmt.Errorf("user %d: %w", id, ErrNoPassword)
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil, nil
+}
+
+// Schedules the user to be deleted at deleteAt, and revokes their refresh
+// tokens so that they have to log in again to cancel the deletion
+func (db *DB) ScheduleUserDeletion(id int, deleteAt time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	user, exists := data.Users[id]
+	if !exists {
+		return fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+	}
+
+	deleteAt = deleteAt.UTC()
+	user.DeleteAt = &deleteAt
+	data.Users[id] = user
+	revokeRefreshTokens(&data, id)
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to schedule deletion of user %d: %s", id, err)
+	}
+
+	return nil
+}
+
+// Returns whether the user was scheduled for deletion
+func (db *DB) CancelUserDeletion(id int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return false, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	user, exists := data.Users[id]
+	if !exists || user.DeleteAt == nil {
+		return false, nil
+	}
+
+	user.DeleteAt = nil
+	data.Users[id] = user
+
+	if err := db.writeDB(data); err != nil {
+		return false, fmt.Errorf("failed to cancel deletion of user %d: %s", id, err)
+	}
+
+	return true, nil
+}
+
+// Deletes the users whose grace period has ended. Returns the IDs of the
+// deleted users, and their media so that the caller can delete its blobs
+func (db *DB) PurgeDeletedUsers(now time.Time) ([]int, []Media, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	ids := []int{}
+	for id, user := range data.Users {
+		if user.DeleteAt != nil && !user.DeleteAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return ids, []Media{}, nil
+	}
+	sort.Ints(ids)
+
+	media := []Media{}
+	for _, id := range ids {
+		media = append(media, purgeUser(&data, id)...)
+	}
+
+	if err := db.writeDB(data); err != nil {
+		return nil, nil, fmt.Errorf("failed to delete users: %s", err)
+	}
+
+	return ids, media, nil
+}
+
+func revokeRefreshTokens(data *DBStructure, userID int) {
+	for token, refreshToken := range data.RefreshTokens {
+		if refreshToken.UserID == userID {
+			delete(data.RefreshTokens, token)
+		}
+	}
+}
+
+// Removes the user along with their chirps, likes, rechirps, follows, blocks,
+// mutes, sessions, notifications and reports. Their direct messages are kept for the
+// other participants, and the audit log is kept as is. Returns the user's
+// media
+func purgeUser(data *DBStructure, id int) []Media {
+	user := data.Users[id]
+
+	media := []Media{}
+	for mediaID, m := range data.Media {
+		if m.OwnerID == id {
+			media = append(media, m)
+			delete(data.Media, mediaID)
+		}
+	}
+
+	// Replies are deleted before the chirps they reply to, so that no
+	// placeholders are left for threads that only consist of the user's chirps
+	chirpIDs := slices.Clone(data.AuthorIndex[id])
+	slices.Reverse(chirpIDs)
+	for _, chirpID := range chirpIDs {
+		deleteChirp(data, chirpID)
+	}
+	delete(data.AuthorIndex, id)
+
+	for chirpID := range data.UserLikes[id] {
+		if chirp, exists := data.Chirps[chirpID]; exists {
+			chirp.LikeCount--
+			data.Chirps[chirpID] = chirp
+		}
+		removeRelationship(data.Likes, chirpID, id)
+	}
+	delete(data.UserLikes, id)
+
+	for chirpID, rechirpers := range data.Rechirps {
+		if _, rechirped := rechirpers[id]; !rechirped {
+			continue
+		}
+		if chirp, exists := data.Chirps[chirpID]; exists {
+			chirp.RechirpCount--
+			data.Chirps[chirpID] = chirp
+		}
+		removeRelationship(data.Rechirps, chirpID, id)
+	}
+
+	for followeeID := range data.Following[id] {
+		removeFollow(data, id, followeeID)
+	}
+	for followerID := range data.Followers[id] {
+		removeFollow(data, followerID, id)
+	}
+
+	for _, table := range []map[int]map[int]time.Time{data.Blocks, data.Mutes} {
+		delete(table, id)
+		for otherUserID := range table {
+			removeRelationship(table, otherUserID, id)
+		}
+	}
+
+	revokeRefreshTokens(data, id)
+	for token, link := range data.MagicLinks {
+		if link.Email == user.Email {
+			delete(data.MagicLinks, token)
+		}
+	}
+
+	for notificationID, notification := range data.Notifications {
+		if notification.UserID != id && notification.ActorID != id {
+			continue
+		}
+		delete(data.Notifications, notificationID)
+		data.NotificationIndex[notification.UserID] = slices.DeleteFunc(data.NotificationIndex[notification.UserID], func(indexID int) bool {
+			return indexID == notificationID
+		})
+	}
+	delete(data.NotificationIndex, id)
+	delete(data.NotificationPreferences, id)
+
+	for _, conversationID := range data.UserConversations[id] {
+		delete(data.ConversationReads[conversationID], id)
+	}
+	delete(data.UserConversations, id)
+	delete(data.DirectMessagePreferences, id)
+
+	for reportID, report := range data.Reports {
+		if report.ReporterID == id {
+			delete(data.Reports, reportID)
+		}
+	}
+
+	delete(data.SubscriptionHistory, id)
+	delete(data.HandleIndex, strings.ToLower(user.Handle))
+	delete(data.Users, id)
+
+	return media
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func createTestChirp(t *testing.T, db *DB, body string, authorID int, inReplyToID *int) Chirp {
+	t.Helper()
+	chirp, err := db.CreateChirp(body, authorID, inReplyToID, nil)
+	if err != nil {
+		t.Fatalf("failed to create chirp: %s", err)
+	}
+	return chirp
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice@example.com")
+	bob := createTestUser(t, db, "bob@example.com")
+	carol := createTestUser(t, db, "carol@example.com")
+
+	root := createTestChirp(t, db, "Hello", alice.ID, nil)
+	reply := createTestChirp(t, db, "Hi alice", bob.ID, &root.ID)
+	createTestChirp(t, db, "Hi bob", alice.ID, &reply.ID)
+	standalone := createTestChirp(t, db, "Just me", alice.ID, nil)
+	bobsChirp := createTestChirp(t, db, "Bob here", bob.ID, nil)
+
+	if _, err := db.LikeChirp(alice.ID, bobsChirp.ID); err != nil {
+		t.Fatalf("failed to like chirp: %s", err)
+	}
+	if _, err := db.Rechirp(alice.ID, bobsChirp.ID); err != nil {
+		t.Fatalf("failed to rechirp: %s", err)
+	}
+	if _, err := db.Follow(alice.ID, bob.ID); err != nil {
+		t.Fatalf("failed to follow: %s", err)
+	}
+	if _, err := db.Follow(bob.ID, alice.ID); err != nil {
+		t.Fatalf("failed to follow: %s", err)
+	}
+	if err := db.Mute(bob.ID, alice.ID); err != nil {
+		t.Fatalf("failed to mute: %s", err)
+	}
+
+	now := time.Now()
+	if err := db.ScheduleUserDeletion(alice.ID, now.Add(-time.Minute)); err != nil {
+		t.Fatalf("failed to schedule deletion: %s", err)
+	}
+	if err := db.ScheduleUserDeletion(carol.ID, now.Add(time.Hour)); err != nil {
+		t.Fatalf("failed to schedule deletion: %s", err)
+	}
+	if err := db.SaveRefreshToken("token", alice.ID, time.Hour); err != nil {
+		t.Fatalf("failed to save refresh token: %s", err)
+	}
+
+	ids, _, err := db.PurgeDeletedUsers(now)
+	if err != nil {
+		t.Fatalf("failed to purge users: %s", err)
+	}
+	if len(ids) != 1 || ids[0] != alice.ID {
+		t.Fatalf("got purged users %v, want [%d]", ids, alice.ID)
+	}
+
+	data := loadTestDB(t, db)
+	if _, exists := data.Users[alice.ID]; exists {
+		t.Error("purged user still exists")
+	}
+	if _, exists := data.Users[carol.ID]; !exists {
+		t.Error("user whose grace period hasn't ended was purged")
+	}
+
+	if chirp, exists := data.Chirps[root.ID]; !exists || !chirp.Deleted || chirp.Body != "" {
+		t.Errorf("got root chirp %+v, want a placeholder for bob's reply", chirp)
+	}
+	if chirp := data.Chirps[reply.ID]; chirp.ReplyCount != 0 {
+		t.Errorf("got %d replies to bob's reply, want 0", chirp.ReplyCount)
+	}
+	if _, exists := data.Chirps[standalone.ID]; exists {
+		t.Error("chirp without replies wasn't removed")
+	}
+	if _, exists := data.AuthorIndex[alice.ID]; exists {
+		t.Error("purged user is still in the author index")
+	}
+
+	if chirp := data.Chirps[bobsChirp.ID]; chirp.LikeCount != 0 || chirp.RechirpCount != 0 {
+		t.Errorf("got %d likes and %d rechirps, want none", chirp.LikeCount, chirp.RechirpCount)
+	}
+	if len(data.Following[bob.ID]) != 0 || len(data.Followers[bob.ID]) != 0 {
+		t.Error("purged user is still followed by or following bob")
+	}
+	if len(data.Mutes[bob.ID]) != 0 {
+		t.Error("purged user is still muted by bob")
+	}
+	if _, exists := data.RefreshTokens["token"]; exists {
+		t.Error("purged user's refresh token still exists")
+	}
+}
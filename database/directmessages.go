@@ -0,0 +1,334 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+var (
+	ErrUserNotFound         = errors.New("user does not exist")
+	ErrConversationNotFound = errors.New("conversation does not exist")
+	// The recipient only accepts direct messages from users that they follow
+	ErrDirectMessagesRestricted = errors.New("user only accepts direct messages from users they follow")
+)
+
+// Who can start conversations with a user
+type DirectMessagePolicy string
+
+const (
+	DirectMessagesFromEveryone  DirectMessagePolicy = "everyone"
+	DirectMessagesFromFollowing DirectMessagePolicy = "following"
+)
+
+type DirectMessagePreferences struct {
+	AllowFrom DirectMessagePolicy `json:"allow_from"`
+}
+
+var DefaultDirectMessagePreferences = DirectMessagePreferences{AllowFrom: DirectMessagesFromEveryone}
+
+type Conversation struct {
+	ID int `json:"id"`
+	// In ascending order, including the creator
+	ParticipantIDs []int     `json:"participant_ids"`
+	CreatorID      int       `json:"creator_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	// Increases whenever the conversation is created or gets a message, so
+	// that conversations can be ordered by their latest activity
+	Activity int `json:"activity"`
+}
+
+func (c Conversation) HasParticipant(userID int) bool {
+	_, found := slices.BinarySearch(c.ParticipantIDs, userID)
+	return found
+}
+
+type DirectMessage struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// A conversation as seen by one of its participants
+type ConversationSummary struct {
+	Conversation
+	LastMessage *DirectMessage `json:"last_message"`
+	UnreadCount int            `json:"unread_count"`
+}
+
+func directMessagePreferences(data DBStructure, userID int) DirectMessagePreferences {
+	preferences, exists := data.DirectMessagePreferences[userID]
+	if !exists {
+		return DefaultDirectMessagePreferences
+	}
+	return preferences
+}
+
+// Whether the recipient accepts direct messages from the sender
+func acceptsDirectMessages(data DBStructure, recipientID, senderID int) bool {
+	if directMessagePreferences(data, recipientID).AllowFrom != DirectMessagesFromFollowing {
+		return true
+	}
+	_, follows := data.Following[recipientID][senderID]
+	return follows
+}
+
+func summarizeConversation(data DBStructure, conversation Conversation, userID int) ConversationSummary {
+	summary := ConversationSummary{Conversation: conversation}
+
+	messageIDs := data.ConversationMessages[conversation.ID]
+	if len(messageIDs) > 0 {
+		lastMessage := data.DirectMessages[messageIDs[len(messageIDs)-1]]
+		summary.LastMessage = &lastMessage
+	}
+
+	lastReadID := data.ConversationReads[conversation.ID][userID]
+	for i := len(messageIDs) - 1; i >= 0 && messageIDs[i] > lastReadID; i-- {
+		if data.DirectMessages[messageIDs[i]].SenderID != userID {
+			summary.UnreadCount++
+		}
+	}
+
+	return summary
+}
+
+// Starts a conversation between the creator and the participants. Since there
+// is only ever one conversation between the same set of users, the existing
+// conversation is returned if there already is one, along with false.
+//
+// Returns ErrUserNotFound if a participant doesn't exist, ErrBlocked if a
+// participant and the creator have blocked one another, and
+// ErrDirectMessagesRestricted if a participant doesn't accept direct messages
+// from the creator. They are wrapped with the participant's ID
+func (db *DB) CreateConversation(creatorID int, participantIDs []int) (*Conversation, bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	participants := append([]int{creatorID}, participantIDs...)
+	sort.Ints(participants)
+	participants = slices.Compact(participants)
+
+	for _, id := range participants {
+		if _, exists := data.Users[id]; !exists {
+			return nil, false, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+		}
+		if id != creatorID && isBlocked(data, id, creatorID) {
+			return nil, false, fmt.Errorf("user %d: %w", id, ErrBlocked)
+		}
+		if id != creatorID && !acceptsDirectMessages(data, id, creatorID) {
+			return nil, false, fmt.Errorf("user %d: %w", id, ErrDirectMessagesRestricted)
+		}
+	}
+
+	for _, id := range data.UserConversations[creatorID] {
+		conversation := data.Conversations[id]
+		if slices.Equal(conversation.ParticipantIDs, participants) {
+			return &conversation, false, nil
+		}
+	}
+
+	data.ConversationActivity++
+	conversation := Conversation{
+		ID:             nextID(data.Conversations),
+		ParticipantIDs: participants,
+		CreatorID:      creatorID,
+		CreatedAt:      time.Now().UTC(),
+		Activity:       data.ConversationActivity,
+	}
+	data.Conversations[conversation.ID] = conversation
+	for _, id := range participants {
+		data.UserConversations[id] = append(data.UserConversations[id], conversation.ID)
+	}
+
+	if err := db.writeDB(data); err != nil {
+		return nil, false, fmt.Errorf("failed to save conversation: %s", err)
+	}
+
+	return &conversation, true, nil
+}
+
+// Returns nil if the conversation doesn't exist
+func (db *DB) GetConversation(id int) (*Conversation, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	conversation, exists := data.Conversations[id]
+	if !exists {
+		return nil, nil
+	}
+
+	return &conversation, nil
+}
+
+// Returns the conversation with its last message and the number of messages
+// that the user hasn't read. Returns nil if the conversation doesn't exist
+func (db *DB) GetConversationSummary(id, userID int) (*ConversationSummary, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	conversation, exists := data.Conversations[id]
+	if !exists {
+		return nil, nil
+	}
+
+	summary := summarizeConversation(data, conversation, userID)
+	return &summary, nil
+}
+
+// Returns the user's conversations, with the most recently active first
+func (db *DB) GetConversations(userID int) ([]ConversationSummary, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	summaries := []ConversationSummary{}
+	for _, id := range data.UserConversations[userID] {
+		summaries = append(summaries, summarizeConversation(data, data.Conversations[id], userID))
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Activity > summaries[j].Activity
+	})
+
+	return summaries, nil
+}
+
+// Sends a message to a conversation, which also marks the conversation as read
+// by the sender. Messages in conversations between two users are subject to
+// blocks and the recipient's preferences, in case they have changed since the
+// conversation started. Returns ErrConversationNotFound if
+// the conversation doesn't exist or the sender isn't a participant
+func (db *DB) SendDirectMessage(conversationID, senderID int, body string) (*DirectMessage, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	conversation, exists := data.Conversations[conversationID]
+	if !exists || !conversation.HasParticipant(senderID) {
+		return nil, ErrConversationNotFound
+	}
+
+	if len(conversation.ParticipantIDs) == 2 {
+		for _, id := range conversation.ParticipantIDs {
+			if id != senderID && isBlocked(data, id, senderID) {
+				return nil, fmt.Errorf("user %d: %w", id, ErrBlocked)
+			}
+			if id != senderID && !acceptsDirectMessages(data, id, senderID) {
+				return nil, fmt.Errorf("user %d: %w", id, ErrDirectMessagesRestricted)
+			}
+		}
+	}
+
+	message := DirectMessage{
+		ID:             nextID(data.DirectMessages),
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Body:           body,
+		CreatedAt:      time.Now().UTC(),
+	}
+	data.DirectMessages[message.ID] = message
+	data.ConversationMessages[conversationID] = append(data.ConversationMessages[conversationID], message.ID)
+
+	data.ConversationActivity++
+	conversation.Activity = data.ConversationActivity
+	data.Conversations[conversationID] = conversation
+
+	setConversationRead(&data, conversationID, senderID, message.ID)
+
+	if err := db.writeDB(data); err != nil {
+		return nil, fmt.Errorf("failed to save direct message: %s", err)
+	}
+
+	return &message, nil
+}
+
+// Returns the conversation's messages in ascending order
+func (db *DB) GetDirectMessages(conversationID int) ([]DirectMessage, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	messages := []DirectMessage{}
+	for _, id := range data.ConversationMessages[conversationID] {
+		messages = append(messages, data.DirectMessages[id])
+	}
+
+	return messages, nil
+}
+
+func setConversationRead(data *DBStructure, conversationID, userID, messageID int) {
+	if data.ConversationReads[conversationID] == nil {
+		data.ConversationReads[conversationID] = map[int]int{}
+	}
+	data.ConversationReads[conversationID][userID] = messageID
+}
+
+// Marks all messages in the conversation as read by the user
+func (db *DB) MarkConversationRead(conversationID, userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	messageIDs := data.ConversationMessages[conversationID]
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	setConversationRead(&data, conversationID, userID, messageIDs[len(messageIDs)-1])
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to mark conversation %d as read: %s", conversationID, err)
+	}
+
+	return nil
+}
+
+func (db *DB) GetDirectMessagePreferences(userID int) (DirectMessagePreferences, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return DirectMessagePreferences{}, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return directMessagePreferences(data, userID), nil
+}
+
+func (db *DB) UpdateDirectMessagePreferences(userID int, preferences DirectMessagePreferences) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	data.DirectMessagePreferences[userID] = preferences
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to save direct message preferences of user %d: %s", userID, err)
+	}
+
+	return nil
+}
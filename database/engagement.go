@@ -0,0 +1,142 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Whether a user has liked and rechirped a chirp
+type Engagement struct {
+	Liked     bool
+	Rechirped bool
+}
+
+// Adds or removes the user in the chirp's set of likers or rechirpers and
+// updates the chirp's counter. Doing nothing when the user is already in the
+// desired state makes likes and rechirps idempotent. Returns ErrChirpNotFound
+// if the chirp doesn't exist
+func (db *DB) setEngagement(userID, chirpID int, rechirp, engaged bool) (*Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	chirp, exists := data.Chirps[chirpID]
+	if !exists || chirp.Deleted || chirp.Hidden {
+		return nil, ErrChirpNotFound
+	}
+
+	table, counter := data.Likes, &chirp.LikeCount
+	if rechirp {
+		table, counter = data.Rechirps, &chirp.RechirpCount
+	}
+
+	_, alreadyEngaged := table[chirpID][userID]
+	if alreadyEngaged == engaged {
+		return &chirp, nil
+	}
+
+	now := time.Now().UTC()
+	if engaged {
+		if table[chirpID] == nil {
+			table[chirpID] = map[int]time.Time{}
+		}
+		table[chirpID][userID] = now
+		*counter++
+	} else {
+		delete(table[chirpID], userID)
+		if len(table[chirpID]) == 0 {
+			delete(table, chirpID)
+		}
+		*counter--
+	}
+
+	if !rechirp {
+		if engaged {
+			if data.UserLikes[userID] == nil {
+				data.UserLikes[userID] = map[int]time.Time{}
+			}
+			data.UserLikes[userID][chirpID] = now
+		} else {
+			delete(data.UserLikes[userID], chirpID)
+			if len(data.UserLikes[userID]) == 0 {
+				delete(data.UserLikes, userID)
+			}
+		}
+	}
+
+	data.Chirps[chirpID] = chirp
+
+	if err := db.writeDB(data); err != nil {
+		return nil, fmt.Errorf("failed to write engagement with chirp %d: %s", chirpID, err)
+	}
+
+	return &chirp, nil
+}
+
+func (db *DB) LikeChirp(userID, chirpID int) (*Chirp, error) {
+	return db.setEngagement(userID, chirpID, false, true)
+}
+
+func (db *DB) UnlikeChirp(userID, chirpID int) (*Chirp, error) {
+	return db.setEngagement(userID, chirpID, false, false)
+}
+
+func (db *DB) Rechirp(userID, chirpID int) (*Chirp, error) {
+	return db.setEngagement(userID, chirpID, true, true)
+}
+
+func (db *DB) UndoRechirp(userID, chirpID int) (*Chirp, error) {
+	return db.setEngagement(userID, chirpID, true, false)
+}
+
+// Removes all likes and rechirps of a deleted chirp
+func removeEngagement(data *DBStructure, chirpID int) {
+	for userID := range data.Likes[chirpID] {
+		delete(data.UserLikes[userID], chirpID)
+		if len(data.UserLikes[userID]) == 0 {
+			delete(data.UserLikes, userID)
+		}
+	}
+	delete(data.Likes, chirpID)
+	delete(data.Rechirps, chirpID)
+}
+
+// Returns the chirps that the user has liked, sorted by ID
+func (db *DB) GetLikedChirps(userID int) ([]Chirp, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	chirps := []Chirp{}
+	for chirpID := range data.UserLikes[userID] {
+		if chirp, exists := data.Chirps[chirpID]; exists && !chirp.Deleted && !chirp.Hidden {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+
+	return chirps, nil
+}
+
+// Returns the user's engagement with each of the chirps
+func (db *DB) GetEngagement(userID int, chirpIDs []int) (map[int]Engagement, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	engagement := map[int]Engagement{}
+	for _, chirpID := range chirpIDs {
+		_, liked := data.Likes[chirpID][userID]
+		_, rechirped := data.Rechirps[chirpID][userID]
+		engagement[chirpID] = Engagement{Liked: liked, Rechirped: rechirped}
+	}
+
+	return engagement, nil
+}
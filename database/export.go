@@ -0,0 +1,154 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A relationship with another user, such as a follow or a block
+type Relationship struct {
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A like or rechirp
+type ChirpActivity struct {
+	ChirpID   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A session, without its refresh token
+type Session struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Everything that's stored about a user, except their password hash
+type UserExport struct {
+	User                     User                     `json:"user"`
+	Role                     string                   `json:"role"`
+	DeleteAt                 *time.Time               `json:"delete_at"`
+	Suspension               *Suspension              `json:"suspension"`
+	Subscription             *Subscription            `json:"subscription"`
+	SubscriptionHistory      []SubscriptionEvent      `json:"subscription_history"`
+	Chirps                   []Chirp                  `json:"chirps"`
+	ChirpRevisions           map[int][]ChirpRevision  `json:"chirp_revisions"`
+	Media                    []Media                  `json:"media"`
+	Likes                    []ChirpActivity          `json:"likes"`
+	Rechirps                 []ChirpActivity          `json:"rechirps"`
+	Following                []Relationship           `json:"following"`
+	Followers                []Relationship           `json:"followers"`
+	Blocks                   []Relationship           `json:"blocks"`
+	Mutes                    []Relationship           `json:"mutes"`
+	Notifications            []Notification           `json:"notifications"`
+	NotificationPreferences  NotificationPreferences  `json:"notification_preferences"`
+	Conversations            []Conversation           `json:"conversations"`
+	DirectMessages           []DirectMessage          `json:"direct_messages"`
+	DirectMessagePreferences DirectMessagePreferences `json:"direct_message_preferences"`
+	Sessions                 []Session                `json:"sessions"`
+	// Reports that the user has made
+	Reports []Report `json:"reports"`
+	// Events where the user is either the actor or the subject
+	AuditLog   []AuditEvent `json:"audit_log"`
+	ExportedAt time.Time    `json:"exported_at"`
+}
+
+func relationships(table map[int]time.Time) []Relationship {
+	list := make([]Relationship, 0, len(table))
+	for userID, createdAt := range table {
+		list = append(list, Relationship{UserID: userID, CreatedAt: createdAt})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+	return list
+}
+
+func sortChirpActivity(list []ChirpActivity) {
+	sort.Slice(list, func(i, j int) bool { return list[i].ChirpID < list[j].ChirpID })
+}
+
+// Returns ErrUserNotFound if the user doesn't exist
+func (db *DB) ExportUser(id int) (*UserExport, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	user, exists := data.Users[id]
+	if !exists {
+		return nil, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+	}
+
+	export := UserExport{
+		User:                     user.User,
+		Role:                     user.Role,
+		DeleteAt:                 user.DeleteAt,
+		Suspension:               user.Suspension,
+		Subscription:             user.Subscription,
+		SubscriptionHistory:      append([]SubscriptionEvent{}, data.SubscriptionHistory[id]...),
+		Chirps:                   []Chirp{},
+		ChirpRevisions:           map[int][]ChirpRevision{},
+		Media:                    []Media{},
+		Likes:                    []ChirpActivity{},
+		Rechirps:                 []ChirpActivity{},
+		Following:                relationships(data.Following[id]),
+		Followers:                relationships(data.Followers[id]),
+		Blocks:                   relationships(data.Blocks[id]),
+		Mutes:                    relationships(data.Mutes[id]),
+		Notifications:            []Notification{},
+		NotificationPreferences:  notificationPreferences(data, id),
+		Conversations:            []Conversation{},
+		DirectMessages:           []DirectMessage{},
+		DirectMessagePreferences: directMessagePreferences(data, id),
+		Sessions:                 userSessions(data, id),
+		Reports:                  userReports(data, id),
+		AuditLog:                 []AuditEvent{},
+		ExportedAt:               time.Now().UTC(),
+	}
+	if export.Role == "" {
+		export.Role = RoleUser
+	}
+
+	for _, chirpID := range data.AuthorIndex[id] {
+		export.Chirps = append(export.Chirps, data.Chirps[chirpID])
+		if revisions := data.ChirpRevisions[chirpID]; len(revisions) > 0 {
+			export.ChirpRevisions[chirpID] = revisions
+		}
+	}
+
+	for _, m := range data.Media {
+		if m.OwnerID == id {
+			export.Media = append(export.Media, m)
+		}
+	}
+	sort.Slice(export.Media, func(i, j int) bool { return export.Media[i].ID < export.Media[j].ID })
+
+	for chirpID, createdAt := range data.UserLikes[id] {
+		export.Likes = append(export.Likes, ChirpActivity{ChirpID: chirpID, CreatedAt: createdAt})
+	}
+	sortChirpActivity(export.Likes)
+	for chirpID, rechirpers := range data.Rechirps {
+		if createdAt, rechirped := rechirpers[id]; rechirped {
+			export.Rechirps = append(export.Rechirps, ChirpActivity{ChirpID: chirpID, CreatedAt: createdAt})
+		}
+	}
+	sortChirpActivity(export.Rechirps)
+
+	for _, notificationID := range data.NotificationIndex[id] {
+		export.Notifications = append(export.Notifications, data.Notifications[notificationID])
+	}
+
+	for _, conversationID := range data.UserConversations[id] {
+		export.Conversations = append(export.Conversations, data.Conversations[conversationID])
+		for _, messageID := range data.ConversationMessages[conversationID] {
+			export.DirectMessages = append(export.DirectMessages, data.DirectMessages[messageID])
+		}
+	}
+
+	for _, event := range data.AuditLog {
+		if event.ActorID == id || event.UserID == id {
+			export.AuditLog = append(export.AuditLog, event)
+		}
+	}
+
+	return &export, nil
+}
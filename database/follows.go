@@ -0,0 +1,198 @@
+package database
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Adds a follow relationship. Following someone twice has no effect. Returns
+// whether the user wasn't already followed, and ErrBlocked if either user has
+// blocked the other
+func (db *DB) Follow(followerID, followeeID int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return false, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	if isBlocked(data, followerID, followeeID) {
+		return false, ErrBlocked
+	}
+
+	if _, exists := data.Following[followerID][followeeID]; exists {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	if data.Following[followerID] == nil {
+		data.Following[followerID] = map[int]time.Time{}
+	}
+	if data.Followers[followeeID] == nil {
+		data.Followers[followeeID] = map[int]time.Time{}
+	}
+	data.Following[followerID][followeeID] = now
+	data.Followers[followeeID][followerID] = now
+
+	if err := db.writeDB(data); err != nil {
+		return false, fmt.Errorf("failed to follow user %d: %s", followeeID, err)
+	}
+
+	return true, nil
+}
+
+func (db *DB) Unfollow(followerID, followeeID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	removeFollow(&data, followerID, followeeID)
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to unfollow user %d: %s", followeeID, err)
+	}
+
+	return nil
+}
+
+func removeFollow(data *DBStructure, followerID, followeeID int) {
+	removeRelationship(data.Following, followerID, followeeID)
+	removeRelationship(data.Followers, followeeID, followerID)
+}
+
+func (db *DB) IsFollowing(followerID, followeeID int) (bool, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return false, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	_, exists := data.Following[followerID][followeeID]
+	return exists, nil
+}
+
+func usersByID(data DBStructure, ids map[int]time.Time) []User {
+	users := make([]User, 0, len(ids))
+	for id := range ids {
+		if user, exists := data.Users[id]; exists {
+			users = append(users, *user.toUser())
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// Returns the users that follow the user, sorted by ID
+func (db *DB) GetFollowers(userID int) ([]User, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return usersByID(data, data.Followers[userID]), nil
+}
+
+// Returns the users that the user follows, sorted by ID
+func (db *DB) GetFollowing(userID int) ([]User, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return usersByID(data, data.Following[userID]), nil
+}
+
+// Returns the number of followers and followed users
+func (db *DB) GetFollowCounts(userID int) (int, int, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return len(data.Followers[userID]), len(data.Following[userID]), nil
+}
+
+// One author's chirp IDs in the merge, read from the end towards the start
+type timelineCursor struct {
+	chirpIDs []int
+	position int
+}
+
+// A max-heap of authors, ordered by their next chirp ID
+type timelineHeap []*timelineCursor
+
+func (h timelineHeap) Len() int { return len(h) }
+func (h timelineHeap) Less(i, j int) bool {
+	return h[i].chirpIDs[h[i].position] > h[j].chirpIDs[h[j].position]
+}
+func (h timelineHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *timelineHeap) Push(x any)   { *h = append(*h, x.(*timelineCursor)) }
+func (h *timelineHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// Returns the newest chirps by the user and the users they follow, except
+// for users they have muted, newest first. Only chirps with IDs below beforeID are included, if it's given.
+// Also returns whether there are older chirps that the user can see.
+//
+// The authors' chirp ID lists are already sorted, so they're merged with a
+// heap, which only has to look at the chirps up to the page's end rather than
+// every chirp by every followed user
+func (db *DB) Timeline(userID int, beforeID *int, limit int) ([]Chirp, bool, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	authors := []int{userID}
+	hidden := hiddenUserIDs(data, userID)
+	for followeeID := range data.Following[userID] {
+		if !hidden[followeeID] {
+			authors = append(authors, followeeID)
+		}
+	}
+
+	h := &timelineHeap{}
+	for _, authorID := range authors {
+		chirpIDs := data.AuthorIndex[authorID]
+		end := len(chirpIDs)
+		if beforeID != nil {
+			end = sort.SearchInts(chirpIDs, *beforeID)
+		}
+		if end > 0 {
+			*h = append(*h, &timelineCursor{chirpIDs: chirpIDs, position: end - 1})
+		}
+	}
+	heap.Init(h)
+
+	// One more than the limit is collected to know whether there are more
+	// visible chirps after this page
+	chirps := []Chirp{}
+	for h.Len() > 0 && len(chirps) <= limit {
+		cursor := (*h)[0]
+		if chirp, exists := data.Chirps[cursor.chirpIDs[cursor.position]]; exists && !chirp.Hidden {
+			chirps = append(chirps, chirp)
+		}
+
+		if cursor.position == 0 {
+			heap.Pop(h)
+		} else {
+			cursor.position--
+			heap.Fix(h, 0)
+		}
+	}
+
+	if len(chirps) > limit {
+		return chirps[:limit], true, nil
+	}
+	return chirps, false, nil
+}
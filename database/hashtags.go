@@ -0,0 +1,161 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mawkler/go-web-server/entities"
+)
+
+type TrendingHashtag struct {
+	Tag string `json:"tag"`
+	// Number of chirps with the hashtag within the window
+	Count int `json:"count"`
+	// Number of chirps with the hashtag within the window before that, to
+	// compare against
+	PreviousCount int `json:"previous_count"`
+}
+
+// Extracts the chirp's entities and resolves its mentions to users. Users that
+// have blocked the author or been blocked by them can't be mentioned, so their
+// mentions are left unresolved
+func extractEntities(data DBStructure, body string, authorID int) []entities.Entity {
+	chirpEntities := entities.Extract(body)
+	for i, entity := range chirpEntities {
+		if entity.Type != entities.TypeMention {
+			continue
+		}
+		userID := resolveMention(data, entity.Text)
+		if userID != nil && !isBlocked(data, authorID, *userID) {
+			chirpEntities[i].UserID = userID
+		}
+	}
+	return chirpEntities
+}
+
+// Mentions refer to users by their handle
+func resolveMention(data DBStructure, name string) *int {
+	id, exists := data.HandleIndex[strings.ToLower(name)]
+	if !exists {
+		return nil
+	}
+	return &id
+}
+
+func chirpHashtags(chirp Chirp) []string {
+	hashtags := []string{}
+	for _, entity := range chirp.Entities {
+		if entity.Type == entities.TypeHashtag && !slices.Contains(hashtags, entity.Text) {
+			hashtags = append(hashtags, entity.Text)
+		}
+	}
+	return hashtags
+}
+
+func addToHashtagIndex(data *DBStructure, chirp Chirp) {
+	for _, hashtag := range chirpHashtags(chirp) {
+		chirpIDs := data.HashtagIndex[hashtag]
+		position, _ := slices.BinarySearch(chirpIDs, chirp.ID)
+		data.HashtagIndex[hashtag] = slices.Insert(chirpIDs, position, chirp.ID)
+	}
+}
+
+func removeFromHashtagIndex(data *DBStructure, chirp Chirp) {
+	for _, hashtag := range chirpHashtags(chirp) {
+		data.HashtagIndex[hashtag] = slices.DeleteFunc(data.HashtagIndex[hashtag], func(chirpID int) bool {
+			return chirpID == chirp.ID
+		})
+		if len(data.HashtagIndex[hashtag]) == 0 {
+			delete(data.HashtagIndex, hashtag)
+		}
+	}
+}
+
+// Chirps from before entities were introduced get them extracted here
+func buildHashtagIndex(data *DBStructure) {
+	data.HashtagIndex = map[string][]int{}
+	for id, chirp := range data.Chirps {
+		if chirp.Deleted {
+			continue
+		}
+		if chirp.Entities == nil {
+			chirp.Entities = extractEntities(*data, chirp.Body, chirp.AuthorID)
+			data.Chirps[id] = chirp
+		}
+		addToHashtagIndex(data, chirp)
+	}
+}
+
+// Returns the chirps with the hashtag, sorted by ID. The hashtag is normalized
+// and may start with a `#`
+func (db *DB) GetChirpsByHashtag(hashtag string) ([]Chirp, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	hashtag = entities.NormalizeHashtag(hashtag)
+
+	chirps := []Chirp{}
+	for _, chirpID := range data.HashtagIndex[hashtag] {
+		if chirp, exists := data.Chirps[chirpID]; exists && !chirp.Hidden {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	return chirps, nil
+}
+
+// Returns the hashtags used in the most chirps within the window, most used
+// first
+func (db *DB) TrendingHashtags(window time.Duration, limit int) ([]TrendingHashtag, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	now := time.Now()
+	windowStart := now.Add(-window)
+	previousWindowStart := windowStart.Add(-window)
+
+	trending := []TrendingHashtag{}
+	for hashtag, chirpIDs := range data.HashtagIndex {
+		hashtagTrend := TrendingHashtag{Tag: hashtag}
+
+		// Chirp IDs increase with time, so the newest chirps are at the end
+		for i := len(chirpIDs) - 1; i >= 0; i-- {
+			createdAt := data.Chirps[chirpIDs[i]].CreatedAt
+			if createdAt.After(windowStart) {
+				hashtagTrend.Count++
+			} else if createdAt.After(previousWindowStart) {
+				hashtagTrend.PreviousCount++
+			} else {
+				break
+			}
+		}
+
+		if hashtagTrend.Count > 0 {
+			trending = append(trending, hashtagTrend)
+		}
+	}
+
+	sort.Slice(trending, func(i, j int) bool {
+		a, b := trending[i], trending[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if growthA, growthB := a.Count-a.PreviousCount, b.Count-b.PreviousCount; growthA != growthB {
+			return growthA > growthB
+		}
+		return a.Tag < b.Tag
+	})
+
+	if len(trending) > limit {
+		trending = trending[:limit]
+	}
+
+	return trending, nil
+}
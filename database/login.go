@@ -3,25 +3,63 @@ package database
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (db DB) Login(email, password string) (*User, error) {
-	user, err := db.getUserByEmail(email)
+// Returns nil if the user doesn't exist. Suspended users get ErrUserSuspended,
+// but only once they've given the correct password. Wrong passwords are
+// counted on the account for admins to see, but don't lock it
+func (db *DB) Login(email, password string) (*User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
 	if err != nil {
 		return nil, fmt.Errorf("login failed: %s", err)
 	}
 
+	var user *FullUser
+	for _, u := range data.Users {
+		if u.Email == email {
+			user = &u
+			break
+		}
+	}
+
 	if user == nil {
 		log.Printf("login failed, user %s does not exist", email)
 		return nil, nil
 	}
 
+	if user.Password == "" {
+		return nil, fmt.Errorf("user %s has no password", email)
+	}
+
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
+		now := time.Now().UTC()
+		user.FailedLogins++
+		user.LastFailedLoginAt = &now
+		data.Users[user.ID] = *user
+		if writeErr := db.writeDB(data); writeErr != nil {
+			return nil, fmt.Errorf("failed to record failed login: %s", writeErr)
+		}
 		return nil, fmt.Errorf("invalid password: %s", err)
 	}
 
-	return &user.User, nil
+	if user.Suspension != nil {
+		return nil, fmt.Errorf("user %s: %w", email, ErrUserSuspended)
+	}
+
+	if user.FailedLogins > 0 {
+		user.FailedLogins = 0
+		data.Users[user.ID] = *user
+		if err := db.writeDB(data); err != nil {
+			return nil, fmt.Errorf("failed to reset failed logins: %s", err)
+		}
+	}
+
+	return user.toUser(), nil
 }
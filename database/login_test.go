@@ -0,0 +1,67 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice@example.com")
+
+	user, err := db.Login("alice@example.com", "password")
+	if err != nil || user == nil || user.ID != alice.ID {
+		t.Fatalf("got user %v (%v), want user %d", user, err, alice.ID)
+	}
+
+	if user, err := db.Login("alice@example.com", "wrong"); err == nil || user != nil {
+		t.Errorf("logged in with the wrong password")
+	}
+
+	if user, err := db.Login("nobody@example.com", "password"); err != nil || user != nil {
+		t.Errorf("got user %v (%v) for unknown email, want neither", user, err)
+	}
+}
+
+// Failed logins used to lock accounts, which let anyone lock anyone else out
+func TestFailedLoginsDontLockAccounts(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice@example.com")
+
+	for range 20 {
+		db.Login("alice@example.com", "wrong")
+	}
+
+	state, err := db.GetAccountState(alice.ID)
+	if err != nil {
+		t.Fatalf("failed to get account state: %s", err)
+	}
+	if state.FailedLogins != 20 || state.LastFailedLoginAt == nil {
+		t.Errorf("got %d failed logins, last at %v, want 20", state.FailedLogins, state.LastFailedLoginAt)
+	}
+
+	if user, err := db.Login("alice@example.com", "password"); err != nil || user == nil {
+		t.Errorf("couldn't log in after failed logins: %v", err)
+	}
+
+	if state, err := db.GetAccountState(alice.ID); err != nil || state.FailedLogins != 0 {
+		t.Errorf("failed logins weren't reset by logging in: %v", err)
+	}
+}
+
+func TestLoginOfSuspendedUser(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice@example.com")
+	if err := db.SuspendUser(alice.ID, 0, "Spam"); err != nil {
+		t.Fatalf("failed to suspend user: %s", err)
+	}
+
+	if _, err := db.Login("alice@example.com", "password"); !errors.Is(err, ErrUserSuspended) {
+		t.Errorf("got error %v, want %v", err, ErrUserSuspended)
+	}
+
+	// The suspension is only revealed to those who know the password
+	if _, err := db.Login("alice@example.com", "wrong"); errors.Is(err, ErrUserSuspended) {
+		t.Errorf("got %v for the wrong password", err)
+	}
+}
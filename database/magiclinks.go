@@ -0,0 +1,82 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+type MagicLink struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Token     string    `json:"token"`
+	Email     string    `json:"email"`
+}
+
+func (db *DB) SaveMagicLink(token, email string, expiresIn time.Duration) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	data.MagicLinks[token] = MagicLink{
+		Token:     token,
+		Email:     email,
+		ExpiresAt: time.Now().Add(expiresIn),
+	}
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to save magic link: %s", err)
+	}
+
+	return nil
+}
+
+// Returns nil if the magic link doesn't exist or has already been used
+func (db *DB) GetMagicLink(token string) (*MagicLink, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	link, exists := data.MagicLinks[token]
+	if !exists {
+		return nil, nil
+	}
+
+	return &link, nil
+}
+
+// Removes the magic link so that it can only be used once. Returns nil if the
+// link doesn't exist, has already been used, or has expired
+func (db *DB) ConsumeMagicLink(token string) (*MagicLink, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	link, exists := data.MagicLinks[token]
+	if !exists {
+		return nil, nil
+	}
+
+	delete(data.MagicLinks, token)
+	for t, l := range data.MagicLinks {
+		if l.ExpiresAt.Before(time.Now()) {
+			delete(data.MagicLinks, t)
+		}
+	}
+
+	if err := db.writeDB(data); err != nil {
+		return nil, fmt.Errorf("failed to consume magic link: %s", err)
+	}
+
+	if link.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+
+	return &link, nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrMediaNotFound = errors.New("media does not exist")
+	ErrMediaAttached = errors.New("media is already attached to a chirp")
+)
+
+// An uploaded image. The keys identify its blobs in the blob store
+type Media struct {
+	ID           int    `json:"id"`
+	OwnerID      int    `json:"owner_id"`
+	ContentType  string `json:"content_type"`
+	Size         int    `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Key          string `json:"key"`
+	ThumbnailKey string `json:"thumbnail_key"`
+	// Nil until the media is attached to a chirp
+	ChirpID   *int      `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) CreateMedia(media Media) (Media, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return Media{}, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	media.ID = nextID(data.Media)
+	media.CreatedAt = time.Now().UTC()
+	media.ChirpID = nil
+	data.Media[media.ID] = media
+
+	if err := db.writeDB(data); err != nil {
+		return Media{}, fmt.Errorf("failed to create media: %s", err)
+	}
+
+	return media, nil
+}
+
+// Returns nil if the media doesn't exist
+func (db *DB) GetMedia(id int) (*Media, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	media, exists := data.Media[id]
+	if !exists {
+		return nil, nil
+	}
+
+	return &media, nil
+}
+
+// Returns the chirp's attachments in the order they were attached
+func (db *DB) GetChirpMedia(chirp Chirp) ([]Media, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	attachments := make([]Media, 0, len(chirp.AttachmentIDs))
+	for _, id := range chirp.AttachmentIDs {
+		if media, exists := data.Media[id]; exists {
+			attachments = append(attachments, media)
+		}
+	}
+
+	return attachments, nil
+}
+
+// Attaches media to a new chirp. Only the author's own media that isn't
+// attached to another chirp can be attached
+func attachMedia(data *DBStructure, chirp *Chirp, mediaIDs []int) error {
+	for i, id := range mediaIDs {
+		media, exists := data.Media[id]
+		if !exists || media.OwnerID != chirp.AuthorID {
+			return fmt.Errorf("media %d: %w", id, ErrMediaNotFound)
+		}
+		for _, previousID := range mediaIDs[:i] {
+			if previousID == id {
+				return fmt.Errorf("media %d: %w", id, ErrMediaAttached)
+			}
+		}
+		if media.ChirpID != nil {
+			return fmt.Errorf("media %d: %w", id, ErrMediaAttached)
+		}
+	}
+
+	chirpID := chirp.ID
+	for _, id := range mediaIDs {
+		media := data.Media[id]
+		media.ChirpID = &chirpID
+		data.Media[id] = media
+	}
+	chirp.AttachmentIDs = mediaIDs
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mawkler/go-web-server/moderation"
+)
+
+// A word list rule added or deleted by an admin, on top of the built-in rules
+// and the word lists on disk
+type ModerationRule struct {
+	Word   string `json:"word"`
+	Action string `json:"action,omitempty"`
+	// Deleted rules are kept so that built-in and word list rules stay deleted
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Rules are keyed like in the moderation filter, so that different spellings of
+// a word replace each other
+func moderationRuleKey(word string) string {
+	return moderation.Normalize(word)
+}
+
+// Rules from before they were keyed by normalized word
+func migrateModerationRules(data *DBStructure) {
+	for key, rule := range data.ModerationRules {
+		if normalized := moderationRuleKey(rule.Word); key != normalized {
+			delete(data.ModerationRules, key)
+			data.ModerationRules[normalized] = rule
+		}
+	}
+}
+
+// A chirp that matched a moderation rule with the flag action
+type ChirpFlag struct {
+	ChirpID   int       `json:"chirp_id"`
+	Words     []string  `json:"words"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) GetModerationRules() ([]ModerationRule, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	rules := make([]ModerationRule, 0, len(data.ModerationRules))
+	for _, rule := range data.ModerationRules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Word < rules[j].Word })
+
+	return rules, nil
+}
+
+func (db *DB) SaveModerationRule(rule ModerationRule) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	data.ModerationRules[moderationRuleKey(rule.Word)] = rule
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to save moderation rule: %s", err)
+	}
+
+	return nil
+}
+
+// Stores a deleted rule for the word
+func (db *DB) DeleteModerationRule(word string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	data.ModerationRules[moderationRuleKey(word)] = ModerationRule{Word: word, Deleted: true}
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to delete moderation rule: %s", err)
+	}
+
+	return nil
+}
+
+func (db *DB) FlagChirp(chirpID int, words []string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	data.ChirpFlags[chirpID] = ChirpFlag{ChirpID: chirpID, Words: words, CreatedAt: time.Now().UTC()}
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to flag chirp %d: %s", chirpID, err)
+	}
+
+	return nil
+}
+
+func (db *DB) GetChirpFlags() ([]ChirpFlag, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	flags := make([]ChirpFlag, 0, len(data.ChirpFlags))
+	for _, flag := range data.ChirpFlags {
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].ChirpID < flags[j].ChirpID })
+
+	return flags, nil
+}
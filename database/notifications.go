@@ -0,0 +1,207 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+type NotificationType string
+
+const (
+	NotificationReply   NotificationType = "reply"
+	NotificationMention NotificationType = "mention"
+	NotificationFollow  NotificationType = "follow"
+	// A moderator acted on one of the user's chirps
+	NotificationModeration NotificationType = "moderation"
+)
+
+type Notification struct {
+	ID int `json:"id"`
+	// The user that gets notified
+	UserID int              `json:"user_id"`
+	Type   NotificationType `json:"type"`
+	// The user whose action caused the notification. Moderators are kept
+	// anonymous, so it's 0 for moderation notifications
+	ActorID int `json:"actor_id"`
+	// The reply, the chirp with the mention, or the moderated chirp
+	ChirpID *int `json:"chirp_id,omitempty"`
+	// What the moderator did, and their note to the author
+	ModerationAction ModerationAction `json:"moderation_action,omitempty"`
+	ModerationNote   string           `json:"moderation_note,omitempty"`
+	CreatedAt        time.Time        `json:"created_at"`
+	Read             bool             `json:"read"`
+}
+
+// Which types of notifications the user gets
+type NotificationPreferences struct {
+	Reply   bool `json:"reply"`
+	Mention bool `json:"mention"`
+	Follow  bool `json:"follow"`
+}
+
+var DefaultNotificationPreferences = NotificationPreferences{Reply: true, Mention: true, Follow: true}
+
+func (p NotificationPreferences) Enabled(notificationType NotificationType) bool {
+	switch notificationType {
+	case NotificationReply:
+		return p.Reply
+	case NotificationMention:
+		return p.Mention
+	case NotificationFollow:
+		return p.Follow
+	default:
+		return true
+	}
+}
+
+func notificationPreferences(data DBStructure, userID int) NotificationPreferences {
+	preferences, exists := data.NotificationPreferences[userID]
+	if !exists {
+		return DefaultNotificationPreferences
+	}
+	return preferences
+}
+
+// Saves the notification, unless the user has turned off its type or has
+// already been notified about the chirp, which happens when a reply also
+// mentions the replied to user. Moderation notifications are always saved.
+// Returns nil if it isn't saved
+func (db *DB) CreateNotification(notification Notification) (*Notification, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	if !notificationPreferences(data, notification.UserID).Enabled(notification.Type) {
+		return nil, nil
+	}
+
+	if notification.ChirpID != nil && notification.Type != NotificationModeration {
+		for _, id := range data.NotificationIndex[notification.UserID] {
+			chirpID := data.Notifications[id].ChirpID
+			if chirpID != nil && *chirpID == *notification.ChirpID {
+				return nil, nil
+			}
+		}
+	}
+
+	notification.ID = nextID(data.Notifications)
+	notification.CreatedAt = time.Now().UTC()
+	notification.Read = false
+	data.Notifications[notification.ID] = notification
+	data.NotificationIndex[notification.UserID] = append(data.NotificationIndex[notification.UserID], notification.ID)
+
+	if err := db.writeDB(data); err != nil {
+		return nil, fmt.Errorf("failed to save notification: %s", err)
+	}
+
+	return &notification, nil
+}
+
+// Returns the user's notifications, newest first, along with the number of
+// unread notifications
+func (db *DB) GetNotifications(userID int, unreadOnly bool) ([]Notification, int, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	notifications := []Notification{}
+	unread := 0
+	ids := data.NotificationIndex[userID]
+	for i := len(ids) - 1; i >= 0; i-- {
+		notification := data.Notifications[ids[i]]
+		if !notification.Read {
+			unread++
+		}
+		if !unreadOnly || !notification.Read {
+			notifications = append(notifications, notification)
+		}
+	}
+
+	return notifications, unread, nil
+}
+
+// Returns nil if the user has no notification with the ID
+func (db *DB) MarkNotificationRead(userID, id int) (*Notification, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	notification, exists := data.Notifications[id]
+	if !exists || notification.UserID != userID {
+		return nil, nil
+	}
+
+	if notification.Read {
+		return &notification, nil
+	}
+
+	notification.Read = true
+	data.Notifications[id] = notification
+
+	if err := db.writeDB(data); err != nil {
+		return nil, fmt.Errorf("failed to mark notification %d as read: %s", id, err)
+	}
+
+	return &notification, nil
+}
+
+func (db *DB) MarkAllNotificationsRead(userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	for _, id := range data.NotificationIndex[userID] {
+		notification := data.Notifications[id]
+		notification.Read = true
+		data.Notifications[id] = notification
+	}
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to mark notifications as read: %s", err)
+	}
+
+	return nil
+}
+
+func (db *DB) GetNotificationPreferences(userID int) (NotificationPreferences, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return NotificationPreferences{}, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	return notificationPreferences(data, userID), nil
+}
+
+// Applies update to the user's current preferences and saves the result
+func (db *DB) UpdateNotificationPreferences(userID int, update func(*NotificationPreferences)) (NotificationPreferences, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return NotificationPreferences{}, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	preferences := notificationPreferences(data, userID)
+	update(&preferences)
+	data.NotificationPreferences[userID] = preferences
+
+	if err := db.writeDB(data); err != nil {
+		return NotificationPreferences{}, fmt.Errorf("failed to save notification preferences: %s", err)
+	}
+
+	return preferences, nil
+}
@@ -13,6 +13,9 @@ type RefreshToken struct {
 }
 
 func (db *DB) SaveRefreshToken(refreshToken string, userID int, expiresIn time.Duration) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
 	data, err := db.loadDB()
 	if err != nil {
 		return fmt.Errorf("failed to load database: %s", err)
@@ -48,6 +51,9 @@ func (db *DB) GetRefreshToken(refreshToken string) (*RefreshToken, error) {
 }
 
 func (db *DB) DeleteRefreshToken(refreshToken string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
 	data, err := db.loadDB()
 	if err != nil {
 		return fmt.Errorf("failed to load database: %s", err)
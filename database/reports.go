@@ -0,0 +1,246 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrOwnChirp        = errors.New("users can't report their own chirps")
+	ErrAlreadyReported = errors.New("user has already reported the chirp")
+)
+
+type ReportReason string
+
+const (
+	ReportSpam           ReportReason = "spam"
+	ReportHarassment     ReportReason = "harassment"
+	ReportHateSpeech     ReportReason = "hate_speech"
+	ReportViolence       ReportReason = "violence"
+	ReportMisinformation ReportReason = "misinformation"
+	ReportOther          ReportReason = "other"
+)
+
+var ReportReasons = []ReportReason{
+	ReportSpam, ReportHarassment, ReportHateSpeech, ReportViolence, ReportMisinformation, ReportOther,
+}
+
+type ModerationAction string
+
+const (
+	// Resolves the reports without consequences, and unhides the chirp
+	ModerationDismiss ModerationAction = "dismiss"
+	ModerationHide    ModerationAction = "hide"
+	ModerationDelete  ModerationAction = "delete"
+	// Leaves the chirp as is, but warns its author
+	ModerationWarn ModerationAction = "warn"
+)
+
+var ModerationActions = []ModerationAction{ModerationDismiss, ModerationHide, ModerationDelete, ModerationWarn}
+
+type Report struct {
+	ID         int          `json:"id"`
+	ChirpID    int          `json:"chirp_id"`
+	ReporterID int          `json:"reporter_id"`
+	Reason     ReportReason `json:"reason"`
+	Details    string       `json:"details,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	// Set once a moderator has acted on the chirp
+	Resolution *ReportResolution `json:"resolution,omitempty"`
+}
+
+type ReportResolution struct {
+	Action      ModerationAction `json:"action"`
+	ModeratorID int              `json:"moderator_id"`
+	Note        string           `json:"note,omitempty"`
+	ResolvedAt  time.Time        `json:"resolved_at"`
+}
+
+// A chirp with unresolved reports, as listed in the moderation queue
+type ReportedChirp struct {
+	Chirp Chirp `json:"chirp"`
+	// Number of reports with each reason
+	Reasons         map[ReportReason]int `json:"reasons"`
+	Reports         []Report             `json:"reports"`
+	FirstReportedAt time.Time            `json:"first_reported_at"`
+}
+
+func openReports(data DBStructure, chirpID int) []Report {
+	reports := []Report{}
+	for _, report := range data.Reports {
+		if report.ChirpID == chirpID && report.Resolution == nil {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	return reports
+}
+
+// Reports the chirp. Once hideThreshold distinct users have open reports on
+// the chirp it's hidden until a moderator dismisses the reports, which is
+// disabled if hideThreshold isn't positive. Returns whether the report hid the
+// chirp.
+//
+// Returns ErrChirpNotFound if the chirp doesn't exist, ErrOwnChirp if the
+// reporter is its author, and ErrAlreadyReported if the reporter already has an
+// open report on it
+func (db *DB) ReportChirp(chirpID, reporterID int, reason ReportReason, details string, hideThreshold int) (*Report, bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	chirp, exists := data.Chirps[chirpID]
+	if !exists || chirp.Deleted {
+		return nil, false, ErrChirpNotFound
+	}
+	if chirp.AuthorID == reporterID {
+		return nil, false, ErrOwnChirp
+	}
+
+	reports := openReports(data, chirpID)
+	for _, report := range reports {
+		if report.ReporterID == reporterID {
+			return nil, false, ErrAlreadyReported
+		}
+	}
+
+	data.LastReportID++
+	report := Report{
+		ID:         data.LastReportID,
+		ChirpID:    chirpID,
+		ReporterID: reporterID,
+		Reason:     reason,
+		Details:    details,
+		CreatedAt:  time.Now().UTC(),
+	}
+	data.Reports[report.ID] = report
+
+	// Users can only have one open report per chirp, so every report is from a
+	// distinct user
+	hidden := false
+	if hideThreshold > 0 && !chirp.Hidden && len(reports)+1 >= hideThreshold {
+		chirp.Hidden = true
+		data.Chirps[chirpID] = chirp
+		hidden = true
+	}
+
+	if err := db.writeDB(data); err != nil {
+		return nil, false, fmt.Errorf("failed to report chirp %d: %s", chirpID, err)
+	}
+
+	return &report, hidden, nil
+}
+
+// Returns the chirps with unresolved reports, sorted by ID
+func (db *DB) GetReportQueue() ([]ReportedChirp, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	queue := map[int]*ReportedChirp{}
+	for _, report := range data.Reports {
+		if report.Resolution != nil {
+			continue
+		}
+
+		reported, exists := queue[report.ChirpID]
+		if !exists {
+			reported = &ReportedChirp{
+				Chirp:           data.Chirps[report.ChirpID],
+				Reasons:         map[ReportReason]int{},
+				Reports:         []Report{},
+				FirstReportedAt: report.CreatedAt,
+			}
+			queue[report.ChirpID] = reported
+		}
+
+		reported.Reasons[report.Reason]++
+		reported.Reports = append(reported.Reports, report)
+		if report.CreatedAt.Before(reported.FirstReportedAt) {
+			reported.FirstReportedAt = report.CreatedAt
+		}
+	}
+
+	reportedChirps := make([]ReportedChirp, 0, len(queue))
+	for _, reported := range queue {
+		sort.Slice(reported.Reports, func(i, j int) bool { return reported.Reports[i].ID < reported.Reports[j].ID })
+		reportedChirps = append(reportedChirps, *reported)
+	}
+	sort.Slice(reportedChirps, func(i, j int) bool { return reportedChirps[i].Chirp.ID < reportedChirps[j].Chirp.ID })
+
+	return reportedChirps, nil
+}
+
+// Applies the moderator's action to the chirp, and resolves its open reports.
+// Returns the chirp as it was before the action, the number of resolved
+// reports, and the chirp's attachments if it was deleted so that the caller
+// can delete their blobs. Returns ErrChirpNotFound if the chirp doesn't exist
+func (db *DB) ModerateChirp(chirpID, moderatorID int, action ModerationAction, note string) (*Chirp, int, []Media, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	chirp, exists := data.Chirps[chirpID]
+	if !exists || chirp.Deleted {
+		return nil, 0, nil, ErrChirpNotFound
+	}
+
+	resolution := ReportResolution{Action: action, ModeratorID: moderatorID, Note: note, ResolvedAt: time.Now().UTC()}
+	reports := openReports(data, chirpID)
+	for _, report := range reports {
+		report.Resolution = &resolution
+		data.Reports[report.ID] = report
+	}
+
+	attachments := []Media{}
+	switch action {
+	case ModerationDismiss, ModerationHide:
+		updated := chirp
+		updated.Hidden = action == ModerationHide
+		data.Chirps[chirpID] = updated
+	case ModerationDelete:
+		for _, mediaID := range chirp.AttachmentIDs {
+			if m, exists := data.Media[mediaID]; exists {
+				attachments = append(attachments, m)
+			}
+		}
+		deleteChirp(&data, chirpID)
+	}
+
+	if err := db.writeDB(data); err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to moderate chirp %d: %s", chirpID, err)
+	}
+
+	return &chirp, len(reports), attachments, nil
+}
+
+// Returns the reports that the user has made, sorted by ID
+func userReports(data DBStructure, userID int) []Report {
+	reports := []Report{}
+	for _, report := range data.Reports {
+		if report.ReporterID == userID {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	return reports
+}
+
+func deleteOpenReports(data *DBStructure, chirpID int) {
+	for id, report := range data.Reports {
+		if report.ChirpID == chirpID && report.Resolution == nil {
+			delete(data.Reports, id)
+		}
+	}
+}
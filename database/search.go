@@ -0,0 +1,232 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mawkler/go-web-server/search"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// An inverted index over chirp bodies
+type SearchIndex struct {
+	// Term -> chirp ID -> positions of the term in the chirp
+	Postings map[string]map[int][]int `json:"postings"`
+	// Chirp ID -> number of terms in the chirp
+	DocLengths map[int]int `json:"doc_lengths"`
+}
+
+type SearchResult struct {
+	Chirp
+	Score float64 `json:"score"`
+}
+
+func newSearchIndex() SearchIndex {
+	return SearchIndex{Postings: map[string]map[int][]int{}, DocLengths: map[int]int{}}
+}
+
+func (index *SearchIndex) add(chirp Chirp) {
+	tokens := search.Analyze(chirp.Body)
+	for _, token := range tokens {
+		if index.Postings[token.Term] == nil {
+			index.Postings[token.Term] = map[int][]int{}
+		}
+		index.Postings[token.Term][chirp.ID] = append(index.Postings[token.Term][chirp.ID], token.Position)
+	}
+	index.DocLengths[chirp.ID] = len(tokens)
+}
+
+func (index *SearchIndex) remove(chirp Chirp) {
+	for _, token := range search.Analyze(chirp.Body) {
+		delete(index.Postings[token.Term], chirp.ID)
+		if len(index.Postings[token.Term]) == 0 {
+			delete(index.Postings, token.Term)
+		}
+	}
+	delete(index.DocLengths, chirp.ID)
+}
+
+func (index *SearchIndex) averageDocLength() float64 {
+	if len(index.DocLengths) == 0 {
+		return 0
+	}
+
+	total := 0
+	for _, length := range index.DocLengths {
+		total += length
+	}
+	return float64(total) / float64(len(index.DocLengths))
+}
+
+func (index *SearchIndex) idf(term string) float64 {
+	n := float64(len(index.Postings[term]))
+	docs := float64(len(index.DocLengths))
+	return math.Log(1 + (docs-n+0.5)/(n+0.5))
+}
+
+func (index *SearchIndex) bm25(term string, termFrequency int, docID int, avgDocLength float64) float64 {
+	tf := float64(termFrequency)
+	docLength := float64(index.DocLengths[docID])
+	return index.idf(term) * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*docLength/avgDocLength))
+}
+
+// Counts the occurrences of the terms as consecutive words in the chirp
+func (index *SearchIndex) phraseFrequency(phrase []string, docID int) int {
+	count := 0
+	for _, start := range index.Postings[phrase[0]][docID] {
+		matches := true
+		for offset, term := range phrase[1:] {
+			positions := index.Postings[term][docID]
+			if !containsInt(positions, start+offset+1) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			count++
+		}
+	}
+	return count
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func buildSearchIndex(data *DBStructure) {
+	data.SearchIndex = newSearchIndex()
+	for _, chirp := range data.Chirps {
+		if !chirp.Deleted {
+			data.SearchIndex.add(chirp)
+		}
+	}
+}
+
+// Rebuilds the search index from scratch
+func (db *DB) RebuildSearchIndex() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("failed to load database: %s", err)
+	}
+
+	buildSearchIndex(&data)
+
+	if err := db.writeDB(data); err != nil {
+		return fmt.Errorf("failed to write search index: %s", err)
+	}
+
+	return nil
+}
+
+// Resolves the authors of `from:` filters, which can be user IDs or handles
+// with or without their `@`
+func resolveAuthors(data DBStructure, authors []string) map[int]bool {
+	ids := map[int]bool{}
+	for _, author := range authors {
+		if id, err := strconv.Atoi(author); err == nil {
+			ids[id] = true
+			continue
+		}
+		if id, exists := data.HandleIndex[strings.ToLower(strings.TrimPrefix(author, "@"))]; exists {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// Searches chirps, ranked by BM25. Phrases, authors and hashtags in the query
+// are required, words are optional but affect the ranking. Queries with only
+// filters return the newest matching chirps first
+func (db *DB) SearchChirps(query search.Query, limit int) ([]SearchResult, int, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	index := data.SearchIndex
+	avgDocLength := index.averageDocLength()
+	authors := resolveAuthors(data, query.From)
+
+	scores := map[int]float64{}
+	if len(query.Terms) > 0 {
+		for _, term := range query.Terms {
+			for docID, positions := range index.Postings[term] {
+				scores[docID] += index.bm25(term, len(positions), docID, avgDocLength)
+			}
+		}
+	} else {
+		for docID := range index.DocLengths {
+			scores[docID] = 0
+		}
+	}
+
+	results := []SearchResult{}
+	for docID, score := range scores {
+		chirp, exists := data.Chirps[docID]
+		if !exists || chirp.Hidden {
+			continue
+		}
+
+		if len(query.From) > 0 && !authors[chirp.AuthorID] {
+			continue
+		}
+
+		matchesHashtags := true
+		for _, hashtag := range query.Hashtags {
+			if _, exists := index.Postings[hashtag][docID]; !exists {
+				matchesHashtags = false
+				break
+			}
+		}
+		if !matchesHashtags {
+			continue
+		}
+
+		matchesPhrases := true
+		for _, phrase := range query.Phrases {
+			frequency := index.phraseFrequency(phrase, docID)
+			if frequency == 0 {
+				matchesPhrases = false
+				break
+			}
+			for _, term := range phrase {
+				score += index.bm25(term, frequency, docID, avgDocLength)
+			}
+		}
+		if !matchesPhrases {
+			continue
+		}
+
+		results = append(results, SearchResult{Chirp: chirp, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID > results[j].ID
+	})
+
+	total := len(results)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, total, nil
+}
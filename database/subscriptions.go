@@ -0,0 +1,265 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var ErrNoSubscription = errors.New("user has no active subscription")
+
+const (
+	PlanChirpyRed = "chirpy_red"
+	// How long paid periods last when the payment provider doesn't say
+	DefaultSubscriptionPeriod = 30 * 24 * time.Hour
+)
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionActive SubscriptionStatus = "active"
+	// Canceled subscriptions aren't renewed, but last until the end of the
+	// period that has been paid for
+	SubscriptionCanceled SubscriptionStatus = "canceled"
+	SubscriptionExpired  SubscriptionStatus = "expired"
+)
+
+type Subscription struct {
+	Plan   string             `json:"plan"`
+	Status SubscriptionStatus `json:"status"`
+	// Nil for subscriptions that don't expire, such as ones granted by admins
+	PeriodEnd *time.Time `json:"period_end"`
+	StartedAt time.Time  `json:"started_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Whether the subscription gives access to its plan at the time
+func (s *Subscription) Active(now time.Time) bool {
+	if s == nil || s.Status == SubscriptionExpired {
+		return false
+	}
+	return s.PeriodEnd == nil || now.Before(*s.PeriodEnd)
+}
+
+type SubscriptionEventType string
+
+const (
+	SubscriptionEventUpgraded   SubscriptionEventType = "upgraded"
+	SubscriptionEventRenewed    SubscriptionEventType = "renewed"
+	SubscriptionEventCanceled   SubscriptionEventType = "canceled"
+	SubscriptionEventDowngraded SubscriptionEventType = "downgraded"
+	// The period ended without being renewed
+	SubscriptionEventExpired SubscriptionEventType = "expired"
+	// Granted or revoked manually by an admin
+	SubscriptionEventGranted SubscriptionEventType = "granted"
+	SubscriptionEventRevoked SubscriptionEventType = "revoked"
+)
+
+// A change to a user's subscription, along with the subscription's state after
+// the change
+type SubscriptionEvent struct {
+	Type      SubscriptionEventType `json:"type"`
+	Plan      string                `json:"plan"`
+	Status    SubscriptionStatus    `json:"status"`
+	PeriodEnd *time.Time            `json:"period_end"`
+	// The admin that granted or revoked the subscription
+	ActorID   *int      `json:"actor_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// The change to apply in UpdateSubscription
+type SubscriptionChange struct {
+	Type SubscriptionEventType
+	// Defaults to PlanChirpyRed, or the current plan
+	Plan string
+	// Defaults to DefaultSubscriptionPeriod after now for upgrades, or after the
+	// end of the current period for renewals
+	PeriodEnd *time.Time
+	ActorID   *int
+}
+
+// Users that got Chirpy Red before subscriptions were introduced keep it
+// without an end date
+func migrateChirpyRed(data *DBStructure) {
+	for id, user := range data.Users {
+		if user.IsChirpyRed && user.Subscription == nil {
+			user.Subscription = &Subscription{Plan: PlanChirpyRed, Status: SubscriptionActive}
+			data.Users[id] = user
+		}
+	}
+}
+
+// Applies the change to the user's subscription and records it in their
+// subscription history. Returns ErrUserNotFound if the user doesn't exist, and
+// ErrNoSubscription when canceling a subscription that isn't active.
+// Retried renewals are ignored
+func (db *DB) UpdateSubscription(userID int, change SubscriptionChange) (*Subscription, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	user, exists := data.Users[userID]
+	if !exists {
+		return nil, fmt.Errorf("user %d: %w", userID, ErrUserNotFound)
+	}
+
+	now := time.Now().UTC()
+	subscription := Subscription{Plan: PlanChirpyRed, Status: SubscriptionExpired, StartedAt: now}
+	wasActive := user.Subscription.Active(now)
+	if user.Subscription != nil {
+		subscription = *user.Subscription
+	}
+	if change.Plan != "" {
+		subscription.Plan = change.Plan
+	}
+
+	periodEnd := func(from time.Time) *time.Time {
+		if change.PeriodEnd != nil {
+			end := change.PeriodEnd.UTC()
+			return &end
+		}
+		end := from.Add(DefaultSubscriptionPeriod)
+		return &end
+	}
+
+	// Payment providers retry webhooks, so a renewal to the current period end,
+	// or without an end while a full period is still left, has already been
+	// applied
+	if change.Type == SubscriptionEventRenewed && subscription.Status == SubscriptionActive && wasActive && subscription.PeriodEnd != nil {
+		alreadyRenewed := subscription.PeriodEnd.Sub(now) >= DefaultSubscriptionPeriod
+		if change.PeriodEnd != nil {
+			alreadyRenewed = subscription.PeriodEnd.Equal(*change.PeriodEnd)
+		}
+		if alreadyRenewed {
+			return &subscription, nil
+		}
+	}
+
+	switch change.Type {
+	case SubscriptionEventUpgraded, SubscriptionEventRenewed:
+		from := now
+		if change.Type == SubscriptionEventRenewed && wasActive && subscription.PeriodEnd != nil {
+			from = *subscription.PeriodEnd
+		}
+		if !wasActive {
+			subscription.StartedAt = now
+		}
+		end := periodEnd(from)
+		// Upgrading a subscription that is active without an end, such as a
+		// granted one, or for longer than the new period mustn't shorten it
+		keepPeriodEnd := change.Type == SubscriptionEventUpgraded && wasActive &&
+			(subscription.PeriodEnd == nil || subscription.PeriodEnd.After(*end))
+		if !keepPeriodEnd {
+			subscription.PeriodEnd = end
+		}
+		subscription.Status = SubscriptionActive
+	case SubscriptionEventCanceled:
+		if !wasActive {
+			return nil, fmt.Errorf("user %d: %w", userID, ErrNoSubscription)
+		}
+		subscription.Status = SubscriptionCanceled
+		// Canceled subscriptions without an end would never expire
+		if change.PeriodEnd != nil || subscription.PeriodEnd == nil {
+			subscription.PeriodEnd = periodEnd(now)
+		}
+	case SubscriptionEventDowngraded, SubscriptionEventRevoked:
+		subscription.Status = SubscriptionExpired
+		subscription.PeriodEnd = &now
+	case SubscriptionEventGranted:
+		if !wasActive {
+			subscription.StartedAt = now
+		}
+		subscription.Status = SubscriptionActive
+		subscription.PeriodEnd = nil
+	default:
+		return nil, fmt.Errorf("unknown subscription event %q", change.Type)
+	}
+	subscription.UpdatedAt = now
+
+	user.Subscription = &subscription
+	user.IsChirpyRed = subscription.Active(now)
+	data.Users[userID] = user
+	data.SubscriptionHistory[userID] = append(data.SubscriptionHistory[userID], SubscriptionEvent{
+		Type:      change.Type,
+		Plan:      subscription.Plan,
+		Status:    subscription.Status,
+		PeriodEnd: subscription.PeriodEnd,
+		ActorID:   change.ActorID,
+		CreatedAt: now,
+	})
+
+	if err := db.writeDB(data); err != nil {
+		return nil, fmt.Errorf("failed to update subscription of user %d: %s", userID, err)
+	}
+
+	return &subscription, nil
+}
+
+// Expires the subscriptions whose period has ended. Returns the IDs of the
+// users whose subscriptions expired
+func (db *DB) ExpireSubscriptions(now time.Time) ([]int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	ids := []int{}
+	for id, user := range data.Users {
+		subscription := user.Subscription
+		if subscription == nil || subscription.Status == SubscriptionExpired || subscription.Active(now) {
+			continue
+		}
+
+		subscription.Status = SubscriptionExpired
+		subscription.UpdatedAt = now.UTC()
+		user.IsChirpyRed = false
+		data.Users[id] = user
+		data.SubscriptionHistory[id] = append(data.SubscriptionHistory[id], SubscriptionEvent{
+			Type:      SubscriptionEventExpired,
+			Plan:      subscription.Plan,
+			Status:    subscription.Status,
+			PeriodEnd: subscription.PeriodEnd,
+			CreatedAt: now.UTC(),
+		})
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	sort.Ints(ids)
+
+	if err := db.writeDB(data); err != nil {
+		return nil, fmt.Errorf("failed to expire subscriptions: %s", err)
+	}
+
+	return ids, nil
+}
+
+// Returns the user's subscription, which is nil if they've never had one, and
+// its history, oldest first. Returns ErrUserNotFound if the user doesn't exist
+func (db *DB) GetSubscription(userID int) (*Subscription, []SubscriptionEvent, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	user, exists := data.Users[userID]
+	if !exists {
+		return nil, nil, fmt.Errorf("user %d: %w", userID, ErrUserNotFound)
+	}
+
+	history := data.SubscriptionHistory[userID]
+	if history == nil {
+		history = []SubscriptionEvent{}
+	}
+
+	return user.Subscription, history, nil
+}
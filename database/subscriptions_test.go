@@ -0,0 +1,193 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func updateTestSubscription(t *testing.T, db *DB, userID int, change SubscriptionChange) *Subscription {
+	t.Helper()
+	subscription, err := db.UpdateSubscription(userID, change)
+	if err != nil {
+		t.Fatalf("failed to apply %s: %s", change.Type, err)
+	}
+	return subscription
+}
+
+func subscriptionHistory(t *testing.T, db *DB, userID int) []SubscriptionEvent {
+	t.Helper()
+	_, history, err := db.GetSubscription(userID)
+	if err != nil {
+		t.Fatalf("failed to get subscription: %s", err)
+	}
+	return history
+}
+
+func TestUpdateSubscription(t *testing.T) {
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice@example.com")
+
+	subscription := updateTestSubscription(t, db, user.ID, SubscriptionChange{Type: SubscriptionEventUpgraded})
+	if subscription.Status != SubscriptionActive || subscription.Plan != PlanChirpyRed {
+		t.Fatalf("got %s %s subscription after upgrade, want active %s", subscription.Status, subscription.Plan, PlanChirpyRed)
+	}
+	if subscription.PeriodEnd == nil || time.Until(*subscription.PeriodEnd) < DefaultSubscriptionPeriod-time.Minute {
+		t.Fatalf("got period end %v after upgrade, want %s from now", subscription.PeriodEnd, DefaultSubscriptionPeriod)
+	}
+	upgradedPeriodEnd := *subscription.PeriodEnd
+
+	got, err := db.GetUser(user.ID)
+	if err != nil || !got.IsChirpyRed {
+		t.Fatalf("user isn't Chirpy Red after upgrade: %v", err)
+	}
+
+	renewedPeriodEnd := upgradedPeriodEnd.Add(DefaultSubscriptionPeriod)
+	t.Run("renewals without period end extend it once", func(t *testing.T) {
+		for range 2 {
+			subscription := updateTestSubscription(t, db, user.ID, SubscriptionChange{Type: SubscriptionEventRenewed})
+			if !subscription.PeriodEnd.Equal(renewedPeriodEnd) {
+				t.Errorf("got period end %s, want %s", subscription.PeriodEnd, renewedPeriodEnd)
+			}
+		}
+		if history := subscriptionHistory(t, db, user.ID); len(history) != 2 {
+			t.Errorf("got %d history events, want 2", len(history))
+		}
+	})
+
+	periodEnd := renewedPeriodEnd.Add(DefaultSubscriptionPeriod)
+	t.Run("renewals with period end set it once", func(t *testing.T) {
+		renewal := SubscriptionChange{Type: SubscriptionEventRenewed, PeriodEnd: &periodEnd}
+		for range 2 {
+			subscription := updateTestSubscription(t, db, user.ID, renewal)
+			if !subscription.PeriodEnd.Equal(periodEnd) {
+				t.Errorf("got period end %s, want %s", subscription.PeriodEnd, periodEnd)
+			}
+		}
+		if history := subscriptionHistory(t, db, user.ID); len(history) != 3 {
+			t.Errorf("got %d history events, want 3", len(history))
+		}
+	})
+
+	t.Run("canceled subscriptions last until the period ends", func(t *testing.T) {
+		subscription := updateTestSubscription(t, db, user.ID, SubscriptionChange{Type: SubscriptionEventCanceled})
+		if subscription.Status != SubscriptionCanceled {
+			t.Errorf("got status %s, want %s", subscription.Status, SubscriptionCanceled)
+		}
+		if !subscription.Active(time.Now()) || subscription.Active(periodEnd) {
+			t.Errorf("canceled subscription should be active until %s", periodEnd)
+		}
+	})
+
+	t.Run("downgrades end the subscription", func(t *testing.T) {
+		subscription := updateTestSubscription(t, db, user.ID, SubscriptionChange{Type: SubscriptionEventDowngraded})
+		if subscription.Status != SubscriptionExpired || subscription.Active(time.Now()) {
+			t.Errorf("got active %s subscription after downgrade, want expired", subscription.Status)
+		}
+
+		got, err := db.GetUser(user.ID)
+		if err != nil || got.IsChirpyRed {
+			t.Errorf("user is still Chirpy Red after downgrade: %v", err)
+		}
+	})
+
+	t.Run("canceling inactive subscriptions fails", func(t *testing.T) {
+		_, err := db.UpdateSubscription(user.ID, SubscriptionChange{Type: SubscriptionEventCanceled})
+		if !errors.Is(err, ErrNoSubscription) {
+			t.Errorf("got error %v, want %v", err, ErrNoSubscription)
+		}
+	})
+
+	t.Run("granted subscriptions don't expire", func(t *testing.T) {
+		adminID := 1
+		change := SubscriptionChange{Type: SubscriptionEventGranted, ActorID: &adminID}
+		subscription := updateTestSubscription(t, db, user.ID, change)
+		if subscription.Status != SubscriptionActive || subscription.PeriodEnd != nil {
+			t.Errorf("got %s subscription ending at %v, want active without end", subscription.Status, subscription.PeriodEnd)
+		}
+
+		history := subscriptionHistory(t, db, user.ID)
+		if last := history[len(history)-1]; last.ActorID == nil || *last.ActorID != adminID {
+			t.Errorf("got actor %v, want admin %d", last.ActorID, adminID)
+		}
+	})
+}
+
+func TestUpdateGrantedSubscription(t *testing.T) {
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice@example.com")
+
+	adminID := 1
+	updateTestSubscription(t, db, user.ID, SubscriptionChange{Type: SubscriptionEventGranted, ActorID: &adminID})
+
+	subscription := updateTestSubscription(t, db, user.ID, SubscriptionChange{Type: SubscriptionEventUpgraded})
+	if subscription.Status != SubscriptionActive || subscription.PeriodEnd != nil {
+		t.Fatalf("got %s subscription ending at %v after upgrade, want active without end", subscription.Status, subscription.PeriodEnd)
+	}
+
+	subscription = updateTestSubscription(t, db, user.ID, SubscriptionChange{Type: SubscriptionEventCanceled})
+	if subscription.Status != SubscriptionCanceled || subscription.PeriodEnd == nil {
+		t.Fatalf("got %s subscription ending at %v after cancellation, want canceled with end", subscription.Status, subscription.PeriodEnd)
+	}
+
+	ids, err := db.ExpireSubscriptions(subscription.PeriodEnd.Add(time.Second))
+	if err != nil || len(ids) != 1 || ids[0] != user.ID {
+		t.Errorf("got expired users %v (%v), want [%d]", ids, err, user.ID)
+	}
+}
+
+func TestUpgradeKeepsLaterPeriodEnd(t *testing.T) {
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice@example.com")
+
+	periodEnd := time.Now().Add(3 * DefaultSubscriptionPeriod).UTC()
+	updateTestSubscription(t, db, user.ID, SubscriptionChange{Type: SubscriptionEventUpgraded, PeriodEnd: &periodEnd})
+
+	subscription := updateTestSubscription(t, db, user.ID, SubscriptionChange{Type: SubscriptionEventUpgraded, Plan: PlanChirpyRed})
+	if subscription.PeriodEnd == nil || !subscription.PeriodEnd.Equal(periodEnd) {
+		t.Errorf("got period end %v after upgrade, want %s", subscription.PeriodEnd, periodEnd)
+	}
+}
+
+func TestUpdateSubscriptionOfUnknownUser(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.UpdateSubscription(1, SubscriptionChange{Type: SubscriptionEventUpgraded})
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestExpireSubscriptions(t *testing.T) {
+	db := newTestDB(t)
+	expired := createTestUser(t, db, "alice@example.com")
+	active := createTestUser(t, db, "bob@example.com")
+
+	periodEnd := time.Now().Add(time.Hour)
+	updateTestSubscription(t, db, expired.ID, SubscriptionChange{Type: SubscriptionEventUpgraded, PeriodEnd: &periodEnd})
+	updateTestSubscription(t, db, active.ID, SubscriptionChange{Type: SubscriptionEventUpgraded})
+
+	ids, err := db.ExpireSubscriptions(periodEnd)
+	if err != nil {
+		t.Fatalf("failed to expire subscriptions: %s", err)
+	}
+	if len(ids) != 1 || ids[0] != expired.ID {
+		t.Fatalf("got expired users %v, want [%d]", ids, expired.ID)
+	}
+
+	subscription, history, err := db.GetSubscription(expired.ID)
+	if err != nil {
+		t.Fatalf("failed to get subscription: %s", err)
+	}
+	if subscription.Status != SubscriptionExpired {
+		t.Errorf("got status %s, want %s", subscription.Status, SubscriptionExpired)
+	}
+	if last := history[len(history)-1]; last.Type != SubscriptionEventExpired {
+		t.Errorf("got last event %s, want %s", last.Type, SubscriptionEventExpired)
+	}
+
+	ids, err = db.ExpireSubscriptions(periodEnd)
+	if err != nil || len(ids) != 0 {
+		t.Errorf("got expired users %v (%v) when expiring again, want none", ids, err)
+	}
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/mawkler/go-web-server/entities"
+)
+
+// A chirp in a thread, along with its replies
+type ThreadNode struct {
+	Chirp
+	Replies []ThreadNode `json:"replies"`
+}
+
+type Thread struct {
+	// The chain of chirps that the chirp replies to, starting with the root of
+	// the conversation
+	Ancestors []Chirp
+	Chirp     Chirp
+	// The direct replies to the chirp, oldest first
+	Replies []ThreadNode
+}
+
+func buildReplyIndex(chirps map[int]Chirp) map[int][]int {
+	index := map[int][]int{}
+	for id, chirp := range chirps {
+		if chirp.InReplyToID != nil {
+			index[*chirp.InReplyToID] = append(index[*chirp.InReplyToID], id)
+		}
+	}
+	for _, replyIDs := range index {
+		sort.Ints(replyIDs)
+	}
+	return index
+}
+
+// Removes the chirp entirely. Placeholder ancestors that no longer have any
+// replies are removed as well
+func purgeChirp(data *DBStructure, id int) {
+	chirp, exists := data.Chirps[id]
+	if !exists {
+		return
+	}
+
+	delete(data.Chirps, id)
+	delete(data.Replies, id)
+
+	if chirp.InReplyToID == nil {
+		return
+	}
+
+	parentID := *chirp.InReplyToID
+	data.Replies[parentID] = slices.DeleteFunc(data.Replies[parentID], func(replyID int) bool {
+		return replyID == id
+	})
+	if len(data.Replies[parentID]) == 0 {
+		delete(data.Replies, parentID)
+	}
+
+	parent, exists := data.Chirps[parentID]
+	if !exists {
+		return
+	}
+
+	parent.ReplyCount--
+	data.Chirps[parentID] = parent
+
+	if parent.Deleted && parent.ReplyCount == 0 {
+		purgeChirp(data, parentID)
+	}
+}
+
+// Hidden chirps, and chirps by authors that the viewer shouldn't see, are shown
+// as placeholders in threads, like deleted ones
+func threadChirp(chirp Chirp, hiddenAuthorIDs map[int]bool) Chirp {
+	if !chirp.Hidden && !hiddenAuthorIDs[chirp.AuthorID] {
+		return chirp
+	}
+
+	return Chirp{
+		ID:             chirp.ID,
+		CreatedAt:      chirp.CreatedAt,
+		UpdatedAt:      chirp.UpdatedAt,
+		InReplyToID:    chirp.InReplyToID,
+		ConversationID: chirp.ConversationID,
+		ReplyCount:     chirp.ReplyCount,
+		Entities:       []entities.Entity{},
+		Hidden:         true,
+	}
+}
+
+// Returns the replies to the chirp, with their own replies nested up to depth
+// levels down
+func replyTree(data DBStructure, id int, depth int, hiddenAuthorIDs map[int]bool) []ThreadNode {
+	nodes := []ThreadNode{}
+	if depth <= 0 {
+		return nodes
+	}
+
+	for _, replyID := range data.Replies[id] {
+		if reply, exists := data.Chirps[replyID]; exists {
+			nodes = append(nodes, ThreadNode{
+				Chirp:   threadChirp(reply, hiddenAuthorIDs),
+				Replies: replyTree(data, replyID, depth-1, hiddenAuthorIDs),
+			})
+		}
+	}
+
+	return nodes
+}
+
+// Returns the thread around the chirp, with replies nested up to depth levels
+// down. Returns nil if the chirp doesn't exist. Deleted and hidden chirps that
+// are still part of a thread are returned as placeholders, and so are the
+// ancestors and replies written by hiddenAuthorIDs
+func (db *DB) GetThread(id int, depth int, hiddenAuthorIDs map[int]bool) (*Thread, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	chirp, exists := data.Chirps[id]
+	if !exists {
+		return nil, nil
+	}
+
+	ancestors := []Chirp{}
+	for parentID := chirp.InReplyToID; parentID != nil; {
+		parent, exists := data.Chirps[*parentID]
+		if !exists {
+			break
+		}
+		ancestors = append(ancestors, threadChirp(parent, hiddenAuthorIDs))
+		parentID = parent.InReplyToID
+	}
+	slices.Reverse(ancestors)
+
+	return &Thread{
+		Ancestors: ancestors,
+		Chirp:     threadChirp(chirp, nil),
+		Replies:   replyTree(data, id, depth, hiddenAuthorIDs),
+	}, nil
+}
@@ -3,23 +3,128 @@ package database
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrHandleTaken = errors.New("handle is already taken")
+	ErrEmailTaken  = errors.New("email is already taken")
+)
+
 type User struct {
-	Email       string `json:"email"`
+	// Private, so it's left out when the user is shown to others
+	Email       string `json:"email,omitempty"`
 	ID          int    `json:"id"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	// Unique, but compared case-insensitively
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+// The fields to change in UpdateUser. Nil fields are left unchanged
+type UserUpdate struct {
+	Email       *string
+	Password    *string
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+	AvatarURL   *string
+}
+
+const (
+	minHandleLength = 3
+	maxHandleLength = 15
+)
+
+var handlePattern = regexp.MustCompile(fmt.Sprintf(`^[A-Za-z0-9_]{%d,%d}$`, minHandleLength, maxHandleLength))
+
+// Handles consist of 3 to 15 ASCII letters, digits and underscores
+func ValidHandle(handle string) bool {
+	return handlePattern.MatchString(handle)
+}
+
+// Derives an unused handle from the local part of the email address, with a
+// number appended if it's already taken
+func generateHandle(data DBStructure, email string) string {
+	localPart, _, _ := strings.Cut(email, "@")
+	base := strings.Map(func(r rune) rune {
+		if r < 128 && (r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return -1
+	}, localPart)
+	if len(base) < minHandleLength {
+		base = "user"
+	}
+	base = base[:min(len(base), maxHandleLength)]
+
+	handle := base
+	for n := 2; ; n++ {
+		if _, taken := data.HandleIndex[strings.ToLower(handle)]; !taken && ValidHandle(handle) {
+			return handle
+		}
+		suffix := strconv.Itoa(n)
+		handle = base[:min(len(base), maxHandleLength-len(suffix))] + suffix
+	}
 }
 
+// Users from before handles were introduced get one generated from their email
+func buildHandleIndex(data *DBStructure) {
+	data.HandleIndex = map[string]int{}
+
+	ids := make([]int, 0, len(data.Users))
+	for id, user := range data.Users {
+		if user.Handle != "" {
+			data.HandleIndex[strings.ToLower(user.Handle)] = id
+		} else {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Ints(ids)
+	for _, id := range ids {
+		user := data.Users[id]
+		user.Handle = generateHandle(*data, user.Email)
+		data.Users[id] = user
+		data.HandleIndex[strings.ToLower(user.Handle)] = id
+	}
+}
+
+const (
+	RoleUser = "user"
+	// Can act on reported chirps
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Users that signed up through a magic link have no password
 type FullUser struct {
-	Password string `json:"password"`
+	Password string `json:"password,omitempty"`
+	Role     string `json:"role,omitempty"`
+	// When the user will be deleted, if they've asked to be
+	DeleteAt   *time.Time  `json:"delete_at,omitempty"`
+	Suspension *Suspension `json:"suspension,omitempty"`
+	// Failed logins in a row, which are only shown to admins and never lock
+	// the account
+	FailedLogins      int        `json:"failed_logins,omitempty"`
+	LastFailedLoginAt *time.Time `json:"last_failed_login_at,omitempty"`
+	// Nil for users that have never subscribed. IsChirpyRed is kept in sync
+	// with it
+	Subscription *Subscription `json:"subscription,omitempty"`
 	User
 }
 
 func (u *FullUser) toUser() *User {
-	return &User{Email: u.Email, ID: u.ID, IsChirpyRed: u.IsChirpyRed}
+	user := u.User
+	return &user
 }
 
 func hashPassword(password string) (string, error) {
@@ -31,65 +136,154 @@ func hashPassword(password string) (string, error) {
 	return string(passwordHash), nil
 }
 
+// Whether a user other than the one with exceptID has the email
+func emailTaken(data DBStructure, email string, exceptID int) bool {
+	for _, user := range data.Users {
+		if user.ID != exceptID && user.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns ErrEmailTaken if another user already has the email
 func (db *DB) CreateUser(email, password string, isChirpyRed bool) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
 	data, err := db.loadDB()
 	if err != nil {
 		return User{}, fmt.Errorf("failed to load database: %s", err)
 	}
 
-	passwordHash, err := hashPassword(password)
-	if err != nil {
-		return User{}, err
+	if emailTaken(data, email, 0) {
+		return User{}, ErrEmailTaken
 	}
 
-	id := len(data.Users) + 1
-	user := FullUser{
-		User:     User{Email: email, ID: id, IsChirpyRed: isChirpyRed},
-		Password: string(passwordHash),
+	user, err := addUser(&data, email, password, isChirpyRed)
+	if err != nil {
+		return User{}, err
 	}
-	data.Users[id] = user
 
 	err = db.writeDB(data)
 	if err != nil {
 		return User{}, fmt.Errorf("failed to write user to database: %s", err)
 	}
 
-	return *user.toUser(), nil
+	return *user, nil
 }
 
-func (db *DB) UpdateUser(id int, email, password string) (*User, error) {
-	user, err := db.GetUser(id)
+// Returns the user with the email, creating a passwordless user if there is
+// none. Both happen under the same lock, so that concurrent calls can't create
+// the same user twice
+func (db *DB) GetOrCreateUserByEmail(email string) (*User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	for _, user := range data.Users {
+		if user.Email == email {
+			return user.toUser(), nil
+		}
+	}
+
+	user, err := addUser(&data, email, "", false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user %s: %s", email, err)
+		return nil, err
 	}
 
-	if user == nil {
-		return nil, nil
+	if err := db.writeDB(data); err != nil {
+		return nil, fmt.Errorf("failed to write user to database: %s", err)
 	}
 
+	return user, nil
+}
+
+func addUser(data *DBStructure, email, password string, isChirpyRed bool) (*User, error) {
+	passwordHash := ""
+	if password != "" {
+		var err error
+		passwordHash, err = hashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data.LastUserID++
+	id := data.LastUserID
+	user := FullUser{
+		User:     User{Email: email, ID: id, IsChirpyRed: isChirpyRed, Handle: generateHandle(*data, email)},
+		Password: passwordHash,
+	}
+	data.Users[id] = user
+	data.HandleIndex[strings.ToLower(user.Handle)] = id
+
+	return user.toUser(), nil
+}
+
+// Changes the fields that are set in update. Returns nil if the user doesn't
+// exist, and ErrHandleTaken or ErrEmailTaken if another user already has the
+// new handle or email
+func (db *DB) UpdateUser(id int, update UserUpdate) (*User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
 	data, err := db.loadDB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load database: %s", err)
 	}
 
-	passwordHash, err := hashPassword(password)
-	if err != nil {
-		return nil, err
+	user, exists := data.Users[id]
+	if !exists {
+		return nil, nil
 	}
 
-	user.Email = email
-	updatedUser := FullUser{
-		User:     *user,
-		Password: passwordHash,
+	if update.Email != nil && *update.Email != user.Email {
+		if emailTaken(data, *update.Email, id) {
+			return nil, ErrEmailTaken
+		}
+		user.Email = *update.Email
+	}
+
+	if update.Password != nil {
+		user.Password, err = hashPassword(*update.Password)
+		if err != nil {
+			return nil, err
+		}
 	}
-	data.Users[id] = updatedUser
+
+	if update.Handle != nil {
+		key := strings.ToLower(*update.Handle)
+		if ownerID, taken := data.HandleIndex[key]; taken && ownerID != id {
+			return nil, ErrHandleTaken
+		}
+		delete(data.HandleIndex, strings.ToLower(user.Handle))
+		data.HandleIndex[key] = id
+		user.Handle = *update.Handle
+	}
+
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+	if update.Bio != nil {
+		user.Bio = *update.Bio
+	}
+	if update.AvatarURL != nil {
+		user.AvatarURL = *update.AvatarURL
+	}
+
+	data.Users[id] = user
 
 	err = db.writeDB(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to write user to database: %s", err)
 	}
 
-	return updatedUser.toUser(), nil
+	return user.toUser(), nil
 }
 
 func (db *DB) GetUsers() ([]User, error) {
@@ -104,6 +298,8 @@ func (db *DB) GetUsers() ([]User, error) {
 		users = append(users, *user.toUser())
 	}
 
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
 	return users, nil
 }
 
@@ -137,6 +333,32 @@ func (db *DB) GetUser(id int) (*User, error) {
 	return nil, nil
 }
 
+// Handles are compared case-insensitively, and may start with an `@`. Returns
+// nil if no user has the handle
+func (db *DB) GetUserByHandle(handle string) (*User, error) {
+	data, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database: %s", err)
+	}
+
+	id, exists := data.HandleIndex[strings.ToLower(strings.TrimPrefix(handle, "@"))]
+	if !exists {
+		return nil, nil
+	}
+
+	user := data.Users[id]
+	return user.toUser(), nil
+}
+
+func (db *DB) GetUserByEmail(email string) (*User, error) {
+	user, err := db.getUserByEmail(email)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	return user.toUser(), nil
+}
+
 func (db *DB) getUserByEmail(email string) (*FullUser, error) {
 	users, err := db.getUsersWithPasswords()
 	if err != nil {
@@ -152,16 +374,20 @@ func (db *DB) getUserByEmail(email string) (*FullUser, error) {
 	return nil, nil
 }
 
-func (db *DB) UpgradeUser(id int) error {
+func (db *DB) GetUserRole(id int) (string, error) {
 	data, err := db.loadDB()
 	if err != nil {
-		return fmt.Errorf("failed to upgrade user: %s", err)
+		return "", fmt.Errorf("failed to load database: %s", err)
 	}
 
-	user := data.Users[id]
-	user.IsChirpyRed = true
-	data.Users[id] = user
+	user, exists := data.Users[id]
+	if !exists {
+		return "", fmt.Errorf("user %d does not exist", id)
+	}
+
+	if user.Role == "" {
+		return RoleUser, nil
+	}
 
-	db.writeDB(data)
-	return nil
+	return user.Role, nil
 }
@@ -0,0 +1,87 @@
+// Package entities extracts hashtags and mentions from chirp bodies
+package entities
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mawkler/go-web-server/search"
+)
+
+type Type string
+
+const (
+	TypeHashtag Type = "hashtag"
+	TypeMention Type = "mention"
+)
+
+// A hashtag or mention in a chirp's body. Offsets are in runes and include the
+// leading `#` or `@`, with End being exclusive
+type Entity struct {
+	Type Type `json:"type"`
+	// The text after the `#` or `@`. Hashtags are normalized, so that `#Café`
+	// and `#cafe` are the same hashtag
+	Text  string `json:"text"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// The mentioned user, if the mention refers to an existing user
+	UserID *int `json:"user_id,omitempty"`
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r) || r == '_'
+}
+
+// Normalizes a hashtag the same way as Extract does, with or without its
+// leading `#`. Returns an empty string if it isn't a valid hashtag
+func NormalizeHashtag(hashtag string) string {
+	hashtag = "#" + strings.TrimPrefix(hashtag, "#")
+	tags := Extract(hashtag)
+	if len(tags) != 1 || tags[0].Type != TypeHashtag || tags[0].End != utf8.RuneCountInString(hashtag) {
+		return ""
+	}
+	return tags[0].Text
+}
+
+// Extracts the hashtags and mentions in text, in the order they appear.
+//
+// A `#` or `@` only starts an entity at the beginning of a word, so that `C#`
+// and email addresses aren't picked up. Hashtags have to contain at least one
+// character that isn't a digit, so that `#1` isn't a hashtag
+func Extract(text string) []Entity {
+	entities := []Entity{}
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); i++ {
+		sigil := runes[i]
+		if sigil != '#' && sigil != '@' {
+			continue
+		}
+		if i > 0 && (isNameRune(runes[i-1]) || runes[i-1] == '#' || runes[i-1] == '@') {
+			continue
+		}
+
+		end := i + 1
+		hasNonDigit := false
+		for end < len(runes) && isNameRune(runes[end]) {
+			hasNonDigit = hasNonDigit || !unicode.IsDigit(runes[end])
+			end++
+		}
+		if end == i+1 {
+			continue
+		}
+
+		name := string(runes[i+1 : end])
+		if sigil == '#' {
+			if hasNonDigit {
+				entities = append(entities, Entity{Type: TypeHashtag, Text: search.Normalize(name), Start: i, End: end})
+			}
+		} else {
+			entities = append(entities, Entity{Type: TypeMention, Text: name, Start: i, End: end})
+		}
+		i = end - 1
+	}
+
+	return entities
+}
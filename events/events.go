@@ -0,0 +1,136 @@
+// Package events lets subsystems react to things that happen elsewhere in the
+// application, without the code that makes them happen knowing about them
+package events
+
+import (
+	"log"
+	"sync"
+
+	"github.com/mawkler/go-web-server/database"
+)
+
+type Type string
+
+const (
+	TypeMentionCreated Type = "mention.created"
+	TypeReplyCreated   Type = "reply.created"
+	TypeFollowCreated  Type = "follow.created"
+	TypeChirpCreated   Type = "chirp.created"
+	TypeChirpDeleted   Type = "chirp.deleted"
+	// A notification was saved, after the user's preferences allowed it
+	TypeNotificationCreated  Type = "notification.created"
+	TypeDirectMessageCreated Type = "direct_message.created"
+	TypeChirpModerated       Type = "chirp.moderated"
+)
+
+type Event interface {
+	Type() Type
+}
+
+// A user was mentioned in a chirp, either when it was created or when an edit
+// added the mention
+type MentionCreated struct {
+	ChirpID  int
+	AuthorID int
+	UserID   int
+}
+
+func (MentionCreated) Type() Type { return TypeMentionCreated }
+
+type ReplyCreated struct {
+	ChirpID        int
+	AuthorID       int
+	ParentID       int
+	ParentAuthorID int
+}
+
+func (ReplyCreated) Type() Type { return TypeReplyCreated }
+
+type FollowCreated struct {
+	FollowerID int
+	FolloweeID int
+}
+
+func (FollowCreated) Type() Type { return TypeFollowCreated }
+
+type ChirpCreated struct {
+	Chirp database.Chirp
+}
+
+func (ChirpCreated) Type() Type { return TypeChirpCreated }
+
+type ChirpDeleted struct {
+	// The chirp as it was before it was deleted
+	Chirp database.Chirp
+}
+
+func (ChirpDeleted) Type() Type { return TypeChirpDeleted }
+
+type NotificationCreated struct {
+	Notification database.Notification
+}
+
+func (NotificationCreated) Type() Type { return TypeNotificationCreated }
+
+type DirectMessageCreated struct {
+	Message database.DirectMessage
+	// Everyone in the conversation, including the sender
+	ParticipantIDs []int
+}
+
+func (DirectMessageCreated) Type() Type { return TypeDirectMessageCreated }
+
+// A moderator acted on a chirp, or it was hidden automatically after enough
+// reports, in which case ModeratorID is 0
+type ChirpModerated struct {
+	ChirpID     int
+	AuthorID    int
+	ModeratorID int
+	Action      database.ModerationAction
+	Note        string
+}
+
+func (ChirpModerated) Type() Type { return TypeChirpModerated }
+
+type Handler func(Event)
+
+// Dispatches events to the handlers that have subscribed to their type.
+// Handlers run synchronously in the publishing goroutine, so slow handlers
+// should hand the work off themselves
+type Bus struct {
+	mux      sync.RWMutex
+	handlers map[Type][]Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: map[Type][]Handler{}}
+}
+
+func (bus *Bus) Subscribe(eventType Type, handler Handler) {
+	bus.mux.Lock()
+	defer bus.mux.Unlock()
+
+	bus.handlers[eventType] = append(bus.handlers[eventType], handler)
+}
+
+func (bus *Bus) Publish(event Event) {
+	bus.mux.RLock()
+	handlers := bus.handlers[event.Type()]
+	bus.mux.RUnlock()
+
+	for _, handler := range handlers {
+		bus.dispatch(handler, event)
+	}
+}
+
+// A panicking handler shouldn't take down the request that published the
+// event, or keep the other handlers from running
+func (bus *Bus) dispatch(handler Handler, event Event) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("handler of %s event panicked: %s", event.Type(), err)
+		}
+	}()
+
+	handler(event)
+}
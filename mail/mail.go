@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Outbox is a Mailer that writes each message to a file in a local directory
+// instead of delivering it
+type Outbox struct {
+	mux *sync.Mutex
+	dir string
+}
+
+func NewOutbox(dir string) *Outbox {
+	return &Outbox{mux: &sync.Mutex{}, dir: dir}
+}
+
+func (o *Outbox) Send(msg Message) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create outbox directory: %s", err)
+	}
+
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%d.eml", now.UnixNano())
+	contents := fmt.Sprintf(
+		"To: %s\nSubject: %s\nDate: %s\n\n%s\n",
+		msg.To, msg.Subject, now.Format(time.RFC1123Z), msg.Body,
+	)
+
+	if err := os.WriteFile(filepath.Join(o.dir, name), []byte(contents), 0644); err != nil {
+		return fmt.Errorf("failed to write message to outbox: %s", err)
+	}
+
+	return nil
+}
@@ -3,35 +3,163 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"github.com/mawkler/go-web-server/api"
 	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/events"
+	"github.com/mawkler/go-web-server/mail"
+	"github.com/mawkler/go-web-server/media"
+	"github.com/mawkler/go-web-server/moderation"
+	"github.com/mawkler/go-web-server/notifications"
+	"github.com/mawkler/go-web-server/openapi"
+	"github.com/mawkler/go-web-server/stream"
 )
 
-type errResponse struct {
-	Error string `json:"error"`
+// Credentialed requests (cookie sessions) aren't allowed with a wildcard
+// origin, so allowed origins get echoed back instead
+func middlewareCors(allowedOrigins []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if len(allowedOrigins) == 0 {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				w.Header().Set("Access-Control-Allow-Headers", "*")
+			} else if slices.Contains(allowedOrigins, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-CSRF-Token")
+			}
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-func middlewareCors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "*")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+// Parses a comma separated list
+func parseList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
-		next.ServeHTTP(w, r)
-	})
+	}
+	return items
 }
 
+// Parses an integer from an environment variable
+func intFromEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid number in %s: %s", name, err)
+	}
+
+	return n
+}
+
+// Parses a duration like `15m` from an environment variable
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid duration in %s: %s", name, err)
+	}
+
+	return duration
+}
+
+// Parses a comma separated list of IP addresses and CIDR ranges like
+// `10.0.0.0/8` from an environment variable
+func prefixesFromEnv(name string) []netip.Prefix {
+	prefixes := []netip.Prefix{}
+	for _, item := range parseList(os.Getenv(name)) {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(item)
+			if addrErr != nil {
+				log.Fatalf("Invalid IP address or range in %s: %s", name, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// Rules from later sources override earlier ones: the built-in word list, then
+// the word list files, and lastly rules added by admins
+func loadModerationFilter(db *database.DB, wordlists []string) (*moderation.Filter, error) {
+	filter := moderation.NewFilter(moderation.DefaultRules()...)
+
+	for _, path := range wordlists {
+		rules, err := moderation.LoadRules(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			if err := filter.SetRule(rule); err != nil {
+				return nil, fmt.Errorf("invalid rule for %q in %s: %s", rule.Word, path, err)
+			}
+		}
+	}
+
+	dbRules, err := db.GetModerationRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range dbRules {
+		if rule.Deleted {
+			filter.RemoveRule(rule.Word)
+			continue
+		}
+		if err := filter.SetRule(moderation.Rule{Word: rule.Word, Action: moderation.Action(rule.Action)}); err != nil {
+			return nil, fmt.Errorf("invalid rule for %q in database: %s", rule.Word, err)
+		}
+	}
+
+	return filter, nil
+}
+
+const (
+	// How many events reconnecting stream clients can catch up on
+	streamReplayBufferSize = 1000
+	// How many events a stream client can fall behind before it's disconnected
+	streamQueueSize = 64
+	// How often accounts whose deletion grace period has ended get deleted
+	accountPurgeInterval = time.Hour
+	// How often subscriptions whose period has ended get expired
+	subscriptionExpiryInterval = time.Minute
+)
+
 func main() {
 	databasePath := "database/database.json"
 	debug := flag.Bool("debug", false, "Enable debug mode")
+	reindex := flag.Bool("reindex", false, "Rebuild the chirp search index and exit")
+	makeAdmin := flag.Int("make-admin", 0, "Give the user with this ID the admin role and exit")
 	flag.Parse()
 	if *debug {
 		os.Remove(databasePath)
@@ -40,16 +168,113 @@ func main() {
 	godotenv.Load()
 	jwtSecret := os.Getenv("JWT_SECRET")
 	polkaAPIKey := os.Getenv("POLKA_API_KEY")
+	allowedOrigins := parseList(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	blockImpersonatedWrites := os.Getenv("BLOCK_IMPERSONATED_WRITES") == "true"
+	port := "8080"
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("http://localhost:%s", port)
+	}
 
 	db := database.New(databasePath)
-	mux := http.NewServeMux()
+	if *reindex {
+		if err := db.RebuildSearchIndex(); err != nil {
+			log.Fatalf("Failed to rebuild search index: %s", err)
+		}
+		fmt.Println("Rebuilt search index")
+		return
+	}
+	if *makeAdmin != 0 {
+		if err := db.SetUserRole(*makeAdmin, database.RoleAdmin); err != nil {
+			log.Fatalf("Failed to make user %d an admin: %s", *makeAdmin, err)
+		}
+		fmt.Printf("User %d is now an admin\n", *makeAdmin)
+		return
+	}
+
+	mux := openapi.NewRouter()
+
+	mailer := mail.NewOutbox("outbox")
 
-	cfg := api.NewAPIConfig(db, jwtSecret, polkaAPIKey, 0)
+	filter, err := loadModerationFilter(db, parseList(os.Getenv("MODERATION_WORDLISTS")))
+	if err != nil {
+		log.Fatalf("Failed to load moderation rules: %s", err)
+	}
+
+	chirpLimits := api.ChirpLimits{
+		MaxLength:     intFromEnv("CHIRP_MAX_LENGTH", 140),
+		RedMaxLength:  intFromEnv("CHIRP_RED_MAX_LENGTH", 280),
+		EditWindow:    durationFromEnv("CHIRP_EDIT_WINDOW", 15*time.Minute),
+		RedEditWindow: durationFromEnv("CHIRP_RED_EDIT_WINDOW", time.Hour),
+	}
+
+	eventBus := events.NewBus()
+	notifications.Subscribe(eventBus, db)
+
+	broker := stream.NewBroker(streamReplayBufferSize, streamQueueSize)
+	stream.Forward(eventBus, broker)
+	if *debug {
+		eventBus.Subscribe(events.TypeMentionCreated, func(event events.Event) {
+			log.Printf("Event %s: %+v", event.Type(), event)
+		})
+	}
+
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "uploads"
+	}
+	blobs := media.NewLocalStore(mediaDir)
+
+	cfg := api.NewAPIConfig(db, api.Options{
+		JWTSecret:                  jwtSecret,
+		PolkaAPIKey:                polkaAPIKey,
+		Mailer:                     mailer,
+		BaseURL:                    baseURL,
+		BlockImpersonatedWrites:    blockImpersonatedWrites,
+		Moderation:                 filter,
+		ChirpLimits:                chirpLimits,
+		Events:                     eventBus,
+		Broker:                     broker,
+		Blobs:                      blobs,
+		AccountDeletionGracePeriod: durationFromEnv("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour),
+		ReportHideThreshold:        intFromEnv("REPORT_HIDE_THRESHOLD", 3),
+		TrustedProxies:             prefixesFromEnv("TRUSTED_PROXIES"),
+	})
+	registerRoutes(mux, &cfg)
+
+	go func() {
+		for {
+			cfg.PurgeDeletedAccounts()
+			time.Sleep(accountPurgeInterval)
+		}
+	}()
+
+	go func() {
+		for {
+			cfg.ExpireSubscriptions()
+			time.Sleep(subscriptionExpiryInterval)
+		}
+	}()
+
+	corsMux := middlewareCors(allowedOrigins)(api.MiddlewareRequestID(mux))
+	server := http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: corsMux,
+	}
+
+	fmt.Printf("Listening to port %s\n", port)
+	server.ListenAndServe()
+}
+
+// Registers all routes on mux. Everything under /api/ and /admin/ is
+// documented in the OpenAPI spec at /api/openapi.json
+func registerRoutes(mux *openapi.Router, cfg *api.APIConfig) {
 	fileServer := http.FileServer(http.Dir("."))
 	appHandler := http.StripPrefix("/app", fileServer)
 
 	// File server
 	mux.Handle("/app/*", cfg.MiddlewareMetricsInc(appHandler))
+	mux.HandleFunc("GET /media/{key}", cfg.HandlerServeMedia)
 
 	// Health and metrics
 	mux.HandleFunc("GET /api/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -60,34 +285,116 @@ func main() {
 	mux.HandleFunc("GET /admin/metrics", cfg.HandlerMetrics)
 	mux.HandleFunc("GET /api/reset", cfg.HandlerReset)
 
+	// Admin
+	mux.Handle("POST /admin/users/{id}/impersonate", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerImpersonate)))
+	mux.Handle("GET /admin/users", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerSearchUsers)))
+	mux.Handle("GET /admin/users/{id}", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerGetAccountState)))
+	mux.Handle("POST /admin/users/{id}/suspend", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerSuspendUser)))
+	mux.Handle("POST /admin/users/{id}/reactivate", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerReactivateUser)))
+	mux.Handle("POST /admin/users/{id}/password-reset", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerForcePasswordReset)))
+	mux.Handle("PUT /admin/users/{id}/role", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerSetUserRole)))
+	mux.Handle("POST /admin/users/{id}/chirpy-red", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerGrantChirpyRed)))
+	mux.Handle("DELETE /admin/users/{id}/chirpy-red", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerRevokeChirpyRed)))
+	mux.Handle("GET /admin/audit", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerGetAuditLog)))
+	mux.Handle("POST /admin/introspect", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerIntrospect)))
+	mux.Handle("GET /admin/moderation/rules", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerGetModerationRules)))
+	mux.Handle("PUT /admin/moderation/rules/{word}", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerSetModerationRule)))
+	mux.Handle("DELETE /admin/moderation/rules/{word}", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerDeleteModerationRule)))
+	mux.Handle("GET /admin/moderation/flags", cfg.MiddlewareAdmin(http.HandlerFunc(cfg.HandlerGetChirpFlags)))
+	mux.Handle("GET /admin/moderation/reports", cfg.MiddlewareModerator(http.HandlerFunc(cfg.HandlerGetReportQueue)))
+	mux.Handle("POST /admin/moderation/chirps/{id}/actions", cfg.MiddlewareModerator(http.HandlerFunc(cfg.HandlerModerateChirp)))
+
 	// Authentication
 	mux.HandleFunc("POST /api/login", cfg.HandlerLogin)
-	mux.Handle("POST /api/refresh", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerRefresh)))
-	mux.Handle("POST /api/revoke", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerRevoke)))
+	mux.HandleFunc("POST /api/login/magic", cfg.HandlerMagicLinkLogin)
+	mux.HandleFunc("GET /api/login/magic/verify", cfg.HandlerMagicLinkConfirm)
+	mux.HandleFunc("POST /api/login/magic/verify", cfg.HandlerMagicLinkVerify)
+	mux.Handle("POST /api/refresh", cfg.MiddlewareRefreshAuthorization(http.HandlerFunc(cfg.HandlerRefresh)))
+	mux.Handle("POST /api/revoke", cfg.MiddlewareRefreshAuthorization(http.HandlerFunc(cfg.HandlerRevoke)))
+	mux.Handle("GET /api/me", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerMe)))
 
 	// Chirps
-	mux.HandleFunc("/api/validate_chirp", cfg.HandlerValidateChirp)
+	mux.Handle("POST /api/validate_chirp", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerValidateChirp)))
 	mux.Handle("POST /api/chirps", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerCreateChirp)))
 	mux.Handle("DELETE /api/chirps/{id}", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerDeleteChirp)))
-	mux.HandleFunc("GET /api/chirps", cfg.HandlerGetChirps)
-	mux.HandleFunc("GET /api/chirps/{id}", cfg.HandlerGetChirp)
+	mux.Handle("GET /api/chirps", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetChirps)))
+	mux.Handle("GET /api/chirps/{id}", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetChirp)))
+	mux.Handle("PUT /api/chirps/{id}", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUpdateChirp)))
+	mux.Handle("PATCH /api/chirps/{id}", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUpdateChirp)))
+	mux.Handle("GET /api/chirps/{id}/history", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetChirpHistory)))
+	mux.Handle("GET /api/chirps/{id}/thread", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetThread)))
+	mux.Handle("GET /api/search/chirps", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerSearchChirps)))
+	mux.Handle("POST /api/chirps/{id}/report", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerReportChirp)))
+
+	// Media
+	mux.Handle("POST /api/media", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUploadMedia)))
+	mux.HandleFunc("GET /api/media/{id}", cfg.HandlerGetMedia)
+
+	// Notifications
+	mux.Handle("GET /api/notifications", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerGetNotifications)))
+	mux.Handle("POST /api/notifications/{id}/read", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerMarkNotificationRead)))
+	mux.Handle("POST /api/notifications/read", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerMarkAllNotificationsRead)))
+	mux.Handle("GET /api/notifications/preferences", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerGetNotificationPreferences)))
+	mux.Handle("PATCH /api/notifications/preferences", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUpdateNotificationPreferences)))
+
+	// Direct messages
+	mux.Handle("POST /api/conversations", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerCreateConversation)))
+	mux.Handle("GET /api/conversations", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerGetConversations)))
+	mux.Handle("GET /api/conversations/{id}", cfg.MiddlewareConversationParticipant(http.HandlerFunc(cfg.HandlerGetConversation)))
+	mux.Handle("GET /api/conversations/{id}/messages", cfg.MiddlewareConversationParticipant(http.HandlerFunc(cfg.HandlerGetDirectMessages)))
+	mux.Handle("POST /api/conversations/{id}/messages", cfg.MiddlewareConversationParticipant(http.HandlerFunc(cfg.HandlerSendDirectMessage)))
+	mux.Handle("POST /api/conversations/{id}/read", cfg.MiddlewareConversationParticipant(http.HandlerFunc(cfg.HandlerMarkConversationRead)))
+	mux.Handle("GET /api/conversations/preferences", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerGetDirectMessagePreferences)))
+	mux.Handle("PUT /api/conversations/preferences", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUpdateDirectMessagePreferences)))
+
+	// Streaming
+	mux.Handle("GET /api/stream", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerStream)))
+	mux.Handle("GET /api/stream/ws", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerStreamWebSocket)))
+
+	// Hashtags
+	mux.Handle("GET /api/hashtags/{tag}/chirps", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetHashtagChirps)))
+	mux.HandleFunc("GET /api/hashtags/trending", cfg.HandlerTrendingHashtags)
+
+	// Likes and rechirps
+	mux.Handle("POST /api/chirps/{id}/like", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerLikeChirp)))
+	mux.Handle("DELETE /api/chirps/{id}/like", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUnlikeChirp)))
+	mux.Handle("POST /api/chirps/{id}/rechirp", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerRechirp)))
+	mux.Handle("DELETE /api/chirps/{id}/rechirp", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUndoRechirp)))
+	mux.Handle("GET /api/users/{id}/likes", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetLikedChirps)))
 
 	// Users
 	mux.HandleFunc("POST /api/users", cfg.HandlerCreateUser)
-	mux.HandleFunc("GET /api/users", cfg.HandlerGetUsers)
-	mux.HandleFunc("GET /api/users/{id}", cfg.HandlerGetUser)
+	mux.Handle("GET /api/users", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetUsers)))
+	mux.Handle("GET /api/users/{id}", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetUser)))
+	mux.Handle("GET /api/handles/{handle}", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetUserByHandle)))
 	mux.Handle("PUT /api/users", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUpdateUser)))
+	mux.Handle("PATCH /api/users", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUpdateUser)))
+	mux.Handle("DELETE /api/users/me", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerDeleteAccount)))
+	mux.Handle("GET /api/users/me/export", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerExportAccount)))
+	mux.Handle("GET /api/users/me/subscription", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerGetSubscription)))
+
+	// Follows
+	mux.Handle("POST /api/users/{id}/follow", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerFollow)))
+	mux.Handle("DELETE /api/users/{id}/follow", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUnfollow)))
+	mux.Handle("GET /api/users/{id}/followers", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetFollowers)))
+	mux.Handle("GET /api/users/{id}/following", cfg.MiddlewareOptionalAuthorization(http.HandlerFunc(cfg.HandlerGetFollowing)))
+	mux.Handle("GET /api/timeline", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerTimeline)))
+
+	// Blocks and mutes
+	mux.Handle("POST /api/users/{id}/block", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerBlock)))
+	mux.Handle("DELETE /api/users/{id}/block", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUnblock)))
+	mux.Handle("POST /api/users/{id}/mute", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerMute)))
+	mux.Handle("DELETE /api/users/{id}/mute", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerUnmute)))
+	mux.Handle("GET /api/users/me/blocks", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerGetBlockedUsers)))
+	mux.Handle("GET /api/users/me/mutes", cfg.MiddlewareAuthorization(http.HandlerFunc(cfg.HandlerGetMutedUsers)))
 
 	// Webhooks
-	mux.HandleFunc("POST /api/polka/webhooks", cfg.HandlerUpgraded)
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.HandlerPolkaWebhook)
 
-	corsMux := middlewareCors(mux)
-	port := "8080"
-	server := http.Server{
-		Addr:    fmt.Sprintf(":%s", port),
-		Handler: corsMux,
+	// Documentation
+	spec := func() map[string]any {
+		return mux.Spec("Chirpy API", "1.0.0", []string{"/api/", "/admin/"}, api.Operations)
 	}
-
-	fmt.Printf("Listening to port %s\n", port)
-	server.ListenAndServe()
+	mux.HandleFunc("GET /api/openapi.json", openapi.HandlerSpec(spec))
+	mux.HandleFunc("GET /api/docs", openapi.HandlerDocs("Chirpy API", "/api/openapi.json"))
 }
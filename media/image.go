@@ -0,0 +1,263 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type")
+	ErrInvalidImage    = errors.New("invalid image")
+	// The image has more pixels than allowed, regardless of its file size
+	ErrTooManyPixels = errors.New("image has too many pixels")
+)
+
+// Limits decompression bombs, which are small files that decode into huge
+// images
+const MaxPixels = 40_000_000
+
+const jpegQuality = 85
+
+var extensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// The file extension of a supported content type
+func Extension(contentType string) string {
+	return extensions[contentType]
+}
+
+type Image struct {
+	ContentType string
+	// Re-encoded, so that metadata like EXIF is left out
+	Data   []byte
+	Width  int
+	Height int
+	// JPEG for JPEG images, and PNG otherwise, to keep transparency
+	Thumbnail            []byte
+	ThumbnailContentType string
+}
+
+// Checks the type of the image by its content rather than by what the client
+// claims, and re-encodes it without metadata. GIFs keep their animation.
+// JPEGs get rotated according to their EXIF orientation, since that's lost
+// along with the rest of the metadata
+func Process(data []byte, thumbnailSize int) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	if Extension(contentType) == "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	var img image.Image
+	encoded := bytes.Buffer{}
+
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+		}
+		img = orient(img, jpegOrientation(data))
+		err = jpeg.Encode(&encoded, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+		}
+		err = png.Encode(&encoded, img)
+	case "image/gif":
+		var animation *gif.GIF
+		animation, err = gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+		}
+		img = animation.Image[0]
+		err = gif.EncodeAll(&encoded, animation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %s", err)
+	}
+
+	thumbnail := bytes.Buffer{}
+	thumbnailContentType := "image/png"
+	if contentType == "image/jpeg" {
+		thumbnailContentType = "image/jpeg"
+		err = jpeg.Encode(&thumbnail, resize(img, thumbnailSize), &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&thumbnail, resize(img, thumbnailSize))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %s", err)
+	}
+
+	bounds := img.Bounds()
+	return &Image{
+		ContentType:          contentType,
+		Data:                 encoded.Bytes(),
+		Width:                bounds.Dx(),
+		Height:               bounds.Dy(),
+		Thumbnail:            thumbnail.Bytes(),
+		ThumbnailContentType: thumbnailContentType,
+	}, nil
+}
+
+// Scales the image down to fit within size × size pixels, averaging the
+// pixels that each thumbnail pixel covers. Smaller images keep their size
+func resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	dstWidth, dstHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			dstWidth, dstHeight = size, max(1, height*size/width)
+		} else {
+			dstWidth, dstHeight = max(1, width*size/height), size
+		}
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := range dstHeight {
+		y0 := bounds.Min.Y + y*height/dstHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/dstHeight)
+		for x := range dstWidth {
+			x0 := bounds.Min.X + x*width/dstWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/dstWidth)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sr, sg, sb, sa := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(sr), g+uint64(sg), b+uint64(sb), a+uint64(sa), n+1
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
+
+// Cameras store how a photo is rotated in its EXIF metadata rather than
+// rotating the pixels. Returns 1, the default orientation, if it's missing
+func jpegOrientation(data []byte) int {
+	// Segments follow the start of image marker until the image data starts
+	for i := 2; i+4 <= len(data); {
+		marker := data[i+1]
+		if data[i] != 0xFF || marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+
+		i = end
+	}
+
+	return 1
+}
+
+// Finds the orientation tag in the first IFD of EXIF's TIFF structure
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 0 || offset+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+
+	return 1
+}
+
+// Applies an EXIF orientation, where 2–4 are mirrored or rotated by 180°, and
+// 5–8 swap the width and height
+func orient(src image.Image, orientation int) image.Image {
+	if orientation <= 1 {
+		return src
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := range dstHeight {
+		for x := range dstWidth {
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = width - 1 - x
+			case 3:
+				sx, sy = width-1-x, height-1-y
+			case 4:
+				sy = height - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, height-1-x
+			case 7:
+				sx, sy = width-1-y, height-1-x
+			case 8:
+				sx, sy = width-1-y, x
+			}
+			dst.Set(x, y, src.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
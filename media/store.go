@@ -0,0 +1,106 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var ErrBlobNotFound = errors.New("blob does not exist")
+
+// BlobStore stores uploaded files by key. Its operations mirror those of
+// S3-compatible object stores, so that an object store can replace the local
+// filesystem without changes to the handlers
+type BlobStore interface {
+	Put(key string, body io.Reader, contentType string) error
+	// Returns ErrBlobNotFound if there's no blob with the key
+	Get(key string) (io.ReadCloser, error)
+	// Deleting a blob that doesn't exist has no effect
+	Delete(key string) error
+}
+
+// Keys are generated by the server, so anything else is rejected rather than
+// being turned into a path
+var keyPattern = regexp.MustCompile(`^[0-9a-f]{32}(_thumb)?\.(jpg|png|gif)$`)
+
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
+
+// LocalStore is a BlobStore that keeps blobs as files in a local directory.
+// The content type isn't stored, since it's implied by the key's extension
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir}
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Writes to a temporary file first, so that readers never see a partial blob
+func (s *LocalStore) Put(key string, body io.Reader, _ string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create media directory: %s", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create blob %s: %s", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob %s: %s", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob %s: %s", key, err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, ErrBlobNotFound
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %s", key, err)
+	}
+
+	return file, nil
+}
+
+func (s *LocalStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob %s: %s", key, err)
+	}
+
+	return nil
+}
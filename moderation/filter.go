@@ -0,0 +1,277 @@
+package moderation
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Action string
+
+// Actions in order of increasing severity
+const (
+	ActionNone   Action = ""
+	ActionMask   Action = "mask"
+	ActionFlag   Action = "flag"
+	ActionReject Action = "reject"
+)
+
+const mask = "****"
+
+func (a Action) severity() int {
+	switch a {
+	case ActionMask:
+		return 1
+	case ActionFlag:
+		return 2
+	case ActionReject:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func ParseAction(action string) (Action, error) {
+	switch Action(action) {
+	case ActionMask, ActionFlag, ActionReject:
+		return Action(action), nil
+	default:
+		return ActionNone, fmt.Errorf("unknown action %q, expected mask, flag or reject", action)
+	}
+}
+
+type Rule struct {
+	Word   string `json:"word"`
+	Action Action `json:"action"`
+}
+
+type Match struct {
+	Rule
+	// The text that matched, as written
+	Text string `json:"text"`
+	// Byte offsets into the checked text
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type Result struct {
+	// The checked text with masked words replaced
+	Text string `json:"text"`
+	// The most severe action of all matches
+	Action  Action  `json:"action"`
+	Matches []Match `json:"matches"`
+}
+
+type Filter struct {
+	mux *sync.RWMutex
+	// Keyed by normalized word
+	rules map[string]Rule
+}
+
+//go:embed default.txt
+var defaultRules string
+
+func NewFilter(rules ...Rule) *Filter {
+	filter := &Filter{mux: &sync.RWMutex{}, rules: map[string]Rule{}}
+	for _, rule := range rules {
+		filter.SetRule(rule)
+	}
+	return filter
+}
+
+func DefaultRules() []Rule {
+	rules, err := ParseRules(strings.NewReader(defaultRules))
+	if err != nil {
+		panic(fmt.Sprintf("invalid default moderation rules: %s", err))
+	}
+	return rules
+}
+
+// Parses a word list with one rule per line, formatted as `<word> [action]`.
+// Empty lines and lines starting with `#` are ignored
+func ParseRules(r io.Reader) ([]Rule, error) {
+	rules := []Rule{}
+	scanner := bufio.NewScanner(r)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		rule := Rule{Word: fields[0], Action: ActionMask}
+		if len(fields) > 1 {
+			action, err := ParseAction(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+			}
+			rule.Action = action
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+func LoadRules(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open word list: %s", err)
+	}
+	defer f.Close()
+
+	rules, err := ParseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid word list %s: %s", path, err)
+	}
+	return rules, nil
+}
+
+func (f *Filter) SetRule(rule Rule) error {
+	if _, err := ParseAction(string(rule.Action)); err != nil {
+		return err
+	}
+
+	normalized := Normalize(rule.Word)
+	if normalized == "" {
+		return fmt.Errorf("word must not be empty")
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.rules[normalized] = rule
+	return nil
+}
+
+// Returns false if there was no rule for the word
+func (f *Filter) RemoveRule(word string) bool {
+	normalized := Normalize(word)
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	_, exists := f.rules[normalized]
+	delete(f.rules, normalized)
+	return exists
+}
+
+func (f *Filter) Rules() []Rule {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	rules := make([]Rule, 0, len(f.rules))
+	for _, rule := range f.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Word < rules[j].Word })
+	return rules
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+}
+
+type span struct {
+	start, end int
+	// Where the leetspeak symbols before the word start, which is start if
+	// there are none
+	symbolsStart int
+}
+
+// Finds the words in text. Leetspeak symbols count as part of a word, unless
+// they're at the end of it, so that `sh!t` is one word but the `!` in `oh!`
+// is punctuation. Symbols at the start of a word are kept track of separately,
+// since they can be either, like the `$` in `$hit` and the `@` in `@name`
+func words(text string) []span {
+	spans := []span{}
+	start := -1
+
+	for i, r := range text {
+		inWord := isWordRune(r) || isLeet(r)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			spans = append(spans, trimLeet(text, span{start, i, start}))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, trimLeet(text, span{start, len(text), start}))
+	}
+
+	return spans
+}
+
+// Trims leading and trailing leetspeak symbols that aren't letters or digits
+func trimLeet(text string, s span) span {
+	for s.end > s.start {
+		r, size := utf8.DecodeLastRuneInString(text[s.start:s.end])
+		if isWordRune(r) {
+			break
+		}
+		s.end -= size
+	}
+	for s.start < s.end {
+		r, size := utf8.DecodeRuneInString(text[s.start:s.end])
+		if isWordRune(r) {
+			break
+		}
+		s.start += size
+	}
+	return s
+}
+
+// Checks text against the rules. Whitespace and punctuation are left intact
+func (f *Filter) Check(text string) Result {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	result := Result{Matches: []Match{}}
+	var masked strings.Builder
+	previousEnd := 0
+
+	for _, s := range words(text) {
+		if s.start == s.end {
+			continue
+		}
+
+		// Leading symbols are tried as letters first, like in `$hit`, and then
+		// dropped one by one, like the `@` in `@$hit`
+		start := s.symbolsStart
+		rule, matches := f.rules[Normalize(text[start:s.end])]
+		for !matches && start < s.start {
+			_, size := utf8.DecodeRuneInString(text[start:])
+			start += size
+			rule, matches = f.rules[Normalize(text[start:s.end])]
+		}
+		if !matches {
+			continue
+		}
+		s.start = start
+
+		word := text[s.start:s.end]
+
+		result.Matches = append(result.Matches, Match{Rule: rule, Text: word, Start: s.start, End: s.end})
+		if rule.Action.severity() > result.Action.severity() {
+			result.Action = rule.Action
+		}
+
+		if rule.Action == ActionMask {
+			masked.WriteString(text[previousEnd:s.start])
+			masked.WriteString(mask)
+			previousEnd = s.end
+		}
+	}
+
+	masked.WriteString(text[previousEnd:])
+	result.Text = masked.String()
+
+	return result
+}
@@ -0,0 +1,31 @@
+package moderation
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	filter := NewFilter(Rule{Word: "fornax", Action: ActionMask}, Rule{Word: "shoot", Action: ActionFlag})
+
+	tests := []struct {
+		text   string
+		masked string
+		action Action
+	}{
+		{"what the fornax", "what the ****", ActionMask},
+		{"f0rn@x!", "****!", ActionMask},
+		{"@fornax is here", "@**** is here", ActionMask},
+		{"(fornax)", "(****)", ActionMask},
+		{"$hoot", "$hoot", ActionFlag},
+		{"@$hoot", "@$hoot", ActionFlag},
+		{"fornaxes", "fornaxes", ActionNone},
+		{"@ !! @", "@ !! @", ActionNone},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			result := filter.Check(test.text)
+			if result.Text != test.masked || result.Action != test.action {
+				t.Errorf("got %q with action %q, want %q with action %q", result.Text, result.Action, test.masked, test.action)
+			}
+		})
+	}
+}
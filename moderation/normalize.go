@@ -0,0 +1,52 @@
+package moderation
+
+import (
+	"strings"
+
+	"github.com/mawkler/go-web-server/search"
+)
+
+// Symbols and digits that are commonly used in place of letters
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '+': 't',
+}
+
+// Non-Latin characters that look like Latin letters
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j', 'ѕ': 's',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+}
+
+func isLeet(r rune) bool {
+	_, ok := leetspeak[r]
+	return ok
+}
+
+func foldRune(r rune) rune {
+	// Fullwidth forms, e.g. `ｋ`
+	if r >= 0xFF01 && r <= 0xFF5E {
+		r -= 0xFEE0
+	}
+	if folded, ok := confusables[r]; ok {
+		return folded
+	}
+	if folded, ok := leetspeak[r]; ok {
+		return folded
+	}
+	return r
+}
+
+// Case folds and removes diacritics, leetspeak and confusable characters, so
+// that e.g. `K3rfüffle` becomes `kerfuffle`
+func Normalize(word string) string {
+	var b strings.Builder
+	for _, r := range search.Normalize(word) {
+		b.WriteRune(foldRune(r))
+	}
+	return search.Normalize(b.String())
+}
@@ -0,0 +1,69 @@
+// Package notifications turns events into in-app notifications for the users
+// they concern
+package notifications
+
+import (
+	"log"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/events"
+)
+
+// Subscribes to the events that users get notified about
+func Subscribe(bus *events.Bus, db *database.DB) {
+	bus.Subscribe(events.TypeReplyCreated, func(event events.Event) {
+		reply := event.(events.ReplyCreated)
+		if reply.AuthorID == reply.ParentAuthorID {
+			return
+		}
+		notify(bus, db, database.Notification{
+			UserID:  reply.ParentAuthorID,
+			Type:    database.NotificationReply,
+			ActorID: reply.AuthorID,
+			ChirpID: &reply.ChirpID,
+		})
+	})
+
+	bus.Subscribe(events.TypeMentionCreated, func(event events.Event) {
+		mention := event.(events.MentionCreated)
+		notify(bus, db, database.Notification{
+			UserID:  mention.UserID,
+			Type:    database.NotificationMention,
+			ActorID: mention.AuthorID,
+			ChirpID: &mention.ChirpID,
+		})
+	})
+
+	bus.Subscribe(events.TypeFollowCreated, func(event events.Event) {
+		follow := event.(events.FollowCreated)
+		notify(bus, db, database.Notification{
+			UserID:  follow.FolloweeID,
+			Type:    database.NotificationFollow,
+			ActorID: follow.FollowerID,
+		})
+	})
+
+	bus.Subscribe(events.TypeChirpModerated, func(event events.Event) {
+		moderated := event.(events.ChirpModerated)
+		notify(bus, db, database.Notification{
+			UserID:           moderated.AuthorID,
+			Type:             database.NotificationModeration,
+			ChirpID:          &moderated.ChirpID,
+			ModerationAction: moderated.Action,
+			ModerationNote:   moderated.Note,
+		})
+	})
+}
+
+// Failing to notify shouldn't fail the action that caused the notification
+func notify(bus *events.Bus, db *database.DB, notification database.Notification) {
+	saved, err := db.CreateNotification(notification)
+	if err != nil {
+		log.Printf("failed to notify user %d of %s: %s", notification.UserID, notification.Type, err)
+		return
+	}
+
+	if saved != nil {
+		bus.Publish(events.NotificationCreated{Notification: *saved})
+	}
+}
@@ -0,0 +1,26 @@
+package openapi
+
+import (
+	_ "embed"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+//go:embed docs.html
+var docsPage string
+
+// Serves a self-contained page that renders the spec at specURL, so that the
+// docs work without fetching anything from a CDN
+func HandlerDocs(title, specURL string) http.HandlerFunc {
+	page := strings.NewReplacer(
+		"{{title}}", html.EscapeString(title),
+		"{{specURL}}", html.EscapeString(specURL),
+	).Replace(docsPage)
+
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}
+}
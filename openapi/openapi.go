@@ -0,0 +1,256 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Security requirements of an operation
+const (
+	SecurityNone   = ""
+	SecurityBearer = "bearer"
+	SecurityAPIKey = "apiKey"
+	// Authentication is accepted but not required
+	SecurityOptionalBearer = "optionalBearer"
+)
+
+type Parameter struct {
+	Name        string
+	Description string
+	// A JSON schema type, "string" if empty
+	Type     string
+	Required bool
+}
+
+// Documentation of a single route. Request and the values of Responses are
+// zero values of the types that get sent, and are only used for their types.
+// A nil response means that the response has no body
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Security    string
+	Query       []Parameter
+	Request     any
+	// Content type of the request body, "application/json" if empty
+	RequestContentType string
+	Responses          map[int]any
+	// Content type of error responses, "application/json" if empty
+	ErrorContentType string
+}
+
+// Router is an http.ServeMux that remembers the patterns that get registered,
+// so that the routes can be documented
+type Router struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func NewRouter() *Router {
+	return &Router{ServeMux: http.NewServeMux()}
+}
+
+func (router *Router) Handle(pattern string, handler http.Handler) {
+	router.patterns = append(router.patterns, pattern)
+	router.ServeMux.Handle(pattern, handler)
+}
+
+func (router *Router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	router.Handle(pattern, http.HandlerFunc(handler))
+}
+
+func (router *Router) Patterns() []string {
+	return router.patterns
+}
+
+var pathParameterPattern = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// Generates an OpenAPI 3.1 document for all registered routes that start with
+// one of the prefixes. Routes without an operation in operations are included
+// but left mostly undocumented
+func (router *Router) Spec(title, version string, prefixes []string, operations map[string]Operation) map[string]any {
+	generator := newSchemaGenerator()
+	paths := map[string]map[string]any{}
+
+	for _, pattern := range router.patterns {
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			method, path = "GET", pattern
+		}
+
+		if !hasAnyPrefix(path, prefixes) {
+			continue
+		}
+
+		op, documented := operations[pattern]
+		if !documented {
+			log.Printf("route %s has no OpenAPI operation", pattern)
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(method)] = generator.operation(method, path, op)
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   title,
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": generator.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"cookieAuth": map[string]any{"type": "apiKey", "in": "cookie", "name": "chirpy_access"},
+				"apiKeyAuth": map[string]any{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "Authorization",
+					"description": "Formatted as `ApiKey <key>`",
+				},
+			},
+		},
+	}
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *schemaGenerator) operation(method, path string, op Operation) map[string]any {
+	operation := map[string]any{
+		"operationId": operationID(method, path),
+	}
+
+	if op.Summary != "" {
+		operation["summary"] = op.Summary
+	}
+	if op.Description != "" {
+		operation["description"] = op.Description
+	}
+	if len(op.Tags) > 0 {
+		operation["tags"] = op.Tags
+	}
+
+	switch op.Security {
+	case SecurityBearer:
+		operation["security"] = []map[string][]string{{"bearerAuth": {}}, {"cookieAuth": {}}}
+	case SecurityOptionalBearer:
+		operation["security"] = []map[string][]string{{"bearerAuth": {}}, {"cookieAuth": {}}, {}}
+	case SecurityAPIKey:
+		operation["security"] = []map[string][]string{{"apiKeyAuth": {}}}
+	}
+
+	parameters := []map[string]any{}
+	for _, match := range pathParameterPattern.FindAllStringSubmatch(path, -1) {
+		paramType := "string"
+		if match[1] == "id" || strings.HasSuffix(match[1], "_id") {
+			paramType = "integer"
+		}
+		parameters = append(parameters, map[string]any{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   Schema{"type": paramType},
+		})
+	}
+	for _, param := range op.Query {
+		paramType := param.Type
+		if paramType == "" {
+			paramType = "string"
+		}
+		parameters = append(parameters, map[string]any{
+			"name":        param.Name,
+			"in":          "query",
+			"required":    param.Required,
+			"description": param.Description,
+			"schema":      Schema{"type": paramType},
+		})
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if op.Request != nil {
+		requestContentType := op.RequestContentType
+		if requestContentType == "" {
+			requestContentType = "application/json"
+		}
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				requestContentType: map[string]any{"schema": g.schemaFor(op.Request, true)},
+			},
+		}
+	}
+
+	errorContentType := op.ErrorContentType
+	if errorContentType == "" {
+		errorContentType = "application/json"
+	}
+
+	responses := map[string]any{}
+	statuses := make([]int, 0, len(op.Responses))
+	for status := range op.Responses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+
+	for _, status := range statuses {
+		response := map[string]any{"description": http.StatusText(status)}
+		if body := op.Responses[status]; body != nil {
+			contentType := "application/json"
+			if status >= 400 {
+				contentType = errorContentType
+			}
+			response["content"] = map[string]any{
+				contentType: map[string]any{"schema": g.schemaFor(body, false)},
+			}
+		}
+		responses[fmt.Sprint(status)] = response
+	}
+	if len(responses) == 0 {
+		responses["default"] = map[string]any{"description": "Undocumented"}
+	}
+	operation["responses"] = responses
+
+	return operation
+}
+
+// E.g. "GET /api/chirps/{id}" becomes "getApiChirpsId"
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '_' || r == '.' || r == '-'
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+func HandlerSpec(spec func() map[string]any) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		resp, err := json.MarshalIndent(spec(), "", "  ")
+		if err != nil {
+			log.Printf("Error marshalling OpenAPI spec: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}
+}
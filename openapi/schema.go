@@ -0,0 +1,219 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type Schema map[string]any
+
+// Generates JSON schemas from Go types, collecting named struct types as
+// reusable components
+type schemaGenerator struct {
+	components map[string]Schema
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{components: map[string]Schema{}}
+}
+
+// Returns a schema for v's type. Struct fields are treated as required unless
+// they have `omitempty`, or, if isRequest is true, unless they have a
+// `validate:"required"` rule
+func (g *schemaGenerator) schemaFor(v any, isRequest bool) Schema {
+	if v == nil {
+		return nil
+	}
+	return g.schemaForType(reflect.TypeOf(v), isRequest)
+}
+
+// Names are PascalCase, so generic types like
+// `page[github.com/foo/bar.chirpResponse]` become `PageChirpResponse`
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	base, typeArgs, isGeneric := strings.Cut(name, "[")
+	if !isGeneric {
+		return upperFirst(name)
+	}
+
+	name = upperFirst(base)
+	for _, typeArg := range strings.Split(strings.TrimSuffix(typeArgs, "]"), ",") {
+		parts := strings.Split(typeArg, ".")
+		name += upperFirst(strings.TrimLeft(parts[len(parts)-1], "*[]"))
+	}
+	return name
+}
+
+func upperFirst(s string) string {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return s
+	}
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func (g *schemaGenerator) schemaForType(t reflect.Type, isRequest bool) Schema {
+	if t.Kind() == reflect.Pointer {
+		return nullable(g.schemaForType(t.Elem(), isRequest))
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return Schema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": g.schemaForType(t.Elem(), isRequest)}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": g.schemaForType(t.Elem(), isRequest)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.objectSchema(t, isRequest)
+		}
+
+		name := componentName(t)
+		if _, exists := g.components[name]; !exists {
+			// Reserve the name first, in case the type is recursive
+			g.components[name] = Schema{}
+			g.components[name] = g.objectSchema(t, isRequest)
+		}
+		return Schema{"$ref": "#/components/schemas/" + name}
+	default:
+		return Schema{}
+	}
+}
+
+// Pointers are marshalled as null when they're nil
+func nullable(schema Schema) Schema {
+	switch schemaType := schema["type"].(type) {
+	case string:
+		schema["type"] = []string{schemaType, "null"}
+		return schema
+	case nil:
+		if _, isRef := schema["$ref"]; isRef {
+			return Schema{"anyOf": []Schema{schema, {"type": "null"}}}
+		}
+	}
+	return schema
+}
+
+func (g *schemaGenerator) objectSchema(t reflect.Type, isRequest bool) Schema {
+	properties := Schema{}
+	required := []string{}
+	g.addFields(t, isRequest, properties, &required)
+
+	schema := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, isRequest bool, properties Schema, required *[]string) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(jsonTag, ",")
+
+		// Fields of embedded structs get promoted, like encoding/json does
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.addFields(embedded, isRequest, properties, required)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := g.schemaForType(field.Type, isRequest)
+		rules := field.Tag.Get("validate")
+		applyValidationRules(schema, rules)
+		// E.g. `format:"binary"` for file uploads
+		if format := field.Tag.Get("format"); format != "" {
+			schema["format"] = format
+		}
+		properties[name] = schema
+
+		omitEmpty := strings.Contains(options, "omitempty")
+		if isRequest && hasRule(rules, "required") || !isRequest && !omitEmpty {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func hasRule(rules, rule string) bool {
+	for _, r := range strings.Split(rules, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func applyValidationRules(schema Schema, rules string) {
+	for _, rule := range strings.Split(rules, ",") {
+		ruleName, arg, _ := strings.Cut(rule, "=")
+		limit, _ := strconv.Atoi(arg)
+
+		switch ruleName {
+		case "email":
+			schema["format"] = "email"
+		case "min":
+			schema[limitKeyword(schema, "min")] = limit
+		case "max":
+			schema[limitKeyword(schema, "max")] = limit
+		case "maxbytes":
+			// JSON Schema can't limit bytes, but N bytes are at most N characters
+			schema["maxLength"] = limit
+		}
+	}
+}
+
+func limitKeyword(schema Schema, limit string) string {
+	schemaType := schema["type"]
+	if types, isNullable := schemaType.([]string); isNullable {
+		schemaType = types[0]
+	}
+
+	switch schemaType {
+	case "string":
+		return limit + "Length"
+	case "array":
+		return limit + "Items"
+	case "integer", "number":
+		if limit == "min" {
+			return "minimum"
+		}
+		return "maximum"
+	default:
+		return limit + "Properties"
+	}
+}
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mawkler/go-web-server/api"
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/events"
+	"github.com/mawkler/go-web-server/mail"
+	"github.com/mawkler/go-web-server/media"
+	"github.com/mawkler/go-web-server/notifications"
+	"github.com/mawkler/go-web-server/openapi"
+	"github.com/mawkler/go-web-server/stream"
+)
+
+// Documented routes that the test doesn't call, since they never finish
+var untestedOperations = map[string]string{
+	"GET /api/stream":    "long-lived event stream",
+	"GET /api/stream/ws": "long-lived WebSocket",
+}
+
+const testPolkaAPIKey = "polka-key"
+
+type recordingMailer struct {
+	messages []mail.Message
+}
+
+func (m *recordingMailer) Send(msg mail.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Calls routes and checks that their responses match the OpenAPI spec
+type specTest struct {
+	t       *testing.T
+	db      *database.DB
+	mailer  *recordingMailer
+	router  *openapi.Router
+	handler http.Handler
+	spec    map[string]any
+	// Operations that have been called, keyed by their documented pattern
+	called map[string]bool
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newSpecTest(t *testing.T) *specTest {
+	dir := t.TempDir()
+	db := database.New(filepath.Join(dir, "database.json"))
+
+	filter, err := loadModerationFilter(db, nil)
+	if err != nil {
+		t.Fatalf("failed to load moderation filter: %s", err)
+	}
+
+	eventBus := events.NewBus()
+	notifications.Subscribe(eventBus, db)
+	broker := stream.NewBroker(streamReplayBufferSize, streamQueueSize)
+	stream.Forward(eventBus, broker)
+
+	chirpLimits := api.ChirpLimits{MaxLength: 140, RedMaxLength: 280, EditWindow: 15 * time.Minute, RedEditWindow: time.Hour}
+	mailer := &recordingMailer{}
+	blobs := media.NewLocalStore(filepath.Join(dir, "uploads"))
+	cfg := api.NewAPIConfig(db, api.Options{
+		JWTSecret:                  "jwt-secret",
+		PolkaAPIKey:                testPolkaAPIKey,
+		Mailer:                     mailer,
+		BaseURL:                    "http://localhost:8080",
+		Moderation:                 filter,
+		ChirpLimits:                chirpLimits,
+		Events:                     eventBus,
+		Broker:                     broker,
+		Blobs:                      blobs,
+		AccountDeletionGracePeriod: time.Hour,
+		ReportHideThreshold:        3,
+	})
+
+	router := openapi.NewRouter()
+	registerRoutes(router, &cfg)
+
+	s := &specTest{
+		t:       t,
+		db:      db,
+		mailer:  mailer,
+		router:  router,
+		handler: api.MiddlewareRequestID(router),
+		called:  map[string]bool{},
+	}
+
+	res := s.call("GET", "/api/openapi.json", "", nil)
+	s.expect(res, 200)
+	if err := json.Unmarshal(res.body, &s.spec); err != nil {
+		t.Fatalf("failed to decode spec: %s", err)
+	}
+	s.check("GET /api/openapi.json", res)
+
+	return s
+}
+
+func (s *specTest) call(method, target, token string, body any) testResponse {
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("failed to encode request body: %s", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return s.send(req)
+}
+
+// Serves the request, and checks the response against the spec of the route
+// that served it
+func (s *specTest) send(req *http.Request) testResponse {
+	_, pattern := s.router.ServeMux.Handler(req)
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	res := testResponse{status: rec.Code, header: rec.Header(), body: rec.Body.Bytes()}
+
+	// The spec isn't loaded until it's been fetched
+	if s.spec != nil {
+		s.check(pattern, res)
+	}
+	return res
+}
+
+func (s *specTest) check(pattern string, res testResponse) {
+	s.t.Helper()
+
+	method, path, _ := strings.Cut(pattern, " ")
+	paths, _ := s.spec["paths"].(map[string]any)
+	pathItem, _ := paths[path].(map[string]any)
+	operation, documented := pathItem[strings.ToLower(method)].(map[string]any)
+	if !documented {
+		s.t.Errorf("%s isn't in the spec", pattern)
+		return
+	}
+	s.called[pattern] = true
+
+	responses, _ := operation["responses"].(map[string]any)
+	response, documented := responses[strconv.Itoa(res.status)].(map[string]any)
+	if !documented {
+		s.t.Errorf("%s responded with undocumented status %d: %s", pattern, res.status, res.body)
+		return
+	}
+
+	mediaType, _, _ := strings.Cut(res.header.Get("Content-Type"), ";")
+	content, hasContent := response["content"].(map[string]any)
+	if !hasContent {
+		if len(res.body) > 0 && strings.HasSuffix(mediaType, "json") {
+			s.t.Errorf("%s %d: response has an undocumented body: %s", pattern, res.status, res.body)
+		}
+		return
+	}
+
+	mediaTypeObject, documented := content[mediaType].(map[string]any)
+	if !documented {
+		s.t.Errorf("%s %d: content type %q isn't documented", pattern, res.status, mediaType)
+		return
+	}
+
+	var value any
+	if err := json.Unmarshal(res.body, &value); err != nil {
+		s.t.Errorf("%s %d: response isn't JSON: %s", pattern, res.status, err)
+		return
+	}
+
+	schema, _ := mediaTypeObject["schema"].(map[string]any)
+	for _, problem := range s.validate(schema, value, "body") {
+		s.t.Errorf("%s %d: %s", pattern, res.status, problem)
+	}
+}
+
+// Validates value against the subset of JSON Schema that the spec uses.
+// Objects with properties are treated as closed, so that undocumented fields
+// are reported
+func (s *specTest) validate(schema map[string]any, value any, at string) []string {
+	if ref, isRef := schema["$ref"].(string); isRef {
+		components, _ := s.spec["components"].(map[string]any)
+		schemas, _ := components["schemas"].(map[string]any)
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, exists := schemas[name].(map[string]any)
+		if !exists {
+			return []string{fmt.Sprintf("%s: unknown schema %s", at, ref)}
+		}
+		return s.validate(resolved, value, at)
+	}
+
+	if branches, isAnyOf := schema["anyOf"].([]any); isAnyOf {
+		for _, branch := range branches {
+			branchSchema, _ := branch.(map[string]any)
+			if len(s.validate(branchSchema, value, at)) == 0 {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%s: %s matches none of the schemas", at, describe(value))}
+	}
+
+	if types := schemaTypes(schema); len(types) > 0 && !matchesAnyType(value, types) {
+		return []string{fmt.Sprintf("%s: got %s, want %s", at, describe(value), strings.Join(types, " or "))}
+	}
+
+	problems := []string{}
+	switch value := value.(type) {
+	case map[string]any:
+		properties, hasProperties := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, exists := value[name.(string)]; !exists {
+				problems = append(problems, fmt.Sprintf("%s: missing required field %q", at, name))
+			}
+		}
+
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		additional, hasAdditional := schema["additionalProperties"].(map[string]any)
+		for _, name := range names {
+			fieldAt := at + "." + name
+			if property, exists := properties[name].(map[string]any); exists {
+				problems = append(problems, s.validate(property, value[name], fieldAt)...)
+			} else if hasAdditional {
+				problems = append(problems, s.validate(additional, value[name], fieldAt)...)
+			} else if hasProperties {
+				problems = append(problems, fmt.Sprintf("%s: undocumented field", fieldAt))
+			}
+		}
+	case []any:
+		if items, hasItems := schema["items"].(map[string]any); hasItems {
+			for i, item := range value {
+				problems = append(problems, s.validate(items, item, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case string:
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q isn't a date-time", at, value))
+			}
+		}
+	}
+
+	return problems
+}
+
+func schemaTypes(schema map[string]any) []string {
+	switch schemaType := schema["type"].(type) {
+	case string:
+		return []string{schemaType}
+	case []any:
+		types := []string{}
+		for _, t := range schemaType {
+			types = append(types, t.(string))
+		}
+		return types
+	default:
+		return nil
+	}
+}
+
+func matchesAnyType(value any, types []string) bool {
+	for _, t := range types {
+		switch value := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case float64:
+			if t == "number" || t == "integer" && value == math.Trunc(value) {
+				return true
+			}
+		case map[string]any:
+			if t == "object" {
+				return true
+			}
+		case []any:
+			if t == "array" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func describe(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		return fmt.Sprintf("%T %v", value, value)
+	}
+}
+
+func (s *specTest) expect(res testResponse, status int) {
+	s.t.Helper()
+	if res.status != status {
+		s.t.Fatalf("got status %d, want %d: %s", res.status, status, res.body)
+	}
+}
+
+// Decodes the response and returns the value at the path of keys, e.g. "id"
+// or "items.0.id"
+func (s *specTest) field(res testResponse, path string) any {
+	s.t.Helper()
+
+	var value any
+	if err := json.Unmarshal(res.body, &value); err != nil {
+		s.t.Fatalf("failed to decode response: %s", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i >= len(v) {
+				s.t.Fatalf("no %s in %s", path, res.body)
+			}
+			value = v[i]
+		default:
+			s.t.Fatalf("no %s in %s", path, res.body)
+		}
+	}
+	return value
+}
+
+func (s *specTest) id(res testResponse, path string) int {
+	s.t.Helper()
+	id, ok := s.field(res, path).(float64)
+	if !ok {
+		s.t.Fatalf("%s isn't a number in %s", path, res.body)
+	}
+	return int(id)
+}
+
+func (s *specTest) str(res testResponse, path string) string {
+	s.t.Helper()
+	value, ok := s.field(res, path).(string)
+	if !ok {
+		s.t.Fatalf("%s isn't a string in %s", path, res.body)
+	}
+	return value
+}
+
+// Creates a user and returns their ID and access token
+func (s *specTest) user(name string) (int, string) {
+	s.t.Helper()
+	credentials := map[string]string{"email": name + "@example.com", "password": "password"}
+	s.expect(s.call("POST", "/api/users", "", credentials), 201)
+
+	res := s.call("POST", "/api/login", "", credentials)
+	s.expect(res, 200)
+	return s.id(res, "id"), s.str(res, "token")
+}
+
+func (s *specTest) upload(token string) testResponse {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	imageData := &bytes.Buffer{}
+	if err := png.Encode(imageData, img); err != nil {
+		s.t.Fatalf("failed to encode image: %s", err)
+	}
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, _ := form.CreateFormFile("file", "image.png")
+	part.Write(imageData.Bytes())
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/api/media", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return s.send(req)
+}
+
+func (s *specTest) webhook(apiKey string, body any) testResponse {
+	encoded, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/api/polka/webhooks", bytes.NewReader(encoded))
+	if apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+apiKey)
+	}
+	return s.send(req)
+}
+
+var magicLinkTokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+func (s *specTest) lastMagicLinkToken() string {
+	s.t.Helper()
+	if len(s.mailer.messages) == 0 {
+		s.t.Fatal("no mail was sent")
+	}
+
+	match := magicLinkTokenPattern.FindStringSubmatch(s.mailer.messages[len(s.mailer.messages)-1].Body)
+	if match == nil {
+		s.t.Fatal("mail has no login link")
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		s.t.Fatalf("invalid login link token: %s", err)
+	}
+	return token
+}
+
+func TestResponsesMatchOpenAPISpec(t *testing.T) {
+	s := newSpecTest(t)
+
+	// Health, metrics and documentation
+	s.expect(s.call("GET", "/api/healthz", "", nil), 200)
+	s.expect(s.call("GET", "/admin/metrics", "", nil), 200)
+	s.expect(s.call("GET", "/api/reset", "", nil), 200)
+	s.expect(s.call("GET", "/api/docs", "", nil), 200)
+
+	// Users and authentication
+	aliceID, alice := s.user("alice")
+	bobID, bob := s.user("bob")
+	carolID, carol := s.user("carol")
+	if err := s.db.SetUserRole(aliceID, database.RoleAdmin); err != nil {
+		t.Fatalf("failed to make alice an admin: %s", err)
+	}
+	s.expect(s.call("POST", "/api/users", "", map[string]string{"email": "alice@example.com", "password": "password"}), 409)
+	s.expect(s.call("POST", "/api/users", "", map[string]string{"email": "not an email"}), 400)
+	s.expect(s.call("POST", "/api/login", "", map[string]string{"email": "alice@example.com", "password": "wrong"}), 401)
+	s.expect(s.call("GET", "/api/me", alice, nil), 200)
+	s.expect(s.call("GET", "/api/me", "", nil), 401)
+
+	session := s.call("POST", "/api/login", "", map[string]string{"email": "bob@example.com", "password": "password"})
+	s.expect(session, 200)
+	refreshToken := s.str(session, "refresh_token")
+	s.expect(s.call("GET", "/api/me", refreshToken, nil), 401)
+	s.expect(s.call("POST", "/api/refresh", refreshToken, nil), 200)
+	s.expect(s.call("POST", "/api/revoke", refreshToken, nil), 204)
+	s.expect(s.call("POST", "/api/refresh", refreshToken, nil), 401)
+
+	s.expect(s.call("POST", "/api/login/magic", "", map[string]string{"email": "dave@example.com"}), 202)
+	rawMagicToken := s.lastMagicLinkToken()
+	magicToken := url.QueryEscape(rawMagicToken)
+	s.expect(s.call("GET", "/api/login/magic/verify?token="+magicToken, "", nil), 200)
+	dave := s.call("POST", "/api/login/magic/verify?token="+magicToken, "", nil)
+	s.expect(dave, 200)
+	daveID, daveToken := s.id(dave, "id"), s.str(dave, "token")
+	s.expect(s.call("POST", "/api/login/magic/verify?token="+magicToken, "", nil), 401)
+
+	s.expect(s.call("PATCH", "/api/users", bob, map[string]string{"handle": "bobby", "bio": "Hi"}), 200)
+	s.expect(s.call("PUT", "/api/users", bob, map[string]string{"display_name": "Bob"}), 200)
+	s.expect(s.call("PATCH", "/api/users", bob, map[string]string{"email": "alice@example.com"}), 409)
+	s.expect(s.call("GET", "/api/users", "", nil), 200)
+	s.expect(s.call("GET", "/api/users", alice, nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/api/users/%d", bobID), "", nil), 200)
+	s.expect(s.call("GET", "/api/users/999", "", nil), 404)
+	s.expect(s.call("GET", "/api/handles/bobby", "", nil), 200)
+
+	// Chirps and media
+	s.expect(s.call("POST", "/api/validate_chirp", "", map[string]string{"body": "Hello"}), 200)
+	s.expect(s.call("POST", "/api/validate_chirp", "", map[string]string{"body": strings.Repeat("a", 141)}), 400)
+
+	upload := s.upload(alice)
+	s.expect(upload, 201)
+	mediaID := s.id(upload, "id")
+	s.expect(s.call("GET", fmt.Sprintf("/api/media/%d", mediaID), "", nil), 200)
+
+	first := s.call("POST", "/api/chirps", alice, map[string]any{"body": "Hello #go @bobby", "attachment_ids": []int{mediaID}})
+	s.expect(first, 201)
+	chirpID := s.id(first, "id")
+	reply := s.call("POST", "/api/chirps", bob, map[string]any{"body": "Hi alice", "in_reply_to_id": chirpID})
+	s.expect(reply, 201)
+	carolsChirp := s.call("POST", "/api/chirps", carol, map[string]any{"body": "Carol here #go"})
+	s.expect(carolsChirp, 201)
+	carolsChirpID := s.id(carolsChirp, "id")
+	s.expect(s.call("POST", "/api/chirps", "", map[string]any{"body": "Anonymous"}), 401)
+
+	s.expect(s.call("GET", "/api/chirps", "", nil), 200)
+	s.expect(s.call("GET", "/api/chirps?limit=1", alice, nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/api/chirps/%d", chirpID), "", nil), 200)
+	s.expect(s.call("GET", "/api/chirps/999", "", nil), 404)
+	s.expect(s.call("PUT", fmt.Sprintf("/api/chirps/%d", chirpID), alice, map[string]string{"body": "Hello again #go"}), 200)
+	s.expect(s.call("PATCH", fmt.Sprintf("/api/chirps/%d", chirpID), alice, map[string]string{"body": "Hello once more #go"}), 200)
+	s.expect(s.call("PATCH", fmt.Sprintf("/api/chirps/%d", chirpID), bob, map[string]string{"body": "Not mine"}), 403)
+	s.expect(s.call("GET", fmt.Sprintf("/api/chirps/%d/history", chirpID), "", nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/api/chirps/%d/thread", chirpID), "", nil), 200)
+	s.expect(s.call("GET", "/api/search/chirps?q=hello", "", nil), 200)
+	searchResults := s.call("GET", "/api/search/chirps?q=hello", bob, nil)
+	s.expect(searchResults, 200)
+	if likedByMe := s.field(searchResults, "items.0.liked_by_me"); likedByMe != false {
+		t.Errorf("got liked_by_me %v in search results, want false", likedByMe)
+	}
+	s.expect(s.call("GET", "/api/search/chirps", "", nil), 400)
+	s.expect(s.call("GET", "/api/hashtags/go/chirps", "", nil), 200)
+	s.expect(s.call("GET", "/api/hashtags/trending", "", nil), 200)
+
+	// Likes and rechirps
+	s.expect(s.call("POST", fmt.Sprintf("/api/chirps/%d/like", chirpID), bob, nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/api/users/%d/likes", bobID), "", nil), 200)
+	s.expect(s.call("DELETE", fmt.Sprintf("/api/chirps/%d/like", chirpID), bob, nil), 200)
+	s.expect(s.call("POST", fmt.Sprintf("/api/chirps/%d/rechirp", chirpID), bob, nil), 200)
+	s.expect(s.call("DELETE", fmt.Sprintf("/api/chirps/%d/rechirp", chirpID), bob, nil), 200)
+
+	// Follows
+	s.expect(s.call("POST", fmt.Sprintf("/api/users/%d/follow", aliceID), bob, nil), 204)
+	s.expect(s.call("GET", fmt.Sprintf("/api/users/%d/followers", aliceID), "", nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/api/users/%d/following", bobID), "", nil), 200)
+	s.expect(s.call("GET", "/api/timeline", bob, nil), 200)
+	s.expect(s.call("DELETE", fmt.Sprintf("/api/users/%d/follow", aliceID), bob, nil), 204)
+
+	// Notifications
+	notificationList := s.call("GET", "/api/notifications", alice, nil)
+	s.expect(notificationList, 200)
+	notificationID := s.id(notificationList, "items.0.id")
+	s.expect(s.call("POST", fmt.Sprintf("/api/notifications/%d/read", notificationID), alice, nil), 200)
+	s.expect(s.call("POST", "/api/notifications/read", alice, nil), 204)
+	s.expect(s.call("GET", "/api/notifications/preferences", alice, nil), 200)
+	s.expect(s.call("PATCH", "/api/notifications/preferences", alice, map[string]bool{"follow": false}), 200)
+
+	// Direct messages
+	conversation := s.call("POST", "/api/conversations", alice, map[string]any{"participant_ids": []int{bobID}})
+	s.expect(conversation, 201)
+	conversationID := s.id(conversation, "id")
+	s.expect(s.call("GET", "/api/conversations", alice, nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/api/conversations/%d", conversationID), bob, nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/api/conversations/%d", conversationID), carol, nil), 404)
+	s.expect(s.call("POST", fmt.Sprintf("/api/conversations/%d/messages", conversationID), alice, map[string]string{"body": "Hi Bob"}), 201)
+	s.expect(s.call("GET", fmt.Sprintf("/api/conversations/%d/messages", conversationID), bob, nil), 200)
+	s.expect(s.call("POST", fmt.Sprintf("/api/conversations/%d/read", conversationID), bob, nil), 204)
+	s.expect(s.call("GET", "/api/conversations/preferences", bob, nil), 200)
+	s.expect(s.call("PUT", "/api/conversations/preferences", bob, map[string]string{"allow_from": "following"}), 200)
+
+	// Blocks and mutes
+	s.expect(s.call("POST", fmt.Sprintf("/api/users/%d/block", bobID), carol, nil), 204)
+	s.expect(s.call("GET", "/api/users/me/blocks", carol, nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/api/chirps/%d/thread", chirpID), carol, nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/api/chirps/%d/thread", s.id(reply, "id")), carol, nil), 403)
+	s.expect(s.call("GET", fmt.Sprintf("/api/chirps/%d/history", s.id(reply, "id")), carol, nil), 403)
+	s.expect(s.call("DELETE", fmt.Sprintf("/api/users/%d/block", bobID), carol, nil), 204)
+	s.expect(s.call("POST", fmt.Sprintf("/api/users/%d/mute", bobID), carol, nil), 204)
+	s.expect(s.call("GET", "/api/users/me/mutes", carol, nil), 200)
+	s.expect(s.call("DELETE", fmt.Sprintf("/api/users/%d/mute", bobID), carol, nil), 204)
+
+	// Reports and moderation
+	s.expect(s.call("POST", fmt.Sprintf("/api/chirps/%d/report", carolsChirpID), bob, map[string]string{"reason": "spam"}), 201)
+	s.expect(s.call("POST", fmt.Sprintf("/api/chirps/%d/report", carolsChirpID), bob, map[string]string{"reason": "spam"}), 409)
+	s.expect(s.call("GET", "/admin/moderation/reports", bob, nil), 403)
+	s.expect(s.call("GET", "/admin/moderation/reports", alice, nil), 200)
+	s.expect(s.call("POST", fmt.Sprintf("/admin/moderation/chirps/%d/actions", carolsChirpID), alice, map[string]string{"action": "dismiss"}), 200)
+
+	s.expect(s.call("GET", "/admin/moderation/rules", alice, nil), 200)
+	s.expect(s.call("PUT", "/admin/moderation/rules/fudge", alice, map[string]string{"action": "flag"}), 200)
+	s.expect(s.call("POST", "/api/chirps", carol, map[string]any{"body": "Oh fudge"}), 201)
+	s.expect(s.call("GET", "/admin/moderation/flags", alice, nil), 200)
+	s.expect(s.call("DELETE", "/admin/moderation/rules/fudge", alice, nil), 204)
+
+	// Admin
+	s.expect(s.call("GET", "/admin/users?q=bob", alice, nil), 200)
+	s.expect(s.call("GET", fmt.Sprintf("/admin/users/%d", bobID), alice, nil), 200)
+	aliceState := s.call("GET", fmt.Sprintf("/admin/users/%d", aliceID), alice, nil)
+	s.expect(aliceState, 200)
+	if failedLogins := s.field(aliceState, "failed_logins"); failedLogins != float64(1) {
+		t.Errorf("got %v failed logins for alice, want 1", failedLogins)
+	}
+	s.expect(s.call("POST", fmt.Sprintf("/admin/users/%d/suspend", carolID), alice, map[string]string{"reason": "Testing"}), 200)
+	s.expect(s.call("GET", "/api/me", carol, nil), 403)
+	s.expect(s.call("POST", fmt.Sprintf("/admin/users/%d/reactivate", carolID), alice, nil), 200)
+	s.expect(s.call("PUT", fmt.Sprintf("/admin/users/%d/role", carolID), alice, map[string]string{"role": "moderator"}), 200)
+	s.expect(s.call("PUT", fmt.Sprintf("/admin/users/%d/role", carolID), alice, map[string]string{"role": "owner"}), 400)
+	s.expect(s.call("POST", fmt.Sprintf("/admin/users/%d/chirpy-red", bobID), alice, nil), 200)
+	s.expect(s.call("DELETE", fmt.Sprintf("/admin/users/%d/chirpy-red", bobID), alice, nil), 200)
+	impersonation := s.call("POST", fmt.Sprintf("/admin/users/%d/impersonate", bobID), alice, nil)
+	s.expect(impersonation, 200)
+	s.expect(s.call("POST", "/admin/introspect", alice, map[string]string{"token": s.str(impersonation, "token")}), 200)
+	usedMagicLink := s.call("POST", "/admin/introspect", alice, map[string]string{"token": rawMagicToken})
+	s.expect(usedMagicLink, 200)
+	if active := s.field(usedMagicLink, "active"); active != false {
+		t.Errorf("got active %v for a used magic link, want false", active)
+	}
+	s.expect(s.call("POST", fmt.Sprintf("/admin/users/%d/password-reset", daveID), alice, nil), 200)
+	s.expect(s.call("GET", "/admin/audit", alice, nil), 200)
+
+	// Subscriptions
+	s.expect(s.webhook("", map[string]any{"event": "user.upgraded", "data": map[string]int{"user_id": bobID}}), 401)
+	s.expect(s.webhook(testPolkaAPIKey, map[string]any{"event": "user.upgraded", "data": map[string]int{"user_id": bobID}}), 204)
+	s.expect(s.webhook(testPolkaAPIKey, map[string]any{"event": "user.upgraded", "data": map[string]int{"user_id": 999}}), 404)
+	s.expect(s.call("GET", "/api/users/me/subscription", bob, nil), 200)
+
+	// Deletion and export
+	s.expect(s.call("DELETE", fmt.Sprintf("/api/chirps/%d", carolsChirpID), carol, nil), 204)
+	s.expect(s.call("GET", "/api/users/me/export", alice, nil), 200)
+	s.expect(s.call("DELETE", "/api/users/me", alice, map[string]string{"password": "wrong"}), 403)
+	s.expect(s.call("DELETE", "/api/users/me", daveToken, map[string]string{"password": "password"}), 403)
+	s.expect(s.call("DELETE", "/api/users/me", carol, map[string]string{"password": "password"}), 202)
+
+	for pattern := range api.Operations {
+		if _, untested := untestedOperations[pattern]; !untested && !s.called[pattern] {
+			t.Errorf("%s is documented but wasn't called", pattern)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+type Token struct {
+	Term     string
+	Position int
+}
+
+// Diacritics that can't be removed by decomposing, since the standard library
+// doesn't do Unicode normalization
+var foldings = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ı': "i",
+	'ł': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss", 'ť': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// Lowercases and removes diacritics and combining marks
+func Normalize(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		r = unicode.ToLower(r)
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if folded, ok := foldings[r]; ok {
+			b.WriteString(folded)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+}
+
+// Splits text into words. Hashtags keep their leading `#`
+func Words(text string) []string {
+	words := []string{}
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) && !(runes[i] == '#' && i+1 < len(runes) && isWordRune(runes[i+1])) {
+			i++
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(runes) && (isWordRune(runes[i]) || runes[i] == '_' || runes[i] == '\'') {
+			i++
+		}
+		words = append(words, strings.TrimRight(string(runes[start:i]), "'"))
+	}
+
+	return words
+}
+
+// Turns text into normalized and stemmed terms. Hashtags are normalized but
+// not stemmed, so `#running` only matches `#running`
+func Analyze(text string) []Token {
+	tokens := []Token{}
+	for position, word := range Words(text) {
+		word = Normalize(word)
+		word = strings.ReplaceAll(word, "'", "")
+		if !strings.HasPrefix(word, "#") {
+			word = Stem(word)
+		}
+		tokens = append(tokens, Token{Term: word, Position: position})
+	}
+	return tokens
+}
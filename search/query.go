@@ -0,0 +1,93 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+type Query struct {
+	// Analyzed terms of words outside of phrases
+	Terms []string
+	// Analyzed terms of each "quoted phrase"
+	Phrases [][]string
+	// Authors from `from:<author>` filters
+	From []string
+	// Normalized hashtags, including the `#`
+	Hashtags []string
+}
+
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && len(q.From) == 0 && len(q.Hashtags) == 0
+}
+
+// Parses a query like `"hello world" from:5 #golang gopher`
+func ParseQuery(query string) Query {
+	q := Query{}
+
+	for _, part := range splitQuery(query) {
+		if phrase, isPhrase := strings.CutPrefix(part, `"`); isPhrase {
+			terms := []string{}
+			for _, token := range Analyze(strings.TrimSuffix(phrase, `"`)) {
+				terms = append(terms, token.Term)
+			}
+			if len(terms) == 1 {
+				q.Terms = append(q.Terms, terms[0])
+			} else if len(terms) > 1 {
+				q.Phrases = append(q.Phrases, terms)
+			}
+			continue
+		}
+
+		if author, isFrom := strings.CutPrefix(strings.ToLower(part), "from:"); isFrom {
+			if author = strings.TrimPrefix(author, "@"); author != "" {
+				q.From = append(q.From, author)
+			}
+			continue
+		}
+
+		for _, token := range Analyze(part) {
+			if strings.HasPrefix(token.Term, "#") {
+				q.Hashtags = append(q.Hashtags, token.Term)
+			} else {
+				q.Terms = append(q.Terms, token.Term)
+			}
+		}
+	}
+
+	return q
+}
+
+// Splits on whitespace, but keeps quoted phrases together
+func splitQuery(query string) []string {
+	parts := []string{}
+	var current strings.Builder
+	inQuotes := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			if inQuotes {
+				current.WriteRune(r)
+				flush()
+			} else {
+				flush()
+				current.WriteRune(r)
+			}
+			inQuotes = !inQuotes
+		case unicode.IsSpace(r) && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return parts
+}
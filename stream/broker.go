@@ -0,0 +1,206 @@
+// Package stream pushes events to connected clients, over Server-Sent Events
+// or WebSockets
+package stream
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+)
+
+const (
+	EventChirpCreated         = "chirp.created"
+	EventChirpDeleted         = "chirp.deleted"
+	EventNotificationCreated  = "notification.created"
+	EventDirectMessageCreated = "direct_message.created"
+)
+
+type Message struct {
+	ID    int64
+	Event string
+	// JSON encoded payload
+	Data json.RawMessage
+
+	// Used for filtering, and not sent to clients
+	authorID int
+	hashtags []string
+	// If set, only this user receives the message
+	recipientID int
+}
+
+// Which messages a subscriber receives. Zero values match all messages.
+// Private messages are only delivered to their recipient
+type Filter struct {
+	AuthorIDs []int
+	Hashtags  []string
+	// The authenticated subscriber, or 0 if the subscriber isn't authenticated
+	UserID int
+	// Users that the subscriber has blocked or muted, or that have blocked the
+	// subscriber, as of subscribing
+	HiddenAuthorIDs map[int]bool
+}
+
+func (f Filter) matches(message Message) bool {
+	if message.recipientID != 0 {
+		return message.recipientID == f.UserID
+	}
+	if f.HiddenAuthorIDs[message.authorID] {
+		return false
+	}
+	if len(f.AuthorIDs) > 0 && !slices.Contains(f.AuthorIDs, message.authorID) {
+		return false
+	}
+	if len(f.Hashtags) > 0 && !slices.ContainsFunc(f.Hashtags, func(hashtag string) bool {
+		return slices.Contains(message.hashtags, hashtag)
+	}) {
+		return false
+	}
+	return true
+}
+
+type Subscription struct {
+	// Closed when the subscription ends, either by unsubscribing or by falling
+	// too far behind
+	Messages <-chan Message
+	// Messages published since the last event ID that was passed to Subscribe
+	Replay []Message
+	// Whether messages since the last event ID have been dropped from the
+	// replay buffer, so the client has to reload its state
+	ReplayIncomplete bool
+
+	messages chan Message
+	filter   Filter
+	// Set when the subscriber couldn't keep up and got disconnected
+	overflowed bool
+}
+
+// Whether the subscription ended because the subscriber couldn't keep up.
+// Only meaningful once Messages has been closed
+func (sub *Subscription) Overflowed() bool {
+	return sub.overflowed
+}
+
+// Fans out published messages to subscribers. Recent messages are kept in a
+// bounded buffer, so that reconnecting subscribers can catch up on what they
+// missed.
+//
+// Publishing never blocks. A subscriber whose queue is full gets disconnected,
+// rather than slowing down everyone else, and can resume from the replay
+// buffer when it reconnects
+type Broker struct {
+	mux         sync.Mutex
+	lastID      int64
+	buffer      []Message
+	bufferSize  int
+	queueSize   int
+	subscribers map[*Subscription]struct{}
+}
+
+// bufferSize is how many messages are kept for replaying, and queueSize is how
+// many messages a subscriber can fall behind before getting disconnected
+func NewBroker(bufferSize, queueSize int) *Broker {
+	return &Broker{
+		bufferSize:  bufferSize,
+		queueSize:   queueSize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+func (b *Broker) publish(event string, data any, authorID int, hashtags []string, recipientID int) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.lastID++
+	message := Message{
+		ID:          b.lastID,
+		Event:       event,
+		Data:        encoded,
+		authorID:    authorID,
+		hashtags:    hashtags,
+		recipientID: recipientID,
+	}
+
+	b.buffer = append(b.buffer, message)
+	if len(b.buffer) > b.bufferSize {
+		b.buffer = slices.Delete(b.buffer, 0, len(b.buffer)-b.bufferSize)
+	}
+
+	for sub := range b.subscribers {
+		if !sub.filter.matches(message) {
+			continue
+		}
+
+		select {
+		case sub.messages <- message:
+		default:
+			sub.overflowed = true
+			b.remove(sub)
+		}
+	}
+
+	return nil
+}
+
+// Publishes a public message about a chirp
+func (b *Broker) PublishChirp(event string, data any, authorID int, hashtags []string) error {
+	return b.publish(event, data, authorID, hashtags, 0)
+}
+
+// Publishes a message that only the recipient receives
+func (b *Broker) PublishPrivate(event string, data any, recipientID int) error {
+	return b.publish(event, data, 0, nil, recipientID)
+}
+
+// Subscribes to messages matching the filter. If lastEventID is given, the
+// subscription includes the buffered messages published after it
+func (b *Broker) Subscribe(filter Filter, lastEventID *int64) *Subscription {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	messages := make(chan Message, b.queueSize)
+	sub := &Subscription{Messages: messages, messages: messages, filter: filter, Replay: []Message{}}
+
+	if lastEventID != nil {
+		// The buffer is complete if it still has the message right after the
+		// last one the client saw, or if nothing has been published since. IDs
+		// start over when the server restarts, so an ID from the future means
+		// that the client saw messages that are gone
+		switch {
+		case *lastEventID > b.lastID:
+			sub.ReplayIncomplete = true
+		case len(b.buffer) > 0:
+			sub.ReplayIncomplete = b.buffer[0].ID > *lastEventID+1
+		default:
+			sub.ReplayIncomplete = b.lastID > *lastEventID
+		}
+
+		for _, message := range b.buffer {
+			if message.ID > *lastEventID && filter.matches(message) {
+				sub.Replay = append(sub.Replay, message)
+			}
+		}
+	}
+
+	b.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.remove(sub)
+}
+
+func (b *Broker) remove(sub *Subscription) {
+	if _, exists := b.subscribers[sub]; exists {
+		delete(b.subscribers, sub)
+		close(sub.messages)
+	}
+}
@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"log"
+
+	"github.com/mawkler/go-web-server/database"
+	"github.com/mawkler/go-web-server/entities"
+	"github.com/mawkler/go-web-server/events"
+)
+
+type chirpDeletedData struct {
+	ID int `json:"id"`
+}
+
+func chirpHashtags(chirp database.Chirp) []string {
+	hashtags := []string{}
+	for _, entity := range chirp.Entities {
+		if entity.Type == entities.TypeHashtag {
+			hashtags = append(hashtags, entity.Text)
+		}
+	}
+	return hashtags
+}
+
+// Publishes the events that clients can stream to the broker
+func Forward(bus *events.Bus, broker *Broker) {
+	logError := func(err error) {
+		if err != nil {
+			log.Printf("failed to publish to stream: %s", err)
+		}
+	}
+
+	bus.Subscribe(events.TypeChirpCreated, func(event events.Event) {
+		chirp := event.(events.ChirpCreated).Chirp
+		logError(broker.PublishChirp(EventChirpCreated, chirp, chirp.AuthorID, chirpHashtags(chirp)))
+	})
+
+	bus.Subscribe(events.TypeChirpDeleted, func(event events.Event) {
+		chirp := event.(events.ChirpDeleted).Chirp
+		data := chirpDeletedData{ID: chirp.ID}
+		logError(broker.PublishChirp(EventChirpDeleted, data, chirp.AuthorID, chirpHashtags(chirp)))
+	})
+
+	bus.Subscribe(events.TypeNotificationCreated, func(event events.Event) {
+		notification := event.(events.NotificationCreated).Notification
+		logError(broker.PublishPrivate(EventNotificationCreated, notification, notification.UserID))
+	})
+
+	// Participants get their own messages too, so that their other clients
+	// stay in sync
+	bus.Subscribe(events.TypeDirectMessageCreated, func(event events.Event) {
+		created := event.(events.DirectMessageCreated)
+		for _, id := range created.ParticipantIDs {
+			logError(broker.PublishPrivate(EventDirectMessageCreated, created.Message, id))
+		}
+	})
+}
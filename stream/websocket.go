@@ -0,0 +1,223 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Appended to the client's key to compute the handshake response, as per RFC
+// 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opcodeContinuation = 0x0
+	opcodeText         = 0x1
+	opcodeBinary       = 0x2
+	opcodeClose        = 0x8
+	opcodePing         = 0x9
+	opcodePong         = 0xA
+)
+
+// Clients only send control frames, so anything bigger than this is rejected
+const maxClientFrameSize = 4096
+
+const websocketWriteTimeout = 10 * time.Second
+
+var ErrNotWebSocket = errors.New("request is not a WebSocket upgrade")
+
+// A server side WebSocket connection that only supports what the stream
+// needs: sending text messages, and answering pings and close frames
+type WebSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// Frames can be written both when sending messages and when answering
+	// control frames
+	writeMux sync.Mutex
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+// Performs the opening handshake and takes over the connection
+func Upgrade(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	if r.Method != http.MethodGet || !IsWebSocketUpgrade(r) {
+		return nil, ErrNotWebSocket
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported WebSocket version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, errors.New("invalid Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection can't be taken over")
+	}
+
+	conn, readWriter, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take over connection: %s", err)
+	}
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n"
+
+	conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write handshake: %s", err)
+	}
+
+	return &WebSocket{conn: conn, reader: readWriter.Reader}, nil
+}
+
+// Server frames are never masked or fragmented
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMux.Lock()
+	defer ws.writeMux.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	ws.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := ws.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (ws *WebSocket) WriteText(payload []byte) error {
+	return ws.writeFrame(opcodeText, payload)
+}
+
+func (ws *WebSocket) Ping() error {
+	return ws.writeFrame(opcodePing, nil)
+}
+
+// Sends a close frame with the status code and closes the connection
+func (ws *WebSocket) Close(code uint16, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	ws.writeFrame(opcodeClose, append(payload, reason...))
+	return ws.conn.Close()
+}
+
+type frame struct {
+	opcode  byte
+	payload []byte
+}
+
+func (ws *WebSocket) readFrame() (frame, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(ws.reader, header); err != nil {
+		return frame{}, err
+	}
+
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(ws.reader, extended); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(ws.reader, extended); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+
+	if !masked {
+		return frame{}, errors.New("client frames must be masked")
+	}
+	if length > maxClientFrameSize {
+		return frame{}, errors.New("frame is too large")
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(ws.reader, mask); err != nil {
+		return frame{}, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return frame{}, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return frame{opcode: opcode, payload: payload}, nil
+}
+
+// Reads frames until the client closes the connection or it breaks, answering
+// pings and close frames. Messages from the client are ignored, since the
+// stream only goes one way
+func (ws *WebSocket) ReadUntilClosed() error {
+	for {
+		f, err := ws.readFrame()
+		if err != nil {
+			ws.conn.Close()
+			return err
+		}
+
+		switch f.opcode {
+		case opcodeClose:
+			ws.writeFrame(opcodeClose, f.payload)
+			return ws.conn.Close()
+		case opcodePing:
+			if err := ws.writeFrame(opcodePong, f.payload); err != nil {
+				ws.conn.Close()
+				return err
+			}
+		case opcodePong, opcodeText, opcodeBinary, opcodeContinuation:
+		default:
+			ws.Close(1002, "unknown opcode")
+			return fmt.Errorf("unknown opcode %d", f.opcode)
+		}
+	}
+}